@@ -0,0 +1,635 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// abstract interpretation of IMP
+//
+// the abstract interpreter runs a program over abstract states instead of ValStates: every variable is mapped to
+// the kinds its value can have, an abstract value of a Domain for its integer values and a set of its booleans.
+// The domain is pluggable (intervals, signs, parities, constants or a product of them), the engine is the same:
+// conditions of if and while statements refine the states of the branches (e.g. x<10 restricts x to the values
+// below 10), nested scopes are handled like ValState.update does, and loops are iterated until their head state
+// is stable, using widening (after a few iterations) so this always terminates, followed by a few narrowing
+// iterations. Integer overflow is not taken into account.
+
+// an abstract value of a Domain, it describes a non-empty set of integers
+type absNum interface {
+	String() string
+}
+
+// an abstract domain for the integer values of IMP
+// every method gets and returns abstract values of the domain itself
+type Domain interface {
+	// the abstract value of a single integer
+	constant(n int) absNum
+	join(a, b absNum) absNum
+	// returns the values a and b have in common, and false if there are none
+	meet(a, b absNum) (absNum, bool)
+	// returns an upper bound of a (the earlier value) and b, repeated widening has to become stable
+	widen(a, b absNum) absNum
+	leq(a, b absNum) bool
+	contains(a absNum, n int) bool
+
+	// the transfer functions of the operators
+	plus(a, b absNum) absNum
+	mult(a, b absNum) absNum
+	// return whether a<b (a==b) can be true and whether it can be false
+	less(a, b absNum) (canTrue, canFalse bool)
+	equal(a, b absNum) (canTrue, canFalse bool)
+	// return the values of a and b for which a<b (a==b) has the value truth, and false if there are none
+	refineLess(a, b absNum, truth bool) (absNum, absNum, bool)
+	refineEqual(a, b absNum, truth bool) (absNum, absNum, bool)
+}
+
+// a set of booleans
+type boolSet uint8
+
+const (
+	boolFalse boolSet = 1 << iota
+	boolTrue
+)
+
+// returns the set of the booleans that are possible
+func boolsFrom(canTrue, canFalse bool) boolSet {
+	var bs boolSet
+	if canTrue {
+		bs |= boolTrue
+	}
+	if canFalse {
+		bs |= boolFalse
+	}
+	return bs
+}
+
+func boolsOf(b bool) boolSet {
+	if b {
+		return boolTrue
+	}
+	return boolFalse
+}
+
+func (bs boolSet) String() string {
+	switch bs {
+	case boolTrue:
+		return "true"
+	case boolFalse:
+		return "false"
+	}
+	return "{true, false}"
+}
+
+// the abstract value of a variable or expression: its possible kinds, integers (if kinds contains kindInt)
+// and booleans (if kinds contains kindBool). The zero absVal has no possible values.
+type absVal struct {
+	kinds kindSet
+	ints  absNum
+	bools boolSet
+}
+
+var absUndefined = absVal{kinds: kindUndef}
+
+func absInt(n absNum) absVal {
+	return absVal{kinds: kindInt, ints: n}
+}
+
+func absBool(bs boolSet) absVal {
+	if bs == 0 {
+		return absVal{}
+	}
+	return absVal{kinds: kindBool, bools: bs}
+}
+
+func (v absVal) String() string {
+	var parts []string
+	if v.kinds&kindInt != 0 {
+		parts = append(parts, v.ints.String())
+	}
+	if v.kinds&kindBool != 0 {
+		parts = append(parts, v.bools.String())
+	}
+	if v.kinds&kindUndef != 0 {
+		parts = append(parts, "undefined")
+	}
+	if len(parts) == 0 {
+		return "nothing"
+	}
+	return strings.Join(parts, " or ")
+}
+
+// returns the values of v of the given kinds
+func (v absVal) restrict(ks kindSet) absVal {
+	r := v
+	r.kinds &= ks
+	if r.kinds&kindInt == 0 {
+		r.ints = nil
+	}
+	if r.kinds&kindBool == 0 {
+		r.bools = 0
+	}
+	return r
+}
+
+// an abstract state maps variables to abstract values, nil is the state of unreachable program points
+type absState map[string]absVal
+
+func (s absState) copy() absState {
+	if s == nil {
+		return nil
+	}
+	c := make(absState)
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// returns the abstract value of a variable, undeclared variables are undefined
+func (s absState) get(x string) absVal {
+	if v, ok := s[x]; ok {
+		return v
+	}
+	return absUndefined
+}
+
+func (s absState) String() string {
+	if s == nil {
+		return "unreachable"
+	}
+	var names []string
+	for k := range s {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, k := range names {
+		parts = append(parts, k+": "+s[k].String())
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// the number of loop iterations before widening, and of narrowing iterations afterwards
+const (
+	widenDelay       = 3
+	narrowIterations = 2
+)
+
+type absInterp struct {
+	d Domain
+	// the abstract state before each statement (for while statements: whenever the condition is evaluated), by path
+	points map[string]absState
+	// whether states are recorded, they aren't while a loop is iterated to its fixpoint
+	recording bool
+}
+
+// analyzes a program with a domain, returns the abstract states before every statement and at the end of the program
+func analyze(prg Prog, d Domain) (map[string]absState, absState) {
+	a := &absInterp{d: d, points: make(map[string]absState), recording: true}
+	end := a.stmt(prg, Path{}, make(absState))
+	return a.points, end
+}
+
+func (a *absInterp) join(v, w absVal) absVal {
+	j := absVal{kinds: v.kinds | w.kinds, bools: v.bools | w.bools}
+	switch {
+	case v.kinds&w.kinds&kindInt != 0:
+		j.ints = a.d.join(v.ints, w.ints)
+	case v.kinds&kindInt != 0:
+		j.ints = v.ints
+	default:
+		j.ints = w.ints
+	}
+	return j
+}
+
+// returns the values of v that w contains as well
+func (a *absInterp) meet(v, w absVal) absVal {
+	m := absVal{kinds: v.kinds & w.kinds, bools: v.bools & w.bools}
+	if m.kinds&kindInt != 0 {
+		n, ok := a.d.meet(v.ints, w.ints)
+		if ok {
+			m.ints = n
+		} else {
+			m.kinds &^= kindInt
+		}
+	}
+	if m.bools == 0 {
+		m.kinds &^= kindBool
+	}
+	return m
+}
+
+func (a *absInterp) widen(v, w absVal) absVal {
+	r := a.join(v, w)
+	if v.kinds&w.kinds&kindInt != 0 {
+		r.ints = a.d.widen(v.ints, w.ints)
+	}
+	return r
+}
+
+func (a *absInterp) leq(v, w absVal) bool {
+	if v.kinds&^w.kinds != 0 || v.bools&^w.bools != 0 {
+		return false
+	}
+	return v.kinds&kindInt == 0 || a.d.leq(v.ints, w.ints)
+}
+
+// returns whether an abstract value contains a value
+func (a *absInterp) contains(v absVal, x Val) bool {
+	switch x.flag {
+	case ValueInt:
+		return v.kinds&kindInt != 0 && a.d.contains(v.ints, x.valI)
+	case ValueBool:
+		return v.kinds&kindBool != 0 && v.bools&boolsOf(x.valB) != 0
+	}
+	return v.kinds&kindUndef != 0
+}
+
+func (a *absInterp) joinStates(s, o absState) absState {
+	if s == nil {
+		return o.copy()
+	}
+	j := s.copy()
+	for k, v := range o {
+		if old, ok := j[k]; ok {
+			j[k] = a.join(old, v)
+		} else {
+			j[k] = v
+		}
+	}
+	return j
+}
+
+func (a *absInterp) widenStates(s, o absState) absState {
+	if s == nil {
+		return o.copy()
+	}
+	w := a.joinStates(s, o)
+	for k, v := range o {
+		if old, ok := s[k]; ok {
+			w[k] = a.widen(old, v)
+		}
+	}
+	return w
+}
+
+func (a *absInterp) leqStates(s, o absState) bool {
+	if s == nil {
+		return true
+	}
+	if o == nil {
+		return false
+	}
+	for k, v := range s {
+		if ov, ok := o[k]; !ok || !a.leq(v, ov) {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the abstract value of an expression
+func (a *absInterp) eval(e Exp, s absState) absVal {
+	switch e := e.(type) {
+	case Num:
+		return absInt(a.d.constant(int(e)))
+	case Bool:
+		return absBool(boolsOf(bool(e)))
+	case Var:
+		return s.get(string(e))
+	case Group:
+		return a.eval(e[0], s)
+	case Negation:
+		v := a.eval(e[0], s)
+		r := absBool(v.bools>>1 | v.bools<<1&boolTrue)
+		if v.kinds&^kindBool != 0 {
+			r.kinds |= kindUndef
+		}
+		return r
+	case Plus:
+		return a.intOp(a.eval(e[0], s), a.eval(e[1], s), func(x, y absNum) absVal { return absInt(a.d.plus(x, y)) })
+	case Mult:
+		return a.intOp(a.eval(e[0], s), a.eval(e[1], s), func(x, y absNum) absVal { return absInt(a.d.mult(x, y)) })
+	case Lesser:
+		return a.intOp(a.eval(e[0], s), a.eval(e[1], s), func(x, y absNum) absVal { return absBool(boolsFrom(a.d.less(x, y))) })
+	case Equal:
+		l, r := a.eval(e[0], s), a.eval(e[1], s)
+		var bs boolSet
+		if l.kinds&r.kinds&kindInt != 0 {
+			bs |= boolsFrom(a.d.equal(l.ints, r.ints))
+		}
+		if l.kinds&r.kinds&kindBool != 0 {
+			bs |= boolsFrom(l.bools&r.bools != 0, l.bools|r.bools == boolTrue|boolFalse)
+		}
+		res := absBool(bs)
+		// values of different kinds, or undefined ones, are never equal
+		if (l.kinds|r.kinds)&kindUndef != 0 || (l.kinds|r.kinds)&(kindInt|kindBool) == kindInt|kindBool {
+			res.kinds |= kindUndef
+		}
+		return res
+	case Or:
+		return absLogic(a.eval(e[0], s), a.eval(e[1], s), boolTrue)
+	case And:
+		return absLogic(a.eval(e[0], s), a.eval(e[1], s), boolFalse)
+	}
+	return absVal{kinds: kindBool | kindUndef, bools: boolTrue | boolFalse}
+}
+
+// returns the value of an operator on integers, which is undefined unless both operands are integers
+func (a *absInterp) intOp(l, r absVal, op func(x, y absNum) absVal) absVal {
+	var res absVal
+	if l.kinds&r.kinds&kindInt != 0 {
+		res = op(l.ints, r.ints)
+	}
+	if (l.kinds|r.kinds)&^kindInt != 0 {
+		res.kinds |= kindUndef
+	}
+	return res
+}
+
+// returns the value of an and (decides is false) or an or (decides is true): the left operand decides on its own
+// if it has the value decides, otherwise both operands have to be booleans
+func absLogic(l, r absVal, decides boolSet) absVal {
+	res := r.restrict(kindBool)
+	if l.bools&^decides == 0 {
+		res = absVal{}
+	} else if r.kinds&^kindBool != 0 {
+		res.kinds |= kindUndef
+	}
+	if l.bools&decides != 0 {
+		res.kinds |= kindBool
+		res.bools |= decides
+	}
+	if l.kinds&^kindBool != 0 {
+		res.kinds |= kindUndef
+	}
+	return res
+}
+
+// returns the part of a state in which a condition evaluates to the boolean truth (nil if there is none)
+func (a *absInterp) refine(s absState, cond Exp, truth bool) absState {
+	if s == nil || a.eval(cond, s).bools&boolsOf(truth) == 0 {
+		return nil
+	}
+	switch cond := cond.(type) {
+	case Group:
+		return a.refine(s, cond[0], truth)
+	case Negation:
+		return a.refine(s, cond[0], !truth)
+	case Var:
+		return a.restrictVar(s, cond, absBool(boolsOf(truth)))
+	case And:
+		if truth {
+			return a.refine(a.refine(s, cond[0], true), cond[1], true)
+		}
+		return a.joinStates(a.refine(s, cond[0], false), a.refine(a.refine(s, cond[0], true), cond[1], false))
+	case Or:
+		if truth {
+			return a.joinStates(a.refine(s, cond[0], true), a.refine(a.refine(s, cond[0], false), cond[1], true))
+		}
+		return a.refine(a.refine(s, cond[0], false), cond[1], false)
+	case Lesser:
+		// the condition only has a boolean value if both operands are integers
+		l, r := a.eval(cond[0], s), a.eval(cond[1], s)
+		x, y, ok := a.d.refineLess(l.ints, r.ints, truth)
+		if !ok {
+			return nil
+		}
+		return a.restrictVar(a.restrictVar(s, cond[0], absInt(x)), cond[1], absInt(y))
+	case Equal:
+		l, r := a.eval(cond[0], s), a.eval(cond[1], s)
+		// the condition only has a boolean value if both operands have the same kind
+		ks := l.kinds & r.kinds & (kindInt | kindBool)
+		x, y := l.restrict(ks), r.restrict(ks)
+		if ks&kindInt != 0 {
+			xi, yi, ok := a.d.refineEqual(l.ints, r.ints, truth)
+			if ok {
+				x.ints, y.ints = xi, yi
+			} else {
+				x.kinds &^= kindInt
+				y.kinds &^= kindInt
+			}
+		}
+		if ks&kindBool != 0 {
+			if truth {
+				x.bools &= r.bools
+				y.bools &= l.bools
+			} else {
+				// a single boolean differs from the other one
+				if r.bools != boolTrue|boolFalse {
+					x.bools &^= r.bools
+				}
+				if l.bools != boolTrue|boolFalse {
+					y.bools &^= l.bools
+				}
+			}
+			if x.bools == 0 || y.bools == 0 {
+				x = x.restrict(kindInt)
+				y = y.restrict(kindInt)
+			}
+		}
+		if x.kinds == 0 || y.kinds == 0 {
+			return nil
+		}
+		return a.restrictVar(a.restrictVar(s, cond[0], x), cond[1], y)
+	}
+	return s
+}
+
+// restricts an operand of a condition to the values of v, if it is a variable
+// returns nil if no value remains
+func (a *absInterp) restrictVar(s absState, e Exp, v absVal) absState {
+	for {
+		g, ok := e.(Group)
+		if !ok {
+			break
+		}
+		e = g[0]
+	}
+	x, ok := e.(Var)
+	old, declared := s[string(x)]
+	if s == nil || !ok || !declared {
+		// other operands, and undeclared variables which are undefined, aren't restricted
+		return s
+	}
+	m := a.meet(old, v)
+	if m.kinds == 0 {
+		return nil
+	}
+	r := s.copy()
+	r[string(x)] = m
+	return r
+}
+
+// returns the values a variable can have after the value new is copied over the value old,
+// which only happens if both have the same kind (like in assignments and ValState.update)
+func (a *absInterp) copyVal(old, new absVal) absVal {
+	v := new.restrict(old.kinds)
+	if ks := old.kinds | new.kinds; ks&(ks-1) != 0 {
+		// the kinds may differ, so the copy may be refused
+		v = a.join(v, old)
+	}
+	return v
+}
+
+// returns outer.update(inner) for abstract states
+func (a *absInterp) update(outer, inner absState) absState {
+	if outer == nil || inner == nil {
+		return nil
+	}
+	r := make(absState)
+	for k, o := range outer {
+		r[k] = a.copyVal(o, inner.get(k))
+	}
+	return r
+}
+
+func (a *absInterp) record(p Path, s absState) {
+	if a.recording {
+		a.points[p.String()] = a.joinStates(a.points[p.String()], s)
+	}
+}
+
+// analyzes a statement, returns the abstract state after it
+func (a *absInterp) stmt(stmt Stmt, p Path, s absState) absState {
+	switch stmt := stmt.(type) {
+	case Prog:
+		return a.stmt(stmt[0], p.child(0), s)
+	case Block:
+		return a.stmt(stmt[0], p.child(0), s)
+	case Seq:
+		return a.stmt(stmt[1], p.child(1), a.stmt(stmt[0], p.child(0), s))
+	case Decl:
+		a.record(p, s)
+		if s == nil {
+			return nil
+		}
+		r := s.copy()
+		r[stmt.lhs] = a.eval(stmt.rhs, s)
+		return r
+	case Assign:
+		a.record(p, s)
+		old, declared := s[stmt.lhs]
+		if s == nil || !declared {
+			return s
+		}
+		r := s.copy()
+		r[stmt.lhs] = a.copyVal(old, a.eval(stmt.rhs, s))
+		return r
+	case Print:
+		a.record(p, s)
+		return s
+	case IfThenElse:
+		a.record(p, s)
+		if s == nil {
+			a.stmt(stmt.thenBl, p.child(1), nil)
+			a.stmt(stmt.elseBl, p.child(2), nil)
+			return nil
+		}
+		sThen, sElse := a.refine(s, stmt.cond, true), a.refine(s, stmt.cond, false)
+		r := a.update(sThen, a.stmt(stmt.thenBl, p.child(1), sThen.copy()))
+		r = a.joinStates(r, a.update(sElse, a.stmt(stmt.elseBl, p.child(2), sElse.copy())))
+		if a.eval(stmt.cond, s).kinds&^kindBool != 0 {
+			// the condition may fail, which leaves the state unchanged
+			r = a.joinStates(r, s)
+		}
+		return r
+	case While:
+		if s == nil {
+			a.record(p, nil)
+			a.stmt(stmt.do, p.child(1), nil)
+			return nil
+		}
+		recording := a.recording
+		a.recording = false
+		// the state at the loop head: the outer state, or the outer state updated by an iteration
+		iterate := func(head absState) absState {
+			return a.joinStates(s, a.update(s, a.stmt(stmt.do, p.child(1), a.refine(head, stmt.cond, true))))
+		}
+		head := s
+		for n := 0; ; n++ {
+			next := iterate(head)
+			if a.leqStates(next, head) {
+				break
+			}
+			if n >= widenDelay {
+				head = a.widenStates(head, next)
+			} else {
+				head = a.joinStates(head, next)
+			}
+		}
+		for n := 0; n < narrowIterations; n++ {
+			head = iterate(head)
+		}
+		a.recording = recording
+		a.record(p, head)
+		a.stmt(stmt.do, p.child(1), a.refine(head, stmt.cond, true))
+
+		r := a.update(s, a.refine(head, stmt.cond, false))
+		if a.eval(stmt.cond, head).kinds&^kindBool != 0 {
+			// the condition may fail, which throws away the nested scope
+			r = a.joinStates(r, s)
+		}
+		return r
+	}
+	return s
+}
+
+// returns the abstract states of a program by line of the pretty printed program
+func showAnalysis(prg Prog, d Domain) string {
+	points, end := analyze(prg, d)
+	lines := lineNumbers(prg)
+	var paths []Path
+	for k := range points {
+		p, _ := parsePath(k)
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].less(paths[j]) })
+	var b strings.Builder
+	for _, p := range paths {
+		n, _ := nodeAt(prg, p)
+		fmt.Fprintf(&b, "line %d, %s: %s\n", lineOf(lines, p), headline(n), points[p.String()])
+	}
+	fmt.Fprintf(&b, "end: %s\n", end)
+	return b.String()
+}
+
+// checks that the states of an execution of the program are contained in the abstract states
+// returns a description of the first violation, or an empty string
+func checkAnalysis(prg Prog, d Domain) string {
+	a := &absInterp{d: d}
+	points, end := analyze(prg, d)
+	contains := func(abs absState, s ValState) bool {
+		if abs == nil {
+			return false
+		}
+		for k, v := range s {
+			if !a.contains(abs.get(k), v) {
+				return false
+			}
+		}
+		return true
+	}
+	r := newReplayer(recordProg(prg))
+	for r.pos < len(r.rec.steps) {
+		st := r.rec.steps[r.pos]
+		p := st.path
+		if st.kind == "cond" {
+			// the condition is evaluated in the state of its if or while statement
+			p = p[:len(p)-1]
+		}
+		if st.kind == "stmt" || st.kind == "cond" {
+			if s := r.state(); !contains(points[p.String()], s) {
+				return fmt.Sprintf("line %d: the state %s isn't contained in %s", st.line, showState(s), points[p.String()])
+			}
+		}
+		r.forward()
+	}
+	if s := r.state(); !contains(end, s) {
+		return fmt.Sprintf("the final state %s isn't contained in %s", showState(s), end)
+	}
+	return ""
+}
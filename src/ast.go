@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
 
 var exampleRunCounter int
 
@@ -40,6 +45,65 @@ func (prg Prog) run() {
 	fmt.Printf("\n")
 }
 
+// evaluate a program in a fresh state and capture its output
+// returns the output and the final state of the program's outermost scope
+func evalCaptured(prg Prog) (string, ValState) {
+	s := make(map[string]Val)
+	output := captureOutput(func() { prg.eval(s) })
+	return output, s
+}
+
+// evaluates two programs and compares their output and final states
+// returns a description of the first difference, or an empty string
+func diffEval(prg1, prg2 Prog) string {
+	out1, s1 := evalCaptured(prg1)
+	out2, s2 := evalCaptured(prg2)
+	if out1 != out2 {
+		return fmt.Sprintf("output differs:\n--- original\n%s\n--- transformed\n%s", out1, out2)
+	}
+	if !sameState(s1, s2) {
+		return fmt.Sprintf("final state differs: original %s, transformed %s", showState(s1), showState(s2))
+	}
+	return ""
+}
+
+// capture everything that is written to out while running f
+func captureOutput(f func()) string {
+	var buf bytes.Buffer
+	old := out
+	out = &buf
+	defer func() { out = old }()
+	f()
+	return buf.String()
+}
+
+// returns whether two value states contain the same variables with the same values
+func sameState(s1, s2 ValState) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for k, v := range s1 {
+		if w, ok := s2[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// return a value state as pretty string, with variables sorted by name
+func showState(s ValState) string {
+	var names []string
+	for k := range s {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var vars []string
+	for _, k := range names {
+		vars = append(vars, k+": "+showVal(s[k]))
+	}
+	return "{" + strings.Join(vars, ", ") + "}"
+}
+
 // helper functions for expressions to create ASTs
 func number(x int) Exp {
 	return Num(x)
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// bounded model checking
+//
+// an assertion is a condition that has to hold whenever a statement is about to be executed (or at the end of
+// the program). The program is unrolled into one formula: loops are unrolled up to a bound, and every value a
+// variable gets is a new variable of the formula (static single assignment), defined by an equation. Like in
+// symbolic.go, the declarations with literals are the inputs. The kinds of values are known statically (see
+// optimize.go), so the scoping rules of evalHooks.exec are followed when states are merged: a
+// nested scope starts with a copy of the state, and ValState.update only keeps the variables of the enclosing
+// scope, taking their value from the nested scope unless it has another kind. After an if, the value of a
+// variable is the one of the branch its condition chose, after a loop the one of the last iteration.
+//
+// a statement runs under a guard, the condition of reaching it. An assertion is violated if the equations, the
+// guard of its statement and its negation can all be true (for some unrolling); a model of these conditions
+// gives the inputs, which are replayed on the interpreter to confirm the violation and show its trace.
+// Runs that need more iterations than the bound are not checked beyond it.
+//
+// the solver reasons about unbounded integers, while sums and products of the interpreter wrap around. A
+// violation is confirmed by the interpreter, but an assertion that holds, and a loop that needs no more iterations
+// than the bound, only do so for runs in which no sum or product overflows, which the results say.
+
+// an assertion and where it is checked
+type bmcAssertion struct {
+	// the line, path or "end" it was given for
+	key string
+	// the statement it is checked before, nil at the end of the program
+	path Path
+	cond Exp
+}
+
+// the value of a program variable: its kind and, unless it is undefined, an expression over the variables of
+// the formula (an SSA variable, a symbol or a literal)
+type bmcVal struct {
+	kind Kind
+	exp  Exp
+}
+
+type bmcState map[string]bmcVal
+
+func (s bmcState) copy() bmcState {
+	c := make(bmcState)
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+func (s bmcState) kinds() kindEnv {
+	env := make(kindEnv)
+	for x, v := range s {
+		env[x] = 1 << v.kind
+	}
+	return env
+}
+
+// returns the state of the enclosing scope after a nested scope, like ValState.update
+func (s bmcState) update(inner bmcState) bmcState {
+	u := make(bmcState)
+	for x, v := range s {
+		u[x] = v
+		if w := inner[x]; w.kind == v.kind {
+			u[x] = w
+		}
+	}
+	return u
+}
+
+type bmcEncoder struct {
+	lines   map[string]int
+	inputs  map[string]*symInput
+	asserts map[string][]*bmcAssertion
+	bound   int
+	// the kinds of the variables of the formula
+	kinds kindEnv
+	// the equations defining the SSA variables
+	defs     []Exp
+	versions map[string]int
+	// the conditions under which an assertion is violated, one for every time its statement is unrolled
+	violations map[*bmcAssertion][]Exp
+	// the conditions under which a loop (by line) needs more iterations than the bound
+	cuts map[int][]Exp
+	// whether loops are not unrolled, but give any values to the variables they modify such that they exit
+	// (for the transition relations of termination.go)
+	havoc bool
+}
+
+// returns a new SSA variable for a program variable (or a guard)
+func (e *bmcEncoder) fresh(x string, k Kind) Var {
+	e.versions[x]++
+	v := Var(fmt.Sprintf("%s#%d", x, e.versions[x]))
+	e.kinds[string(v)] = 1 << k
+	return v
+}
+
+// returns an expression equal to a formula: the formula itself if it is a variable or a literal, else a new SSA
+// variable defined by it
+func (e *bmcEncoder) define(x string, k Kind, f Exp) Exp {
+	f = optimizeExp(f, e.kinds)
+	switch f.(type) {
+	case Num, Bool, Var:
+		return f
+	}
+	v := e.fresh(x, k)
+	e.defs = append(e.defs, Equal{v, f})
+	return v
+}
+
+func (e *bmcEncoder) guard(f Exp) Exp {
+	return e.define("guard", ValueBool, f)
+}
+
+// returns the formula for a program expression in a state
+func (e *bmcEncoder) formula(x Exp, s bmcState) (Exp, error) {
+	return translateExp(x, s.kinds(), func(name string, k Kind) Exp { return s[name].exp })
+}
+
+// returns the state after an if or a loop iteration: the first state if c holds, else the second
+func (e *bmcEncoder) merge(c Exp, s1, s2 bmcState) bmcState {
+	m := make(bmcState)
+	for x, v1 := range s1 {
+		v2 := s2[x]
+		if v1.kind == Undefined || v1.exp == v2.exp {
+			m[x] = v1
+			continue
+		}
+		v := e.fresh(x, v1.kind)
+		e.defs = append(e.defs, implies(c, Equal{v, v1.exp}), implies(Negation{c}, Equal{v, v2.exp}))
+		m[x] = bmcVal{v1.kind, v}
+	}
+	return m
+}
+
+// encodes a statement at a path, reached under the guard g in the state s
+// returns the state after it and the guard of reaching the statement after it
+func (e *bmcEncoder) encode(stmt Stmt, p Path, g Exp, s bmcState) (bmcState, Exp, error) {
+	switch stmt := stmt.(type) {
+	case Prog:
+		return e.encode(stmt[0], p.child(0), g, s)
+	case Block:
+		return e.encode(stmt[0], p.child(0), g, s)
+	case Seq:
+		s, g, err := e.encode(stmt[0], p.child(0), g, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		return e.encode(stmt[1], p.child(1), g, s)
+	}
+	line := lineOf(e.lines, p)
+	fail := func(err error) (bmcState, Exp, error) {
+		return nil, nil, fmt.Errorf("line %d: %s", line, err)
+	}
+	for _, a := range e.asserts[p.String()] {
+		if err := e.violation(a, g, s); err != nil {
+			return fail(err)
+		}
+	}
+	kindOf := func(x Exp) (Kind, error) {
+		k, ok := staticKind(s.kinds(), x)
+		if !ok {
+			return k, fmt.Errorf("the kind of %s depends on the values of its variables", x.pretty())
+		}
+		return k, nil
+	}
+	switch stmt := stmt.(type) {
+	case Decl:
+		if in, ok := e.inputs[p.String()]; ok {
+			s[stmt.lhs] = bmcVal{kindOfLiteral(in.lit), Var(in.name)}
+			break
+		}
+		k, err := kindOf(stmt.rhs)
+		if err != nil {
+			return fail(err)
+		}
+		if k == Undefined {
+			s[stmt.lhs] = bmcVal{kind: Undefined}
+			break
+		}
+		rhs, err := e.formula(stmt.rhs, s)
+		if err != nil {
+			return fail(err)
+		}
+		s[stmt.lhs] = bmcVal{k, e.define(stmt.lhs, k, rhs)}
+	case Assign:
+		k, err := kindOf(stmt.rhs)
+		if err != nil {
+			return fail(err)
+		}
+		if old, ok := s[stmt.lhs]; !ok || old.kind != k || k == Undefined {
+			// the assignment fails (or assigns an undefined value to an undefined variable)
+			break
+		}
+		rhs, err := e.formula(stmt.rhs, s)
+		if err != nil {
+			return fail(err)
+		}
+		s[stmt.lhs] = bmcVal{k, e.define(stmt.lhs, k, rhs)}
+	case IfThenElse:
+		if k, err := kindOf(stmt.cond); err != nil {
+			return fail(err)
+		} else if k != ValueBool {
+			break
+		}
+		cond, err := e.formula(stmt.cond, s)
+		if err != nil {
+			return fail(err)
+		}
+		c := e.guard(cond)
+		st, gt, err := e.encode(stmt.thenBl, p.child(1), e.guard(And{g, c}), s.copy())
+		if err != nil {
+			return nil, nil, err
+		}
+		se, ge, err := e.encode(stmt.elseBl, p.child(2), e.guard(And{g, Negation{c}}), s.copy())
+		if err != nil {
+			return nil, nil, err
+		}
+		return e.merge(c, s.update(st), s.update(se)), e.guard(Or{gt, ge}), nil
+	case While:
+		if k, err := kindOf(stmt.cond); err != nil {
+			return fail(err)
+		} else if k != ValueBool {
+			break
+		}
+		return e.loop(stmt, p, g, s, 0)
+	}
+	return s, g, nil
+}
+
+// encodes the iterations of a loop after n iterations, reached under the guard g in the state s
+func (e *bmcEncoder) loop(w While, p Path, g Exp, s bmcState, n int) (bmcState, Exp, error) {
+	if e.havoc {
+		s = s.copy()
+		for x := range modifiedVars(w.do) {
+			if v, ok := s[x]; ok && v.kind != Undefined {
+				s[x] = bmcVal{v.kind, e.fresh(x, v.kind)}
+			}
+		}
+	}
+	cond, err := e.formula(w.cond, s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("line %d: %s", lineOf(e.lines, p), err)
+	}
+	if e.havoc {
+		return s, e.guard(And{g, Negation{cond}}), nil
+	}
+	c := e.guard(cond)
+	exit := e.guard(And{g, Negation{c}})
+	if n == e.bound {
+		line := lineOf(e.lines, p)
+		e.cuts[line] = append(e.cuts[line], e.guard(And{g, c}))
+		return s, exit, nil
+	}
+	sb, gb, err := e.encode(w.do, p.child(1), e.guard(And{g, c}), s.copy())
+	if err != nil {
+		return nil, nil, err
+	}
+	sn, gn, err := e.loop(w, p, gb, s.update(sb), n+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e.merge(c, sn, s), e.guard(Or{gn, exit}), nil
+}
+
+// adds the condition under which an assertion is violated in a state reached under the guard g
+func (e *bmcEncoder) violation(a *bmcAssertion, g Exp, s bmcState) error {
+	if k, ok := staticKind(s.kinds(), a.cond); !ok || k != ValueBool {
+		return fmt.Errorf("the assertion %s isn't a condition", a.cond.pretty())
+	}
+	cond, err := e.formula(a.cond, s)
+	if err != nil {
+		return fmt.Errorf("the assertion %s: %s", a.cond.pretty(), err)
+	}
+	e.violations[a] = append(e.violations[a], e.guard(And{g, Negation{cond}}))
+	return nil
+}
+
+func disjunction(es []Exp) Exp {
+	if len(es) == 0 {
+		return Bool(false)
+	}
+	d := es[0]
+	for _, x := range es[1:] {
+		d = Or{d, x}
+	}
+	return d
+}
+
+// finds the statements assertions are checked before, by line, path or "end"
+func bmcAssertions(prg Prog, srcs map[string]string) ([]*bmcAssertion, error) {
+	lines := lineNumbers(prg)
+	var keys []string
+	for k := range srcs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var asserts []*bmcAssertion
+	for _, key := range keys {
+		cond, err := parseExp(srcs[key])
+		if err != nil {
+			return nil, fmt.Errorf("assertion %q: %s", srcs[key], err)
+		}
+		a := &bmcAssertion{key: key, cond: cond}
+		if key != "end" {
+			walkPaths(prg, func(n Node, p Path) bool {
+				switch n.(type) {
+				case Prog, Block, Seq:
+					return a.path == nil
+				}
+				if a.path == nil && (p.String() == key || strconv.Itoa(lineOf(lines, p)) == key && lines[p.String()] != 0) {
+					a.path = p
+				}
+				return a.path == nil
+			})
+			if a.path == nil {
+				return nil, fmt.Errorf("assertion %s: there is no statement at %s", srcs[key], key)
+			}
+		}
+		asserts = append(asserts, a)
+	}
+	return asserts, nil
+}
+
+// the result of checking an assertion
+type bmcResult struct {
+	assertion *bmcAssertion
+	result    satResult
+	// the inputs of a violation and its replay on the interpreter
+	inputs []goldenInput
+	run    *assertionRun
+}
+
+// checks assertions of a program for its inputs (those of the given variables unless names is empty), unrolling
+// loops at most bound times
+// returns the results and the lines of the loops the bound cuts off on some runs
+func bmc(prg Prog, names []string, asserts []*bmcAssertion, bound int) ([]*bmcResult, []int, error) {
+	inputs := symInputs(prg, names)
+	e := &bmcEncoder{lines: lineNumbers(prg), inputs: make(map[string]*symInput), asserts: make(map[string][]*bmcAssertion),
+		bound: bound, kinds: inputKinds(inputs), versions: make(map[string]int),
+		violations: make(map[*bmcAssertion][]Exp), cuts: make(map[int][]Exp)}
+	for _, in := range inputs {
+		e.inputs[in.path.String()] = in
+	}
+	var atEnd []*bmcAssertion
+	for _, a := range asserts {
+		if a.path == nil {
+			atEnd = append(atEnd, a)
+		} else {
+			e.asserts[a.path.String()] = append(e.asserts[a.path.String()], a)
+		}
+	}
+	s, g, err := e.encode(prg, Path{}, Bool(true), make(bmcState))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, a := range atEnd {
+		if err := e.violation(a, g, s); err != nil {
+			return nil, nil, fmt.Errorf("end: %s", err)
+		}
+	}
+
+	literals := literalValues(inputs)
+	var results []*bmcResult
+	for _, a := range asserts {
+		r := &bmcResult{assertion: a}
+		results = append(results, r)
+		conds := append(append([]Exp{}, e.defs...), disjunction(e.violations[a]))
+		var model ValState
+		if r.result, model = solve(conds, e.kinds); r.result != sat {
+			continue
+		}
+		for _, in := range inputs {
+			v, ok := model[in.name]
+			if !ok {
+				v = literals[in.name]
+			}
+			r.inputs = append(r.inputs, goldenInput{in.path.String(), in.variable, jsonVal(v)})
+		}
+		p, err := withInputs(prg, r.inputs)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.run = runAssertions(p, []*bmcAssertion{a})
+	}
+	var cut []int
+	for line, guards := range e.cuts {
+		if res, _ := solve(append(append([]Exp{}, e.defs...), disjunction(guards)), e.kinds); res != unsat {
+			cut = append(cut, line)
+		}
+	}
+	sort.Ints(cut)
+	return results, cut, nil
+}
+
+// the maximal number of statements runAssertions executes
+const maxAssertionSteps = 100000
+
+// a run of a program on the interpreter that checks assertions
+type assertionRun struct {
+	lines   map[string]int
+	asserts map[string][]*bmcAssertion
+	// the executed statements and conditions, with what they printed and the state after them
+	trace []string
+	steps int
+	// the violated assertion and the state it was violated in, nil if none was
+	violated *bmcAssertion
+	state    ValState
+}
+
+// runs a program, stopping at the first violated assertion (or after maxAssertionSteps statements)
+func runAssertions(prg Prog, asserts []*bmcAssertion) *assertionRun {
+	r := &assertionRun{lines: lineNumbers(prg), asserts: make(map[string][]*bmcAssertion)}
+	var atEnd []*bmcAssertion
+	for _, a := range asserts {
+		if a.path == nil {
+			atEnd = append(atEnd, a)
+		} else {
+			r.asserts[a.path.String()] = append(r.asserts[a.path.String()], a)
+		}
+	}
+	s := make(ValState)
+	var finished bool
+	// the output is part of the trace
+	captureOutput(func() { finished = r.hooks().exec(prg, Path{}, s) })
+	if finished {
+		r.check(atEnd, s)
+	}
+	return r
+}
+
+// checks assertions in a state, returns false if one is violated
+func (r *assertionRun) check(asserts []*bmcAssertion, s ValState) bool {
+	for _, a := range asserts {
+		if a.cond.eval(s) != mkBool(true) {
+			r.violated, r.state = a, copyState(s)
+			return false
+		}
+	}
+	return true
+}
+
+func (r *assertionRun) step(p Path, desc string, output string, s ValState) {
+	entry := fmt.Sprintf("line %d: %s", lineOf(r.lines, p), desc)
+	if output != "" {
+		entry += fmt.Sprintf(" prints %q", strings.TrimSuffix(output, "\n"))
+	}
+	r.trace = append(r.trace, entry+" -> "+showState(s))
+}
+
+// returns the hooks checking the assertions and recording the trace of a run
+func (r *assertionRun) hooks() *evalHooks {
+	return &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			switch stmt.(type) {
+			case Prog, Block, Seq:
+				return true
+			}
+			if !r.check(r.asserts[p.String()], s) {
+				return false
+			}
+			r.steps++
+			return r.steps <= maxAssertionSteps
+		},
+		iteration: func(while While, p Path, s2 ValState) bool {
+			// every iteration after the first one is a step
+			return r.steps <= maxAssertionSteps
+		},
+		reset: func(while While, p Path, s2 ValState) {
+			r.steps++
+		},
+		cond: func(owner Stmt, p Path, s ValState, v Val, output string) {
+			var desc string
+			switch owner := owner.(type) {
+			case IfThenElse:
+				desc = fmt.Sprintf("if %s is %s", owner.cond.pretty(), showVal(v))
+			case While:
+				desc = fmt.Sprintf("while %s is %s", owner.cond.pretty(), showVal(v))
+			}
+			r.step(p, desc, output, s)
+		},
+		done: func(stmt Stmt, p Path, s ValState, output string) {
+			switch stmt.(type) {
+			case Decl, Assign, Print:
+				r.step(p, headline(stmt), output, s)
+			}
+		},
+	}
+}
+
+// returns the results of bounded model checking
+func showBMC(prg Prog, results []*bmcResult, cut []int, bound int) string {
+	lines := lineNumbers(prg)
+	// the solver doesn't know that sums and products wrap around
+	assuming := func(n Node) string {
+		if hasArithmetic(prg) || hasArithmetic(n) {
+			return ", assuming no overflow"
+		}
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range results {
+		where := "at the end"
+		if r.assertion.path != nil {
+			n, _ := nodeAt(prg, r.assertion.path)
+			where = fmt.Sprintf("before line %d (%s)", lineOf(lines, r.assertion.path), headline(n))
+		}
+		fmt.Fprintf(&b, "assertion %s %s: ", r.assertion.cond.pretty(), where)
+		switch r.result {
+		case unsat:
+			fmt.Fprintf(&b, "holds for up to %d iterations of every loop%s\n", bound, assuming(r.assertion.cond))
+			continue
+		case unknown:
+			fmt.Fprintf(&b, "unknown\n")
+			continue
+		}
+		fmt.Fprintf(&b, "violated\n")
+		var inputs []string
+		for _, in := range r.inputs {
+			p, _ := parsePath(in.Path)
+			inputs = append(inputs, fmt.Sprintf("%s = %v (line %d)", in.Name, in.Value, lineOf(lines, p)))
+		}
+		fmt.Fprintf(&b, "  inputs: %s\n", strings.Join(inputs, ", "))
+		for _, t := range r.run.trace {
+			fmt.Fprintf(&b, "  %s\n", t)
+		}
+		if r.run.violated != nil {
+			fmt.Fprintf(&b, "  confirmed by the interpreter in the state %s\n", showState(r.run.state))
+		} else {
+			fmt.Fprintf(&b, "  NOT confirmed by the interpreter\n")
+		}
+	}
+	if len(cut) == 0 {
+		fmt.Fprintf(&b, "no run needs more than %d iterations of a loop%s\n", bound, assuming(prg))
+	}
+	for _, line := range cut {
+		fmt.Fprintf(&b, "the loop at line %d can need more than %d iterations, runs are only checked that far\n", line, bound)
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+// checks assertions of a program for the inputs of the given variables, unrolling loops at most 3 times
+// (nested loops make the formula grow quickly)
+func checkAssertions(prg Prog, names []string, srcs map[string]string) ([]*bmcResult, error) {
+	asserts, err := bmcAssertions(prg, srcs)
+	if err != nil {
+		return nil, err
+	}
+	results, _, err := bmc(prg, names, asserts, 3)
+	return results, err
+}
+
+func TestBMCViolationsAreConfirmed(t *testing.T) {
+	checked := 0
+	for name, prg := range testPrograms() {
+		results, err := checkAssertions(prg, nil, map[string]string{"end": "false"})
+		if err != nil {
+			// like failing conditions, kinds that depend on the inputs can't be encoded
+			continue
+		}
+		checked++
+		for _, r := range results {
+			if r.result == sat && (r.run == nil || r.run.violated == nil) {
+				t.Errorf("%s: the violation with inputs %v isn't confirmed by the interpreter", name, r.inputs)
+			}
+		}
+	}
+	if checked == 0 {
+		t.Errorf("no program could be checked")
+	}
+}
+
+func TestBMCResults(t *testing.T) {
+	// x is the only input, the loop counts y up to x
+	l01 := declaration("x", number(2))
+	l02 := declaration("y", number(0))
+	l03 := while(lesser(variable("y"), variable("x")), block(assignment("y", plus(variable("y"), number(1)))))
+	prg := generateProg([]Stmt{l01, l02, l03, sPrint(variable("y"))})
+	tests := map[string]satResult{
+		"!(y<x)":       unsat,
+		"y==x":         sat,
+		"y<3":          sat,
+		"y==x || y==0": unsat,
+		"!(y<0)":       unsat,
+	}
+	for cond, want := range tests {
+		results, err := checkAssertions(prg, []string{"x"}, map[string]string{"end": cond})
+		if err != nil {
+			t.Fatalf("%s: %s", cond, err)
+		}
+		r := results[0]
+		if r.result != want {
+			t.Errorf("%s: %s instead of %s", cond, r.result, want)
+		}
+		if r.result == sat && (r.run == nil || r.run.violated == nil) {
+			t.Errorf("%s: the violation with inputs %v isn't confirmed by the interpreter", cond, r.inputs)
+		}
+	}
+}
+
+// the solver doesn't know that x+1 wraps around for the largest x, so x<y only holds assuming no overflow
+func TestShowBMCAssumesNoOverflow(t *testing.T) {
+	prg := generateProg([]Stmt{declaration("x", number(1)), declaration("y", plus(variable("x"), number(1)))})
+	asserts, err := bmcAssertions(prg, map[string]string{"end": "x<y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, cut, err := bmc(prg, []string{"x"}, asserts, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "assertion (x<y) at the end: holds for up to 3 iterations of every loop, assuming no overflow\n" +
+		"no run needs more than 3 iterations of a loop, assuming no overflow\n"
+	if got := showBMC(prg, results, cut, 3); got != want {
+		t.Errorf("got\n%s", got)
+	}
+}
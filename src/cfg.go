@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// control-flow graphs of programs
+//
+// basic blocks contain Decl, Assign and Print statements, branch blocks evaluate the condition
+// of a While or IfThenElse. The nested scopes of While and IfThenElse are modelled by scope blocks,
+// which behave exactly like the temporary states of while and if statements in evalHooks.exec:
+//
+//	scope enter   the current state is saved and the scope continues with a copy of it
+//	scope reset   after each loop iteration, the copy is reset to the saved state updated with the copy
+//	scope exit    the saved state is updated with the copy and becomes the current state again
+//	scope drop    the copy is thrown away (the while condition failed to evaluate)
+
+// the kinds of blocks of a control-flow graph
+type cfgKind int
+
+const (
+	cfgEntry      cfgKind = 0
+	cfgExit       cfgKind = 1
+	cfgBasic      cfgKind = 2
+	cfgBranch     cfgKind = 3
+	cfgScopeEnter cfgKind = 4
+	cfgScopeReset cfgKind = 5
+	cfgScopeExit  cfgKind = 6
+	cfgScopeDrop  cfgKind = 7
+)
+
+// a block of a control-flow graph
+// the successors of a branch block are ordered: condition true, condition false, condition failed to evaluate
+type cfgBlock struct {
+	id    int
+	kind  cfgKind
+	stmts []Stmt
+	cond  Exp
+	// the While or IfThenElse a branch or scope block belongs to
+	owner Stmt
+	succs []*cfgBlock
+	preds []*cfgBlock
+}
+
+// a control-flow graph with a unique entry and exit block
+type cfg struct {
+	entry  *cfgBlock
+	exit   *cfgBlock
+	blocks []*cfgBlock
+}
+
+// builds the control-flow graph of a program
+func buildCFG(prg Prog) *cfg {
+	g := &cfg{}
+	g.entry = g.newBlock(cfgEntry, nil)
+	ends := g.build(prg, []*cfgBlock{g.entry})
+	g.exit = g.newBlock(cfgExit, nil)
+	g.linkAll(ends, g.exit)
+	return g
+}
+
+func (g *cfg) newBlock(kind cfgKind, owner Stmt) *cfgBlock {
+	b := &cfgBlock{id: len(g.blocks), kind: kind, owner: owner}
+	g.blocks = append(g.blocks, b)
+	return b
+}
+func (g *cfg) link(from, to *cfgBlock) {
+	from.succs = append(from.succs, to)
+	to.preds = append(to.preds, from)
+}
+func (g *cfg) linkAll(froms []*cfgBlock, to *cfgBlock) {
+	for _, from := range froms {
+		g.link(from, to)
+	}
+}
+
+// adds a statement to the graph, continuing from the blocks in preds
+// returns the blocks where control continues after the statement
+func (g *cfg) build(s Stmt, preds []*cfgBlock) []*cfgBlock {
+	switch s := s.(type) {
+	case Prog:
+		return g.build(s[0], preds)
+	case Block:
+		return g.build(s[0], preds)
+	case Seq:
+		return g.build(s[1], g.build(s[0], preds))
+	case Decl, Assign, Print:
+		// extend the current basic block, if control can only come from there
+		if len(preds) == 1 && preds[0].kind == cfgBasic && len(preds[0].succs) == 0 {
+			preds[0].stmts = append(preds[0].stmts, s)
+			return preds
+		}
+		b := g.newBlock(cfgBasic, nil)
+		b.stmts = []Stmt{s}
+		g.linkAll(preds, b)
+		return []*cfgBlock{b}
+	case IfThenElse:
+		enter := g.newBlock(cfgScopeEnter, s)
+		g.linkAll(preds, enter)
+		branch := g.newBlock(cfgBranch, s)
+		branch.cond = s.cond
+		g.link(enter, branch)
+		th := g.build(s.thenBl, []*cfgBlock{branch})
+		el := g.build(s.elseBl, []*cfgBlock{branch})
+		exit := g.newBlock(cfgScopeExit, s)
+		g.linkAll(th, exit)
+		g.linkAll(el, exit)
+		// a failing condition leaves the copied state untouched
+		g.link(branch, exit)
+		return []*cfgBlock{exit}
+	case While:
+		enter := g.newBlock(cfgScopeEnter, s)
+		g.linkAll(preds, enter)
+		branch := g.newBlock(cfgBranch, s)
+		branch.cond = s.cond
+		g.link(enter, branch)
+		body := g.build(s.do, []*cfgBlock{branch})
+		reset := g.newBlock(cfgScopeReset, s)
+		g.linkAll(body, reset)
+		g.link(reset, branch)
+		exit := g.newBlock(cfgScopeExit, s)
+		g.link(branch, exit)
+		drop := g.newBlock(cfgScopeDrop, s)
+		g.link(branch, drop)
+		return []*cfgBlock{exit, drop}
+	}
+	panic(fmt.Sprintf("cfg: unknown statement %T", s))
+}
+
+// returns whether the edge from a block to its i-th successor is the back-edge of a loop
+func (b *cfgBlock) isBackEdge(i int) bool {
+	return b.kind == cfgScopeReset && b.succs[i].kind == cfgBranch
+}
+
+// returns the label of the edge from a block to its i-th successor
+func (b *cfgBlock) edgeLabel(i int) string {
+	if b.kind == cfgBranch {
+		return []string{"true", "false", "fail"}[i]
+	}
+	if b.isBackEdge(i) {
+		return "back"
+	}
+	return ""
+}
+
+// returns the name of the statement owning a branch or scope block
+func ownerName(s Stmt) string {
+	if _, ok := s.(While); ok {
+		return "while"
+	}
+	return "if"
+}
+
+// returns a one-line description of a block
+func (b *cfgBlock) describe() string {
+	switch b.kind {
+	case cfgEntry:
+		return "entry"
+	case cfgExit:
+		return "exit"
+	case cfgBasic:
+		var stmts []string
+		for _, s := range b.stmts {
+			stmts = append(stmts, s.pretty())
+		}
+		return strings.Join(stmts, "; ")
+	case cfgBranch:
+		return ownerName(b.owner) + " " + b.cond.pretty()
+	case cfgScopeEnter:
+		return "scope enter (" + ownerName(b.owner) + ")"
+	case cfgScopeReset:
+		return "scope reset (" + ownerName(b.owner) + ")"
+	case cfgScopeExit:
+		return "scope exit (" + ownerName(b.owner) + ")"
+	case cfgScopeDrop:
+		return "scope drop (" + ownerName(b.owner) + ")"
+	}
+	return "?"
+}
+
+// evaluates a program by walking its control-flow graph
+// the result (state and output) is the same as evaluating the program with Prog.eval
+func (g *cfg) eval(s ValState) {
+	cur := s
+	var saved []ValState
+	b := g.entry
+	for b != g.exit {
+		next := 0
+		switch b.kind {
+		case cfgBasic:
+			for _, stmt := range b.stmts {
+				stmt.eval(cur)
+			}
+		case cfgScopeEnter:
+			saved = append(saved, cur)
+			cur = make(map[string]Val)
+			for k, v := range saved[len(saved)-1] {
+				cur[k] = v
+			}
+		case cfgBranch:
+			v := b.cond.eval(cur)
+			switch {
+			case v.flag == ValueBool && v.valB:
+				next = 0
+			case v.flag == ValueBool:
+				next = 1
+			default:
+				fmt.Fprint(out, condFailure(b.owner))
+				next = 2
+			}
+		case cfgScopeReset:
+			cur = saved[len(saved)-1].update(cur)
+		case cfgScopeExit:
+			outer := saved[len(saved)-1]
+			saved = saved[:len(saved)-1]
+			updated := outer.update(cur)
+			for k := range outer {
+				outer[k] = updated[k]
+			}
+			cur = outer
+		case cfgScopeDrop:
+			cur = saved[len(saved)-1]
+			saved = saved[:len(saved)-1]
+		}
+		b = b.succs[next]
+	}
+}
+
+// checks that walking the control-flow graph reproduces the result of Prog.eval
+// returns a description of the first difference, or an empty string
+func (g *cfg) checkAgainst(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	s := make(map[string]Val)
+	gotOut := captureOutput(func() { g.eval(s) })
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- cfg\n%s", wantOut, gotOut)
+	}
+	if !sameState(s, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, cfg %s", showState(wantState), showState(s))
+	}
+	return ""
+}
+
+// renders the graph as text, one block per line with its successors
+func (g *cfg) text() string {
+	var b strings.Builder
+	for _, blk := range g.blocks {
+		fmt.Fprintf(&b, "B%d: %s", blk.id, blk.describe())
+		for i, succ := range blk.succs {
+			if i == 0 {
+				b.WriteString(" ->")
+			}
+			fmt.Fprintf(&b, " B%d", succ.id)
+			if l := blk.edgeLabel(i); l != "" {
+				fmt.Fprintf(&b, "(%s)", l)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// renders the graph as a Graphviz DOT graph
+// back-edges of loops are dashed
+func (g *cfg) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph CFG {\n")
+	b.WriteString("  node [shape=box, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, blk := range g.blocks {
+		attrs := ""
+		switch blk.kind {
+		case cfgEntry, cfgExit:
+			attrs = ", shape=oval"
+		case cfgBranch:
+			attrs = ", shape=diamond"
+		case cfgScopeEnter, cfgScopeReset, cfgScopeExit, cfgScopeDrop:
+			attrs = ", style=dashed"
+		}
+		label := blk.describe()
+		if blk.kind == cfgBasic {
+			label = strings.ReplaceAll(label, "; ", "\n")
+		}
+		fmt.Fprintf(&b, "  b%d [label=%s%s];\n", blk.id, dotQuote(label), attrs)
+	}
+	for _, blk := range g.blocks {
+		for i, succ := range blk.succs {
+			attrs := ""
+			if l := blk.edgeLabel(i); l != "" {
+				attrs = " [label=" + dotQuote(l)
+				if blk.isBackEdge(i) {
+					attrs += ", style=dashed"
+				}
+				attrs += "]"
+			}
+			fmt.Fprintf(&b, "  b%d -> b%d%s;\n", blk.id, succ.id, attrs)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
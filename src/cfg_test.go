@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestCFGReproducesEval(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := buildCFG(prg).checkAgainst(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,968 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the command line interface
+// programs are given either as the name of an example (e.g. ex01) or as a file with the JSON encoding of a program
+
+const usage = `usage: imp [command] [arguments]
+
+without a command, all examples are run
+
+commands:
+  dump PROGRAM    print the JSON encoding of a program
+  load FILE       load a program from its JSON encoding and run it
+  ast PROGRAM     print the abstract syntax tree of a program
+                  --dot: as Graphviz DOT, --flat: flatten sequences, --types: annotate inferred types
+  opt PROGRAM     print the program after constant folding and algebraic simplification
+                  --check: check that the optimized program gives the same output and state
+  dce PROGRAM     print the program after removing declarations and assignments whose values are never observed
+                  --check: check that the resulting program gives the same output
+  lint PROGRAM    print warnings about common mistakes, with a stable code each
+  bindings PROGRAM
+                  list the variables of a program with the lines declaring and referencing them
+  rename PROGRAM POSITION NEWNAME
+                  rename the variable at POSITION (a path like 0.0.1.0 or LINE:NAME), refusing renamings
+                  that would capture or shadow another variable; --json: print the JSON encoding
+  step PROGRAM    print the configurations of the small-step semantics
+                  --full: print whole statements, --max N: stop after N steps,
+                  --check: check that the final state and output agree with eval
+  derive PROGRAM  print the big-step derivation tree of evaluating the program
+                  --types: of type checking it instead, --latex: as LaTeX source for bussproofs,
+                  --check: check that the derivations agree with eval and check
+  trace PROGRAM   print a JSON line for every executed statement, evaluated condition and scope change
+                  (the output of the program is part of the events), -o FILE: write the trace to FILE,
+                  --check: check that tracing gives the same output and state as eval
+  replay PROGRAM  record the execution of a program and replay it forwards and backwards (h for its commands)
+                  --trace FILE: replay a trace written by imp trace instead of a program,
+                  --check: check that replaying reproduces the states of eval
+  profile PROGRAM print how often each statement was executed and the time spent in it, hottest first
+                  (the output of the program is not shown), --top N: only the N hottest statements,
+                  --pprof FILE: also write the profile for go tool pprof,
+                  --check: check that profiling gives the same output and state as eval
+  cover PROGRAM   print the program annotated with the executions of its statements and branches
+                  (the output of the program is not shown), --data FILE: merge the coverage into FILE,
+                  --lcov FILE: write the coverage in the LCOV format, for the pretty printed program written
+                  next to FILE (with the extension .imp),
+                  --check: check that collecting coverage gives the same output and state as eval
+  intervals PROGRAM
+                  print the possible values of the variables before every statement, found by interval analysis
+                  --check: check that the states of eval are among the possible values
+  absint PROGRAM  print the possible values of the variables before every statement, found by abstract interpretation
+                  --domain D1,D2,...: the domain for integers (interval, sign, parity, const), or the product of several,
+                  --check: check that the states of eval are among the possible values
+  symex PROGRAM   execute a program symbolically, the declarations with literals are its inputs, and print its
+                  paths with their conditions, outputs and final states in terms of the inputs
+                  --bound N: unroll loops at most N times (default 5), --paths N: cut off the paths forking once there
+                  are N (default 1000), --inputs X,Y: only these variables are inputs,
+                  --check: check that the path taken with the literals agrees with eval
+  solve EXP...    decide whether the conditions can all be true, and print values of their variables if they can
+                  (integer variables are those used as integers, the others are booleans)
+  testgen PROGRAM generate inputs (values of the declarations with literals) covering every branch of the program
+                  and print them with the output and state of each as golden file in JSON
+                  --bound N: unroll loops at most N times (default 5), --paths N: cut off the paths forking once there
+                  are N (default 1000), -o FILE: write the golden file to FILE
+                  (a program file is named relative to the directory of the golden file)
+  golden FILE...  replay golden files written by imp testgen and report the tests whose output or state changed
+  verify PROGRAM  prove the annotations of a program with Hoare logic, each verification condition is proved
+                  (assuming no overflow if it has sums or products), refuted (with a counterexample) or unknown
+                  --spec FILE: the annotations as JSON, like {"requires": "0<x", "ensures": "x==y",
+                  "invariants": {"LINE": "y<x || y==x"}}, --requires EXP, --ensures EXP, --invariant LINE=EXP:
+                  annotations replacing those of the file, --vcs: print the conditions
+  wp PROGRAM      print the weakest precondition of a program for a postcondition
+                  --post EXP: the postcondition, --at PATH: of the statement at PATH instead (the variables it
+                  reads before declaring them are its free variables), --invariant LINE=EXP: the invariant of
+                  the loop at LINE
+  sp PROGRAM      print the strongest postcondition of a program for a precondition (variables named like x_0
+                  stand for some value), --pre EXP: the precondition, --at PATH, --invariant LINE=EXP: as for wp
+  bmc PROGRAM     check assertions for all inputs (the declarations with literals), with loops unrolled up to a
+                  bound (assuming that no sum or product overflows), and replay violations on the interpreter
+                  --assert LINE=EXP: an assertion that holds before the statement at LINE (or a path, or "end"
+                  for the end of the program), --bound N: unroll loops at most N times (default 5),
+                  --inputs X,Y: only these variables are inputs
+  termination PROGRAM
+                  check that every loop terminates with a linear ranking function, or find a state from which it
+                  runs forever (assuming that no sum or product overflows, the state is replayed on the interpreter);
+                  each loop terminates, possibly doesn't terminate or is unknown
+  defuse PROGRAM  print the definitions (declarations and assignments) reaching every use of a variable, and
+                  the uses every definition reaches
+  debug PROGRAM   run a program in the interactive debugger (h for its commands)
+  cfg PROGRAM     print the control-flow graph of a program
+                  --dot: as Graphviz DOT, --check: check that walking the graph reproduces eval
+
+PROGRAM is the name of an example (%s) or a JSON file
+`
+
+// runs the command selected by the first argument
+func runCommand(args []string) error {
+	cmd, rest := args[0], args[1:]
+	switch cmd {
+	case "dump":
+		return cmdDump(rest)
+	case "load":
+		return cmdLoad(rest)
+	case "ast":
+		return cmdAst(rest)
+	case "cfg":
+		return cmdCfg(rest)
+	case "opt":
+		return cmdOpt(rest)
+	case "dce":
+		return cmdDce(rest)
+	case "lint":
+		return cmdLint(rest)
+	case "bindings":
+		return cmdBindings(rest)
+	case "rename":
+		return cmdRename(rest)
+	case "step":
+		return cmdStep(rest)
+	case "derive":
+		return cmdDerive(rest)
+	case "debug":
+		return cmdDebug(rest)
+	case "trace":
+		return cmdTrace(rest)
+	case "replay":
+		return cmdReplay(rest)
+	case "profile":
+		return cmdProfile(rest)
+	case "cover":
+		return cmdCover(rest)
+	case "intervals":
+		return cmdIntervals(rest)
+	case "absint":
+		return cmdAbsint(rest)
+	case "symex":
+		return cmdSymex(rest)
+	case "solve":
+		return cmdSolve(rest)
+	case "testgen":
+		return cmdTestgen(rest)
+	case "golden":
+		return cmdGolden(rest)
+	case "verify":
+		return cmdVerify(rest)
+	case "wp":
+		return cmdPredicate("wp", rest)
+	case "sp":
+		return cmdPredicate("sp", rest)
+	case "bmc":
+		return cmdBMC(rest)
+	case "termination":
+		return cmdTermination(rest)
+	case "defuse":
+		return cmdDefUse(rest)
+	case "help", "-h", "-help", "--help":
+		fmt.Printf(usage, strings.Join(exampleNames, ", "))
+		return nil
+	}
+	return fmt.Errorf("unknown command %q (run \"imp help\" for usage)", cmd)
+}
+
+// loads a program by example name or from a JSON file
+func loadProg(arg string) (Prog, error) {
+	if ex, ok := examples[arg]; ok {
+		return ex(), nil
+	}
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Prog{}, fmt.Errorf("%s is neither a file nor an example (%s)", arg, strings.Join(exampleNames, ", "))
+		}
+		return Prog{}, err
+	}
+	prg, err := unmarshalProg(data)
+	if err != nil {
+		return Prog{}, fmt.Errorf("%s: %s", arg, err)
+	}
+	return prg, nil
+}
+
+// parses the flags of a command, which has to be followed by exactly one program
+// parses flags given before, between or after the other arguments, which become fs.Args
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			// everything after -- is an argument
+			positional = append(positional, rest...)
+			break
+		}
+		positional, args = append(positional, rest[0]), rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+func parseProgArgs(fs *flag.FlagSet, args []string) (Prog, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return Prog{}, err
+	}
+	if fs.NArg() != 1 {
+		return Prog{}, fmt.Errorf("%s: expected exactly one program", fs.Name())
+	}
+	return loadProg(fs.Arg(0))
+}
+
+func cmdDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	data, err := marshalProg(prg)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func cmdLoad(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	prg.run()
+	return nil
+}
+
+func cmdAst(args []string) error {
+	fs := flag.NewFlagSet("ast", flag.ContinueOnError)
+	dot := fs.Bool("dot", false, "print the tree as Graphviz DOT")
+	var opts astOptions
+	fs.BoolVar(&opts.flatten, "flat", false, "flatten chains of sequences")
+	fs.BoolVar(&opts.types, "types", false, "annotate expressions with their inferred types")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	tree := astTree(prg, opts)
+	if *dot {
+		fmt.Print(astDot(tree))
+	} else {
+		fmt.Print(astText(tree))
+	}
+	return nil
+}
+
+func cmdCfg(args []string) error {
+	fs := flag.NewFlagSet("cfg", flag.ContinueOnError)
+	dot := fs.Bool("dot", false, "print the graph as Graphviz DOT")
+	check := fs.Bool("check", false, "check that walking the graph reproduces eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	g := buildCFG(prg)
+	switch {
+	case *check:
+		if diff := g.checkAgainst(prg); diff != "" {
+			return fmt.Errorf("cfg: %s", diff)
+		}
+		fmt.Printf("ok\n")
+	case *dot:
+		fmt.Print(g.dot())
+	default:
+		fmt.Print(g.text())
+	}
+	return nil
+}
+
+func cmdOpt(args []string) error {
+	fs := flag.NewFlagSet("opt", flag.ContinueOnError)
+	check := fs.Bool("check", false, "check that the optimized program gives the same output and state")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	opt := optimizeProg(prg)
+	if *check {
+		if diff := diffEval(prg, opt); diff != "" {
+			return fmt.Errorf("opt: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	fmt.Printf("%s\n", opt.pretty())
+	return nil
+}
+
+func cmdDce(args []string) error {
+	fs := flag.NewFlagSet("dce", flag.ContinueOnError)
+	check := fs.Bool("check", false, "check that the resulting program gives the same output")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	result, removed := eliminateDeadCode(prg)
+	if *check {
+		// the final state may differ, since removed variables are never observed
+		out1, _ := evalCaptured(prg)
+		out2, _ := evalCaptured(result)
+		if out1 != out2 {
+			return fmt.Errorf("dce: output differs:\n--- original\n%s\n--- transformed\n%s", out1, out2)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	fmt.Printf("%s\n", result.pretty())
+	for _, r := range removed {
+		fmt.Printf("removed %s at %s: %s\n", headline(r.stmt), r.path, r.reason)
+	}
+	return nil
+}
+
+func cmdLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	warnings := lintProg(prg)
+	for _, w := range warnings {
+		fmt.Printf("%s\n", w)
+	}
+	if len(warnings) > 0 {
+		if len(warnings) == 1 {
+			return fmt.Errorf("lint: 1 warning")
+		}
+		return fmt.Errorf("lint: %d warnings", len(warnings))
+	}
+	return nil
+}
+
+func cmdBindings(args []string) error {
+	fs := flag.NewFlagSet("bindings", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	fmt.Print(showBindings(prg))
+	return nil
+}
+
+func cmdRename(args []string) error {
+	fs := flag.NewFlagSet("rename", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the JSON encoding of the renamed program")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return fmt.Errorf("rename: expected a program, a position and a new name")
+	}
+	prg, err := loadProg(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	pos, err := parsePosition(prg, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	renamed, err := rename(prg, pos, fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		data, err := marshalProg(renamed)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	fmt.Printf("%s\n", renamed.pretty())
+	return nil
+}
+
+// parses a position in a program, either a path or LINE:NAME for the first reference to a variable on a line
+func parsePosition(prg Prog, arg string) (Path, error) {
+	if i := strings.Index(arg, ":"); i >= 0 {
+		line, err := strconv.Atoi(arg[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid position %q", arg)
+		}
+		return findReference(prg, line, arg[i+1:])
+	}
+	return parsePath(arg)
+}
+
+func cmdStep(args []string) error {
+	fs := flag.NewFlagSet("step", flag.ContinueOnError)
+	full := fs.Bool("full", false, "print the whole remaining statement of each configuration")
+	max := fs.Int("max", 10000, "stop after this many steps (0 for no limit)")
+	check := fs.Bool("check", false, "check that the final state and output agree with eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkSmallStep(prg); diff != "" {
+			return fmt.Errorf("step: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	var configs []config
+	var finished bool
+	captureOutput(func() { configs, finished = smallStepTrace(prg, *max) })
+	fmt.Print(showConfigs(configs, *full))
+	if !finished {
+		return fmt.Errorf("step: stopped after %d steps", *max)
+	}
+	return nil
+}
+
+func cmdDerive(args []string) error {
+	fs := flag.NewFlagSet("derive", flag.ContinueOnError)
+	types := fs.Bool("types", false, "derive the type check instead of the evaluation")
+	latex := fs.Bool("latex", false, "print LaTeX source for the bussproofs package")
+	check := fs.Bool("check", false, "check that the derivations agree with eval and check")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkDerivation(prg); diff != "" {
+			return fmt.Errorf("derive: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	var d *derivation
+	if *types {
+		d, _ = deriveCheck(prg)
+	} else {
+		captureOutput(func() { d = deriveEval(prg) })
+	}
+	if *latex {
+		fmt.Print(d.bussproofs())
+	} else {
+		fmt.Print(d.textTree())
+	}
+	return nil
+}
+
+func cmdDebug(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", prg.pretty())
+	debugProg(prg, os.Stdin, os.Stdout)
+	return nil
+}
+
+func cmdTrace(args []string) error {
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+	file := fs.String("o", "", "write the trace to this file instead of stdout")
+	check := fs.Bool("check", false, "check that tracing gives the same output and state as eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkTrace(prg); diff != "" {
+			return fmt.Errorf("trace: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	w := os.Stdout
+	if *file != "" {
+		if w, err = os.Create(*file); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	captureOutput(func() {
+		traceEval(prg, func(e traceEvent) { fmt.Fprintln(w, e.json()) })
+	})
+	return nil
+}
+
+func cmdReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	traceFile := fs.String("trace", "", "replay a trace written by imp trace")
+	check := fs.Bool("check", false, "check that replaying reproduces the states of eval")
+	var rec *recording
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *traceFile != "" {
+		if fs.NArg() != 0 {
+			return fmt.Errorf("replay: expected either a program or --trace")
+		}
+		f, err := os.Open(*traceFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if rec, err = readRecording(f); err != nil {
+			return fmt.Errorf("%s: %s", *traceFile, err)
+		}
+	} else {
+		prg, err := parseProgArgs(fs, args)
+		if err != nil {
+			return err
+		}
+		if *check {
+			if diff := checkReplay(prg); diff != "" {
+				return fmt.Errorf("replay: %s", diff)
+			}
+			fmt.Printf("ok\n")
+			return nil
+		}
+		rec = recordProg(prg)
+	}
+	replay(rec, os.Stdin, os.Stdout)
+	return nil
+}
+
+func cmdProfile(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	top := fs.Int("top", 10, "number of statements to report (0 for all)")
+	pprofFile := fs.String("pprof", "", "write the profile in the pprof format to this file")
+	check := fs.Bool("check", false, "check that profiling gives the same output and state as eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkProfile(prg); diff != "" {
+			return fmt.Errorf("profile: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	var prof *profile
+	captureOutput(func() { prof, _ = profileEval(prg) })
+	fmt.Print(prof.report(*top))
+	if *pprofFile != "" {
+		data, err := prof.pprof(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*pprofFile, data, 0644)
+	}
+	return nil
+}
+
+func cmdCover(args []string) error {
+	fs := flag.NewFlagSet("cover", flag.ContinueOnError)
+	dataFile := fs.String("data", "", "merge the coverage into this file (created if it doesn't exist)")
+	lcovFile := fs.String("lcov", "", "write the coverage in the LCOV format to this file")
+	check := fs.Bool("check", false, "check that collecting coverage gives the same output and state as eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkCoverage(prg); diff != "" {
+			return fmt.Errorf("cover: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	cov := newCoverage(prg)
+	captureOutput(func() { cov.run(prg) })
+	if *dataFile != "" {
+		data, err := os.ReadFile(*dataFile)
+		switch {
+		case err == nil:
+			old, err := unmarshalCoverage(data)
+			if err != nil {
+				return fmt.Errorf("%s: %s", *dataFile, err)
+			}
+			if err := cov.merge(old); err != nil {
+				return fmt.Errorf("%s: %s", *dataFile, err)
+			}
+		case !os.IsNotExist(err):
+			return err
+		}
+		if data, err = cov.marshal(); err != nil {
+			return err
+		}
+		if err := os.WriteFile(*dataFile, data, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Print(cov.listing(prg))
+	fmt.Print(cov.summary())
+	if *lcovFile != "" {
+		// the line numbers are those of the pretty printed program, which is written next to the LCOV file
+		source := strings.TrimSuffix(*lcovFile, filepath.Ext(*lcovFile)) + ".imp"
+		if source == *lcovFile {
+			source += ".imp"
+		}
+		if err := os.WriteFile(source, []byte(prg.pretty()+"\n"), 0644); err != nil {
+			return err
+		}
+		return os.WriteFile(*lcovFile, []byte(cov.lcov(prg, source)), 0644)
+	}
+	return nil
+}
+
+func cmdIntervals(args []string) error {
+	fs := flag.NewFlagSet("intervals", flag.ContinueOnError)
+	check := fs.Bool("check", false, "check that the states of eval are among the possible values")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkAnalysis(prg, intervalDomain{}); diff != "" {
+			return fmt.Errorf("intervals: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	fmt.Print(showAnalysis(prg, intervalDomain{}))
+	return nil
+}
+
+func cmdAbsint(args []string) error {
+	fs := flag.NewFlagSet("absint", flag.ContinueOnError)
+	names := fs.String("domain", "interval", "the domain for integers, or a comma-separated list for their product")
+	check := fs.Bool("check", false, "check that the states of eval are among the possible values")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	d, err := domainByNames(*names)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkAnalysis(prg, d); diff != "" {
+			return fmt.Errorf("absint: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	fmt.Print(showAnalysis(prg, d))
+	return nil
+}
+
+func cmdSymex(args []string) error {
+	fs := flag.NewFlagSet("symex", flag.ContinueOnError)
+	bound := fs.Int("bound", 5, "the maximal number of iterations of a loop")
+	paths := fs.Int("paths", maxSymPaths, "the number of paths from which on forking paths are cut off")
+	names := fs.String("inputs", "", "a comma-separated list of the variables that are inputs (default: all)")
+	check := fs.Bool("check", false, "check that the path taken with the literals agrees with eval")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *check {
+		if diff := checkSymbolic(prg, *bound, *paths); diff != "" {
+			return fmt.Errorf("symex: %s", diff)
+		}
+		fmt.Printf("ok\n")
+		return nil
+	}
+	var inputNames []string
+	if *names != "" {
+		inputNames = strings.Split(*names, ",")
+	}
+	inputs := symInputs(prg, inputNames)
+	fmt.Print(showSymbolic(inputs, symExec(prg, inputs, *bound, *paths)))
+	return nil
+}
+
+func cmdSolve(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("solve needs at least one condition")
+	}
+	var conds []Exp
+	for _, arg := range args {
+		e, err := parseExp(arg)
+		if err != nil {
+			return fmt.Errorf("%q: %s", arg, err)
+		}
+		conds = append(conds, e)
+	}
+	res, model := solve(conds, nil)
+	fmt.Println(res)
+	if res == sat {
+		fmt.Println(showState(model))
+	}
+	return nil
+}
+
+func cmdTestgen(args []string) error {
+	fs := flag.NewFlagSet("testgen", flag.ContinueOnError)
+	bound := fs.Int("bound", 5, "the maximal number of iterations of a loop")
+	paths := fs.Int("paths", maxSymPaths, "the number of paths from which on forking paths are cut off")
+	outFile := fs.String("o", "", "write the golden file to this file instead of printing it")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	g, uncovered := testgen(prg, *bound, *paths)
+	g.Program = fs.Arg(0)
+	if *outFile != "" {
+		if g.Program, err = relativeProgram(fs.Arg(0), *outFile); err != nil {
+			return err
+		}
+	}
+	data, err := g.marshal()
+	if err != nil {
+		return err
+	}
+	if *outFile == "" {
+		fmt.Print(string(data))
+		return nil
+	}
+	if err := os.WriteFile(*outFile, data, 0644); err != nil {
+		return err
+	}
+	fmt.Print(testgenSummary(g, uncovered))
+	return nil
+}
+
+func cmdGolden(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("golden needs at least one golden file")
+	}
+	failed := 0
+	for _, file := range args {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		g, err := unmarshalGolden(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		prg, err := loadProg(resolveProgram(g.Program, file))
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		failures := replayGolden(prg, g)
+		for _, f := range failures {
+			fmt.Printf("FAIL %s %s\n", file, f)
+		}
+		if len(failures) == 0 {
+			fmt.Printf("ok   %s (%d tests)\n", file, len(g.Tests))
+		}
+		failed += len(failures)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tests failed", failed)
+	}
+	return nil
+}
+
+// returns the program named relative to the directory of a file, the name of an example is kept
+func relativeProgram(arg, file string) (string, error) {
+	if _, ok := examples[arg]; ok {
+		return arg, nil
+	}
+	prog, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, prog)
+	if err != nil {
+		return prog, nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// returns the program named in a file relative to its directory, the name of an example is kept
+func resolveProgram(name, file string) string {
+	if _, ok := examples[name]; ok || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(file), filepath.FromSlash(name))
+}
+
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	specFile := fs.String("spec", "", "a JSON file with the annotations")
+	requires := fs.String("requires", "", "the precondition, about the inputs")
+	ensures := fs.String("ensures", "", "the postcondition")
+	invariants := keyedFlag(fs, "invariant", "LINE=EXP: the invariant of the loop at LINE (or a path)")
+	showFormulas := fs.Bool("vcs", false, "print the verification conditions")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	spec := &hoareSpec{}
+	if *specFile != "" {
+		data, err := os.ReadFile(*specFile)
+		if err != nil {
+			return err
+		}
+		if spec, err = unmarshalSpec(data); err != nil {
+			return fmt.Errorf("%s: %s", *specFile, err)
+		}
+	}
+	if *requires != "" {
+		spec.Requires = *requires
+	}
+	if *ensures != "" {
+		spec.Ensures = *ensures
+	}
+	for k, inv := range invariants {
+		if spec.Invariants == nil {
+			spec.Invariants = make(map[string]string)
+		}
+		spec.Invariants[k] = inv
+	}
+	vcs, err := verify(prg, spec)
+	if err != nil {
+		return err
+	}
+	fmt.Print(showVerification(vcs, *showFormulas))
+	if refuted, unknowns := verificationFailures(vcs); refuted+unknowns > 0 {
+		return fmt.Errorf("%d conditions refuted, %d unknown", refuted, unknowns)
+	}
+	return nil
+}
+
+// adds a flag like --invariant LINE=EXP, which can be given several times
+func keyedFlag(fs *flag.FlagSet, name, usage string) map[string]string {
+	values := make(map[string]string)
+	fs.Func(name, usage, func(s string) error {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return fmt.Errorf("%q isn't KEY=EXP", s)
+		}
+		values[s[:i]] = s[i+1:]
+		return nil
+	})
+	return values
+}
+
+// runs imp wp or imp sp
+func cmdPredicate(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	cond := fs.String("post", "true", "the postcondition")
+	if cmd == "sp" {
+		cond = fs.String("pre", "true", "the precondition")
+	}
+	at := fs.String("at", "", "the path of a statement of the program to use instead of the program")
+	srcs := keyedFlag(fs, "invariant", "LINE=EXP: the invariant of the loop at LINE (or a path)")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	var stmt Stmt = prg
+	var p Path
+	if *at != "" {
+		if p, err = parsePath(*at); err != nil {
+			return err
+		}
+		n, err := nodeAt(prg, p)
+		if err != nil {
+			return err
+		}
+		s, ok := n.(Stmt)
+		if !ok {
+			return fmt.Errorf("%s is no statement", *at)
+		}
+		stmt = s
+	}
+	e, err := parseExp(*cond)
+	if err != nil {
+		return fmt.Errorf("%q: %s", *cond, err)
+	}
+	invariants, err := invariantsAt(prg, p, srcs)
+	if err != nil {
+		return err
+	}
+	var res Exp
+	if cmd == "wp" {
+		res, err = WP(stmt, e, invariants...)
+	} else {
+		res, err = SP(e, stmt, invariants...)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(res.pretty())
+	return nil
+}
+
+func cmdBMC(args []string) error {
+	fs := flag.NewFlagSet("bmc", flag.ContinueOnError)
+	bound := fs.Int("bound", 5, "the maximal number of iterations of a loop")
+	srcs := keyedFlag(fs, "assert", "LINE=EXP: an assertion before the statement at LINE (or a path, or end)")
+	names := fs.String("inputs", "", "a comma-separated list of the variables that are inputs (default: all)")
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	var inputNames []string
+	if *names != "" {
+		inputNames = strings.Split(*names, ",")
+	}
+	if len(srcs) == 0 {
+		return fmt.Errorf("bmc needs at least one assertion")
+	}
+	asserts, err := bmcAssertions(prg, srcs)
+	if err != nil {
+		return err
+	}
+	results, cut, err := bmc(prg, inputNames, asserts, *bound)
+	if err != nil {
+		return err
+	}
+	fmt.Print(showBMC(prg, results, cut, *bound))
+	violated := 0
+	for _, r := range results {
+		if r.result == sat {
+			violated++
+		}
+	}
+	if violated > 0 {
+		return fmt.Errorf("%d assertions violated", violated)
+	}
+	return nil
+}
+
+func cmdTermination(args []string) error {
+	fs := flag.NewFlagSet("termination", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	results := terminationOf(prg)
+	fmt.Print(showTermination(results))
+	if nonTerminating, unknowns := terminationFailures(results); nonTerminating+unknowns > 0 {
+		return fmt.Errorf("%d loops possibly non-terminating, %d unknown", nonTerminating, unknowns)
+	}
+	return nil
+}
+
+func cmdDefUse(args []string) error {
+	fs := flag.NewFlagSet("defuse", flag.ContinueOnError)
+	prg, err := parseProgArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	fmt.Print(showChains(prg, reachingDefinitions(prg)))
+	return nil
+}
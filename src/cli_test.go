@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseFlagsAfterArguments(t *testing.T) {
+	tests := map[string]string{
+		"ex01 --check":          "ex01",
+		"--check ex01":          "ex01",
+		"ex01 --top 3 ex02":     "ex01 ex02",
+		"--top 3 -- ex01 --top": "ex01 --top",
+	}
+	for args, want := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		check := fs.Bool("check", false, "")
+		top := fs.Int("top", 0, "")
+		if err := parseFlags(fs, strings.Fields(args)); err != nil {
+			t.Errorf("%s: %s", args, err)
+			continue
+		}
+		if got := strings.Join(fs.Args(), " "); got != want {
+			t.Errorf("%s: the arguments are %q instead of %q", args, got, want)
+		}
+		if strings.Contains(args, "--check") != *check || strings.Contains(args, "--top 3") != (*top == 3) {
+			t.Errorf("%s: the flags are --check=%t --top=%d", args, *check, *top)
+		}
+	}
+}
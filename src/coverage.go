@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// code coverage
+//
+// coverage.run evaluates a program like Prog.eval and counts
+//
+//	for every statement, how often it was executed
+//	for every if statement, how often the then and else blocks ran (and how often the condition failed)
+//	for every while statement, how often the loop ended after zero, one or several iterations
+//	  (and how often the condition failed)
+//	for every And and Or, how often the left side alone decided the result (false && ..., true || ...),
+//	  how often both sides were needed and how often the result was undefined
+//
+// the interpreter always evaluates both sides of And and Or, so "short-circuited" means that the value of
+// the right side didn't matter. Coverage of several runs of the same program can be merged.
+
+type coverage struct {
+	// the pretty printed program, coverage can only be merged for the same program
+	Program string `json:"program"`
+	Runs    int    `json:"runs"`
+	// executions of statements, by path
+	Stmts map[string]int `json:"stmts"`
+	// then, else, failed condition, by path
+	Ifs map[string][3]int `json:"ifs"`
+	// loops ending after zero, one, several iterations, failed condition, by path
+	Loops map[string][4]int `json:"loops"`
+	// left side decided, both sides needed, undefined, by path
+	ShortCircuits map[string][3]int `json:"shortCircuits"`
+}
+
+func newCoverage(prg Prog) *coverage {
+	c := &coverage{Program: prg.pretty(), Stmts: make(map[string]int), Ifs: make(map[string][3]int),
+		Loops: make(map[string][4]int), ShortCircuits: make(map[string][3]int)}
+	// every statement, branch and operator is known, even if it is never executed
+	walkPaths(prg, func(n Node, p Path) bool {
+		switch n.(type) {
+		case Decl, Assign, Print:
+			c.Stmts[p.String()] = 0
+		case IfThenElse:
+			c.Stmts[p.String()] = 0
+			c.Ifs[p.String()] = [3]int{}
+		case While:
+			c.Stmts[p.String()] = 0
+			c.Loops[p.String()] = [4]int{}
+		case And, Or:
+			c.ShortCircuits[p.String()] = [3]int{}
+		}
+		return true
+	})
+	return c
+}
+
+// evaluates a program in a fresh state like Prog.eval, adding its coverage to c
+// returns the final state
+func (c *coverage) run(prg Prog) ValState {
+	s := make(map[string]Val)
+	c.Runs++
+	c.hooks().exec(prg, Path{}, s)
+	return s
+}
+
+// counts the And and Or operators of an expression at path p
+func (c *coverage) exp(e Exp, p Path, s ValState) {
+	walkPaths(e, func(n Node, q Path) bool {
+		var left, right Val
+		var decided bool
+		switch n := n.(type) {
+		case And:
+			left, right = n[0].eval(s), n[1].eval(s)
+			decided = left.flag == ValueBool && !left.valB
+		case Or:
+			left, right = n[0].eval(s), n[1].eval(s)
+			decided = left.flag == ValueBool && left.valB
+		default:
+			return true
+		}
+		key := append(append(Path{}, p...), q...).String()
+		counts := c.ShortCircuits[key]
+		switch {
+		case decided:
+			counts[0]++
+		case left.flag == ValueBool && right.flag == ValueBool:
+			counts[1]++
+		default:
+			counts[2]++
+		}
+		c.ShortCircuits[key] = counts
+		return true
+	})
+}
+
+// returns the hooks counting the coverage of an evaluation
+func (c *coverage) hooks() *evalHooks {
+	// the iterations of the running loops, by path
+	iterations := make(map[string]int)
+	return &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			switch stmt.(type) {
+			case Decl, Assign, Print, IfThenElse, While:
+				c.Stmts[p.String()]++
+			}
+			return true
+		},
+		exp: c.exp,
+		enter: func(owner Stmt, p Path, s1, s2 ValState) {
+			iterations[p.String()] = 0
+		},
+		cond: func(owner Stmt, p Path, s ValState, v Val, output string) {
+			if _, ok := owner.(While); ok {
+				if v.flag == ValueBool && v.valB {
+					iterations[p.String()]++
+				}
+				return
+			}
+			counts := c.Ifs[p.String()]
+			switch {
+			case v.flag == ValueBool && v.valB:
+				counts[0]++
+			case v.flag == ValueBool:
+				counts[1]++
+			default:
+				counts[2]++
+			}
+			c.Ifs[p.String()] = counts
+		},
+		exit: func(owner Stmt, p Path, s1, s3 ValState) {
+			if _, ok := owner.(While); !ok {
+				return
+			}
+			counts := c.Loops[p.String()]
+			n := iterations[p.String()]
+			if n > 2 {
+				n = 2
+			}
+			counts[n]++
+			if s3 == nil {
+				// the condition failed
+				counts[3]++
+			}
+			c.Loops[p.String()] = counts
+		},
+	}
+}
+
+// adds the coverage of other runs of the same program
+func (c *coverage) merge(other *coverage) error {
+	if other.Program != c.Program {
+		return fmt.Errorf("coverage: the coverage data is for another program")
+	}
+	c.Runs += other.Runs
+	for k, n := range other.Stmts {
+		c.Stmts[k] += n
+	}
+	for k, counts := range other.Ifs {
+		sum := c.Ifs[k]
+		for i := range counts {
+			sum[i] += counts[i]
+		}
+		c.Ifs[k] = sum
+	}
+	for k, counts := range other.Loops {
+		sum := c.Loops[k]
+		for i := range counts {
+			sum[i] += counts[i]
+		}
+		c.Loops[k] = sum
+	}
+	for k, counts := range other.ShortCircuits {
+		sum := c.ShortCircuits[k]
+		for i := range counts {
+			sum[i] += counts[i]
+		}
+		c.ShortCircuits[k] = sum
+	}
+	return nil
+}
+
+func (c *coverage) marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func unmarshalCoverage(data []byte) (*coverage, error) {
+	c := &coverage{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("coverage: %s", err)
+	}
+	if c.Stmts == nil || c.Ifs == nil || c.Loops == nil || c.ShortCircuits == nil {
+		return nil, fmt.Errorf("coverage: incomplete coverage data")
+	}
+	return c, nil
+}
+
+// a coverage item of the program, at the line of the pretty printed program it belongs to
+type coverItem struct {
+	path Path
+	line int
+}
+
+// returns the paths of a map of counts, in pre-order, with their lines
+func (c *coverage) items(keys []string, lines map[string]int) []coverItem {
+	var items []coverItem
+	for _, k := range keys {
+		p, _ := parsePath(k)
+		items = append(items, coverItem{p, lineOf(lines, p)})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].path.less(items[j].path) })
+	return items
+}
+
+func keys3(m map[string][3]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// returns the annotations of the branches on each line
+func (c *coverage) branchNotes(prg Prog, lines map[string]int) map[int][]string {
+	notes := make(map[int][]string)
+	for _, it := range c.items(keys3(c.Ifs), lines) {
+		n := c.Ifs[it.path.String()]
+		note := fmt.Sprintf("then %dx, else %dx", n[0], n[1])
+		if n[2] > 0 {
+			note += fmt.Sprintf(", failed %dx", n[2])
+		}
+		notes[it.line] = append(notes[it.line], note)
+	}
+	var loopKeys []string
+	for k := range c.Loops {
+		loopKeys = append(loopKeys, k)
+	}
+	for _, it := range c.items(loopKeys, lines) {
+		n := c.Loops[it.path.String()]
+		note := fmt.Sprintf("ran 0 times %dx, once %dx, several times %dx", n[0], n[1], n[2])
+		if n[3] > 0 {
+			note += fmt.Sprintf(", failed %dx", n[3])
+		}
+		notes[it.line] = append(notes[it.line], note)
+	}
+	for _, it := range c.items(keys3(c.ShortCircuits), lines) {
+		n := c.ShortCircuits[it.path.String()]
+		e, _ := nodeAt(prg, it.path)
+		note := fmt.Sprintf("%s: left side decided %dx, both sides %dx", e.pretty(), n[0], n[1])
+		if n[2] > 0 {
+			note += fmt.Sprintf(", undefined %dx", n[2])
+		}
+		notes[it.line] = append(notes[it.line], note)
+	}
+	return notes
+}
+
+// returns the pretty printed program, each line with the executions of its statement
+// never executed statements are marked with #####, branches are annotated at the end of the line
+func (c *coverage) listing(prg Prog) string {
+	lines := lineNumbers(prg)
+	counts := make(map[int]int)
+	for k, n := range c.Stmts {
+		line := lines[k]
+		if _, seen := counts[line]; !seen || n > counts[line] {
+			counts[line] = n
+		}
+	}
+	notes := c.branchNotes(prg, lines)
+	var b strings.Builder
+	for i, text := range strings.Split(prg.pretty(), "\n") {
+		count := ""
+		if n, ok := counts[i+1]; ok {
+			count = "#####"
+			if n > 0 {
+				count = fmt.Sprint(n)
+			}
+		}
+		fmt.Fprintf(&b, "%6s %4d  %s", count, i+1, text)
+		if len(notes[i+1]) > 0 {
+			fmt.Fprintf(&b, "    // %s", strings.Join(notes[i+1], "; "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// returns a summary: covered statements and branches
+func (c *coverage) summary() string {
+	covered := 0
+	for _, n := range c.Stmts {
+		if n > 0 {
+			covered++
+		}
+	}
+	found, hit := c.branchTotals()
+	return fmt.Sprintf("%d runs, statements %d/%d, branches %d/%d\n", c.Runs, covered, len(c.Stmts), hit, found)
+}
+
+// returns the number of branches and covered branches
+func (c *coverage) branchTotals() (found, hit int) {
+	count := func(ns ...int) {
+		for _, n := range ns {
+			found++
+			if n > 0 {
+				hit++
+			}
+		}
+	}
+	for _, n := range c.Ifs {
+		count(n[0], n[1])
+	}
+	for _, n := range c.Loops {
+		count(n[0], n[1], n[2])
+	}
+	for _, n := range c.ShortCircuits {
+		count(n[0], n[1])
+	}
+	return found, hit
+}
+
+// returns the coverage in the LCOV format, for the pretty printed program in the file source
+// if and while statements and And and Or operators are branch blocks:
+// then/else, zero/one/several iterations, left side decided/both sides needed
+func (c *coverage) lcov(prg Prog, source string) string {
+	lines := lineNumbers(prg)
+	var b strings.Builder
+	fmt.Fprintf(&b, "TN:\nSF:%s\n", source)
+
+	var stmtKeys []string
+	for k := range c.Stmts {
+		stmtKeys = append(stmtKeys, k)
+	}
+	block := 0
+	brda := func(line int, ns ...int) {
+		total := 0
+		for _, n := range ns {
+			total += n
+		}
+		for i, n := range ns {
+			taken := fmt.Sprint(n)
+			if total == 0 {
+				// the branch block was never reached
+				taken = "-"
+			}
+			fmt.Fprintf(&b, "BRDA:%d,%d,%d,%s\n", line, block, i, taken)
+		}
+		block++
+	}
+	var branchKeys []string
+	for k := range c.Ifs {
+		branchKeys = append(branchKeys, k)
+	}
+	for k := range c.Loops {
+		branchKeys = append(branchKeys, k)
+	}
+	for k := range c.ShortCircuits {
+		branchKeys = append(branchKeys, k)
+	}
+	for _, it := range c.items(branchKeys, lines) {
+		k := it.path.String()
+		if n, ok := c.Ifs[k]; ok {
+			brda(it.line, n[0], n[1])
+		} else if n, ok := c.Loops[k]; ok {
+			brda(it.line, n[0], n[1], n[2])
+		} else {
+			n := c.ShortCircuits[k]
+			brda(it.line, n[0], n[1])
+		}
+	}
+	found, hit := c.branchTotals()
+	fmt.Fprintf(&b, "BRF:%d\nBRH:%d\n", found, hit)
+
+	// one DA record per line, with the executions of the statement on it
+	perLine := make(map[int]int)
+	for _, it := range c.items(stmtKeys, lines) {
+		if n, seen := perLine[it.line]; !seen || c.Stmts[it.path.String()] > n {
+			perLine[it.line] = c.Stmts[it.path.String()]
+		}
+	}
+	var ls []int
+	for l := range perLine {
+		ls = append(ls, l)
+	}
+	sort.Ints(ls)
+	linesHit := 0
+	for _, l := range ls {
+		fmt.Fprintf(&b, "DA:%d,%d\n", l, perLine[l])
+		if perLine[l] > 0 {
+			linesHit++
+		}
+	}
+	fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", len(ls), linesHit)
+	return b.String()
+}
+
+// checks that collecting coverage gives the same output and final state as Prog.eval
+// returns a description of the first difference, or an empty string
+func checkCoverage(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	var gotState ValState
+	gotOut := captureOutput(func() { gotState = newCoverage(prg).run(prg) })
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- coverage\n%s", wantOut, gotOut)
+	}
+	if !sameState(gotState, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, coverage %s", showState(wantState), showState(gotState))
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestCoverageKeepsResults(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkCoverage(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestCoverageCounts(t *testing.T) {
+	prg := failingLoop()
+	c := newCoverage(prg)
+	captureOutput(func() { c.run(prg) })
+	// the loop ends after two iterations, when the condition fails
+	if got, want := c.Loops["0.0.1.0"], [4]int{0, 0, 1, 1}; got != want {
+		t.Errorf("failingLoop: loop counts %v instead of %v", got, want)
+	}
+	if got, want := c.Stmts["0.0.1.0.1.0"], 2; got != want {
+		t.Errorf("failingLoop: the body was executed %d times instead of %d", got, want)
+	}
+	// x<2 decides the Or twice, then y is undefined
+	if got, want := c.ShortCircuits["0.0.1.0.0"], [3]int{2, 0, 1}; got != want {
+		t.Errorf("failingLoop: Or counts %v instead of %v", got, want)
+	}
+
+	prg = failingIf()
+	c = newCoverage(prg)
+	captureOutput(func() { c.run(prg) })
+	if got, want := c.Ifs["0.0.1.0"], [3]int{0, 0, 1}; got != want {
+		t.Errorf("failingIf: counts of the failing if %v instead of %v", got, want)
+	}
+	if got, want := c.Stmts["0.0.1.0.1.0"], 0; got != want {
+		t.Errorf("failingIf: the then block of the failing if was executed %d times", got)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import "sort"
+
+// dead-code and unused-variable elimination
+//
+// a backward liveness analysis finds declarations and assignments whose values are never observed,
+// i.e. never printed or used in a condition (directly or through other variables).
+// The scoping rules are taken into account: a variable declared in a nested scope is dropped at the end
+// of the scope, and ValState.update only lets values of the same kind leave it. The kinds of variables
+// are tracked as in the optimizer (see kindEnv).
+// Print statements are always kept, and so are assignments that might fail, since they print an error.
+
+// a set of variable names
+type varSet map[string]bool
+
+func (vs varSet) copy() varSet {
+	c := make(varSet)
+	for k := range vs {
+		c[k] = true
+	}
+	return c
+}
+func (vs varSet) addAll(other varSet) {
+	for k := range other {
+		vs[k] = true
+	}
+}
+func (vs varSet) equal(other varSet) bool {
+	if len(vs) != len(other) {
+		return false
+	}
+	for k := range vs {
+		if !other[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// returns the names in the set, sorted
+func (vs varSet) sorted() []string {
+	var names []string
+	for k := range vs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the variables an expression reads
+func expVars(e Exp) varSet {
+	vs := make(varSet)
+	walkPre(e, func(n Node) bool {
+		if v, ok := n.(Var); ok {
+			vs[string(v)] = true
+		}
+		return true
+	})
+	return vs
+}
+
+// returns the variables a statement declares or assigns, including in nested scopes
+func modifiedVars(s Stmt) varSet {
+	vs := make(varSet)
+	walkPre(s, func(n Node) bool {
+		switch n := n.(type) {
+		case Assign:
+			vs[n.lhs] = true
+		case Decl:
+			vs[n.lhs] = true
+		}
+		return true
+	})
+	return vs
+}
+
+// a statement removed by the dead-code elimination
+type removal struct {
+	path   Path
+	stmt   Stmt
+	reason string
+}
+
+// removes declarations and assignments whose values are never observed
+// returns the resulting program and the removed statements (with their paths in the original program)
+func eliminateDeadCode(prg Prog) (Prog, []removal) {
+	d := &deadCode{}
+	b, _, _ := d.block(prg[0], Path{0}, make(kindEnv), make(varSet))
+	sort.Slice(d.removed, func(i, j int) bool { return d.removed[i].path.less(d.removed[j].path) })
+	return Prog{b}, d.removed
+}
+
+type deadCode struct {
+	removed []removal
+}
+
+func (d *deadCode) remove(p Path, s Stmt, reason string) {
+	d.removed = append(d.removed, removal{p, s, reason})
+}
+
+// a statement of a flattened sequence together with its path
+type pathStmt struct {
+	stmt Stmt
+	path Path
+}
+
+// returns the statements of a chain of sequences with their paths
+func flattenSeqPaths(s Stmt, p Path) []pathStmt {
+	if seq, ok := s.(Seq); ok {
+		return append(flattenSeqPaths(seq[0], p.child(0)), flattenSeqPaths(seq[1], p.child(1))...)
+	}
+	return []pathStmt{{s, p}}
+}
+
+// updates the kinds of variables with the declarations of a statement in the current scope
+func declareKinds(s Stmt, env kindEnv) {
+	switch s := s.(type) {
+	case Block:
+		declareKinds(s[0], env)
+	case Seq:
+		declareKinds(s[0], env)
+		declareKinds(s[1], env)
+	case Decl:
+		env[s.lhs] = env.kinds(s.rhs)
+	}
+}
+
+// eliminates dead code in a block, env holds the kinds of variables before the block,
+// live the variables that are observed after the block
+// returns the new block, the variables observed before the block and whether all its statements were removed
+// a block keeps at least one statement, so if everything is removed, the last statement is restored
+func (d *deadCode) block(b Block, p Path, env kindEnv, live varSet) (Block, varSet, bool) {
+	n := len(d.removed)
+	items := flattenSeqPaths(b[0], p.child(0))
+	stmts, liveIn := d.list(items, env, live)
+	if len(stmts) > 0 {
+		return Block{generateSeq(stmts)}, liveIn, false
+	}
+	// the statements before the restored one are eliminated again, given what it reads and writes
+	d.removed = d.removed[:n]
+	last := items[len(items)-1]
+	liveLast := live.copy()
+	liveLast.addAll(stmtVars(last.stmt))
+	stmts, liveIn = d.list(items[:len(items)-1], env, liveLast)
+	return Block{generateSeq(append(stmts, last.stmt))}, liveIn, true
+}
+
+// returns the variables a statement reads or writes
+func stmtVars(s Stmt) varSet {
+	vs := make(varSet)
+	walkPre(s, func(n Node) bool {
+		switch n := n.(type) {
+		case Var:
+			vs[string(n)] = true
+		case Decl:
+			vs[n.lhs] = true
+		case Assign:
+			vs[n.lhs] = true
+		}
+		return true
+	})
+	return vs
+}
+
+// returns the kinds of variables before each statement of a list
+func kindEnvs(items []pathStmt, env kindEnv) []kindEnv {
+	envs := make([]kindEnv, len(items))
+	cur := env.copy()
+	for i, item := range items {
+		envs[i] = cur.copy()
+		declareKinds(item.stmt, cur)
+	}
+	return envs
+}
+
+// eliminates dead code in a list of statements, processing them backwards
+func (d *deadCode) list(items []pathStmt, env kindEnv, live varSet) ([]Stmt, varSet) {
+	envs := kindEnvs(items, env)
+	var kept []Stmt
+	for i := len(items) - 1; i >= 0; i-- {
+		s, removed, liveIn := d.stmt(items[i].stmt, items[i].path, envs[i], live)
+		if !removed {
+			kept = append([]Stmt{s}, kept...)
+		}
+		live = liveIn
+	}
+	return kept, live
+}
+
+// eliminates dead code in a statement
+// returns the new statement, whether it was removed and the variables observed before it
+func (d *deadCode) stmt(s Stmt, p Path, env kindEnv, live varSet) (Stmt, bool, varSet) {
+	switch s := s.(type) {
+	case Block:
+		b, liveIn, _ := d.block(s, p, env, live)
+		return b, false, liveIn
+	case Seq:
+		stmts, liveIn := d.list(flattenSeqPaths(s, p), env, live)
+		if len(stmts) == 0 {
+			return s, true, liveIn
+		}
+		return generateSeq(stmts), false, liveIn
+	case Decl:
+		if !live[s.lhs] {
+			d.remove(p, s, "the value declared for "+s.lhs+" is never read")
+			return s, true, live
+		}
+		liveIn := live.copy()
+		delete(liveIn, s.lhs)
+		liveIn.addAll(expVars(s.rhs))
+		return s, false, liveIn
+	case Assign:
+		_, declared := env[s.lhs]
+		if !live[s.lhs] && declared && env[s.lhs] == env.kinds(s.rhs) && isSingleKind(env[s.lhs]) {
+			// the assignment never fails, so it can be removed
+			d.remove(p, s, "the value assigned to "+s.lhs+" is never read")
+			return s, true, live
+		}
+		// the assignment needs the variable to be declared with the right kind
+		liveIn := live.copy()
+		liveIn[s.lhs] = true
+		liveIn.addAll(expVars(s.rhs))
+		return s, false, liveIn
+	case Print:
+		liveIn := live.copy()
+		liveIn.addAll(expVars(s.printExp))
+		return s, false, liveIn
+	case IfThenElse:
+		// values of the outer scope may survive the nested scope
+		liveIn := live.copy()
+		n := len(d.removed)
+		th, liveThen, emptyThen := d.block(s.thenBl, p.child(1), env.copy(), leavingScope(live, env, scopeEnd(s.thenBl, env)))
+		el, liveElse, emptyElse := d.block(s.elseBl, p.child(2), env.copy(), leavingScope(live, env, scopeEnd(s.elseBl, env)))
+		liveIn.addAll(liveThen)
+		liveIn.addAll(liveElse)
+		liveIn.addAll(expVars(s.cond))
+		if emptyThen && emptyElse && env.always(s.cond, kindBool) {
+			d.removed = d.removed[:n]
+			d.remove(p, s, "the if statement has no effect")
+			return s, true, live
+		}
+		return IfThenElse{s.cond, th, el}, false, liveIn
+	case While:
+		// the variables observed at the loop head: by the condition, after the loop and in the next iteration
+		end := scopeEnd(s.do, env)
+		head := live.copy()
+		head.addAll(expVars(s.cond))
+		n := len(d.removed)
+		var do Block
+		for {
+			d.removed = d.removed[:n]
+			var liveBody varSet
+			do, liveBody, _ = d.block(s.do, p.child(1), env.copy(), leavingScope(head, env, end))
+			next := head.copy()
+			next.addAll(liveBody)
+			if next.equal(head) {
+				break
+			}
+			head = next
+		}
+		return While{s.cond, do}, false, head
+	}
+	// other statements are kept, assuming they read all variables they mention
+	liveIn := live.copy()
+	liveIn.addAll(stmtVars(s))
+	return s, false, liveIn
+}
+
+// returns the kinds of variables at the end of a nested scope
+func scopeEnd(b Block, env kindEnv) kindEnv {
+	end := env.copy()
+	declareKinds(b, end)
+	return end
+}
+
+// returns the live variables that can leave a nested scope: only variables of the outer scope,
+// whose kind at the end of the nested scope can be the same as in the outer scope
+func leavingScope(live varSet, outer, end kindEnv) varSet {
+	vs := make(varSet)
+	for k := range live {
+		if ko, ok := outer[k]; ok && ko&end[k] != 0 {
+			vs[k] = true
+		}
+	}
+	return vs
+}
+
+// returns whether a set contains exactly one kind
+func isSingleKind(ks kindSet) bool {
+	return ks != 0 && ks&(ks-1) == 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// the final state may differ, since removed variables are never observed
+func TestEliminateDeadCodeKeepsOutput(t *testing.T) {
+	for name, prg := range testPrograms() {
+		result, _ := eliminateDeadCode(prg)
+		out1, _ := evalCaptured(prg)
+		out2, _ := evalCaptured(result)
+		if out1 != out2 {
+			t.Errorf("%s: output differs:\n--- original\n%s\n--- transformed\n%s", name, out1, out2)
+		}
+	}
+}
+
+func TestEliminateDeadCodeRemovals(t *testing.T) {
+	prg := generateProg([]Stmt{
+		declaration("x", number(1)),
+		declaration("y", number(2)),
+		// fails, so it is kept for its error message
+		assignment("y", boolean(true)),
+		sPrint(variable("y")),
+	})
+	_, removed := eliminateDeadCode(prg)
+	if len(removed) != 1 || removed[0].stmt != prg[0][0].(Seq)[0] {
+		t.Errorf("expected only x := 1 to be removed, got %v", removed)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// interactive debugger
+//
+// the debugger evaluates a program like Prog.eval, but pauses before statements to read commands.
+// It pauses before every declaration, assignment and print, before the condition of an if statement
+// and before each evaluation of a while condition (inside the nested scope of the loop).
+// Breakpoints are given by line of the pretty printed program or by path.
+
+const debugHelp = `commands:
+  s, step            run until the next statement
+  n, next            run until the next statement that is not nested in the current one
+  o, out             run until the end of the innermost if or while statement
+  c, continue        run until the next breakpoint
+  b, break POS       set a breakpoint on a line (e.g. 4) or a path (e.g. 0.0.1)
+  d, delete N        delete breakpoint N
+  w, watch EXP       evaluate EXP whenever the program pauses
+  u, unwatch N       delete watch expression N
+  i, info            list breakpoints and watch expressions
+  p, print [EXP]     print the state (with block-local variables), or the value of EXP
+  l, list            print the program, marking the current line and breakpoints
+  where              print the enclosing if and while statements
+  q, quit            stop the program
+  h, help            print this help
+an empty line repeats the last command
+`
+
+type debugMode int
+
+const (
+	debugStep debugMode = iota
+	debugNext
+	debugOut
+	debugContinue
+)
+
+// a nested scope the program is currently in
+type debugFrame struct {
+	owner Stmt
+	path  Path
+	// the state of the enclosing scope
+	outer ValState
+}
+
+// a breakpoint on a path, or on a line if path is nil
+// deleted breakpoints are nil, so the others keep their numbers
+type breakpoint struct {
+	path Path
+	line int
+}
+
+func (b breakpoint) String() string {
+	if b.path == nil {
+		return fmt.Sprintf("line %d", b.line)
+	}
+	return "path " + b.path.String()
+}
+
+type debugger struct {
+	prg         Prog
+	lines       map[string]int
+	breakpoints []*breakpoint
+	watches     []Exp
+	in          *bufio.Scanner
+	w           io.Writer
+	frames      []debugFrame
+	mode        debugMode
+	// next skips the statements nested in anchor, out all statements in anchor
+	anchor Path
+	last   string
+	quit   bool
+}
+
+// runs a program in the debugger, reading commands from in and writing to w
+// the program is paused before its first statement
+func debugProg(prg Prog, in io.Reader, w io.Writer) {
+	d := &debugger{prg: prg, lines: lineNumbers(prg), in: bufio.NewScanner(in), w: w}
+	s := make(map[string]Val)
+	d.hooks().exec(prg, Path{}, s)
+	if d.quit {
+		fmt.Fprintf(w, "\nprogram stopped\n")
+		return
+	}
+	fmt.Fprintf(w, "\nprogram finished, state %s\n", showState(s))
+}
+
+// returns the hooks pausing the evaluation before statements and keeping track of the nested scopes
+func (d *debugger) hooks() *evalHooks {
+	return &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			switch stmt.(type) {
+			case Decl, Assign, Print, IfThenElse:
+				d.pause(stmt, p, s)
+			}
+			return !d.quit
+		},
+		iteration: func(while While, p Path, s2 ValState) bool {
+			d.pause(while, p, s2)
+			return !d.quit
+		},
+		enter: func(owner Stmt, p Path, s1, s2 ValState) {
+			d.frames = append(d.frames, debugFrame{owner, p, s1})
+		},
+		exit: func(owner Stmt, p Path, s1, s3 ValState) {
+			d.frames = d.frames[:len(d.frames)-1]
+		},
+	}
+}
+
+// returns whether a breakpoint is set on the statement at a path
+func (d *debugger) isBreakpoint(p Path) bool {
+	for _, b := range d.breakpoints {
+		if b == nil {
+			continue
+		}
+		if b.path != nil && b.path.String() == p.String() {
+			return true
+		}
+		if b.path == nil && b.line == d.lines[p.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// pauses before a statement, if the current mode or a breakpoint says so, and reads commands
+func (d *debugger) pause(stmt Stmt, p Path, s ValState) {
+	bp := d.isBreakpoint(p)
+	switch {
+	case d.mode == debugNext && len(p) > len(d.anchor) && p.within(d.anchor) && !bp:
+		return
+	case d.mode == debugOut && p.within(d.anchor) && !bp:
+		return
+	case d.mode == debugContinue && !bp:
+		return
+	}
+	if bp && d.mode != debugStep {
+		fmt.Fprintf(d.w, "\nbreakpoint")
+	}
+	fmt.Fprintf(d.w, "\nline %d (%s): %s\n", d.lines[p.String()], p, headline(stmt))
+	d.showWatches(s)
+	for {
+		fmt.Fprintf(d.w, "(imp) ")
+		if !d.in.Scan() {
+			d.quit = true
+			return
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		if d.command(line, p, s) {
+			return
+		}
+	}
+}
+
+// executes a debugger command, returns whether the program continues
+func (d *debugger) command(line string, p Path, s ValState) bool {
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch cmd {
+	case "":
+		return false
+	case "s", "step":
+		d.mode = debugStep
+		return true
+	case "n", "next":
+		d.mode, d.anchor = debugNext, p
+		return true
+	case "o", "out":
+		if len(d.frames) == 0 {
+			d.mode = debugContinue
+			return true
+		}
+		// the head of a while belongs to the nested scope of the loop, so out leaves the loop
+		d.mode, d.anchor = debugOut, d.frames[len(d.frames)-1].path
+		return true
+	case "c", "continue":
+		d.mode = debugContinue
+		return true
+	case "q", "quit":
+		d.quit = true
+		return true
+	case "b", "break":
+		b, err := d.parseBreakpoint(arg)
+		if err != nil {
+			fmt.Fprintf(d.w, "%s\n", err)
+			return false
+		}
+		d.breakpoints = append(d.breakpoints, b)
+		fmt.Fprintf(d.w, "breakpoint %d at %s\n", len(d.breakpoints), b)
+	case "d", "delete":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(d.breakpoints) || d.breakpoints[n-1] == nil {
+			fmt.Fprintf(d.w, "there is no breakpoint %q\n", arg)
+			return false
+		}
+		d.breakpoints[n-1] = nil
+	case "w", "watch":
+		e, err := parseExp(arg)
+		if err != nil {
+			fmt.Fprintf(d.w, "%s\n", err)
+			return false
+		}
+		d.watches = append(d.watches, e)
+		d.showWatches(s)
+	case "u", "unwatch":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(d.watches) || d.watches[n-1] == nil {
+			fmt.Fprintf(d.w, "there is no watch expression %q\n", arg)
+			return false
+		}
+		d.watches[n-1] = nil
+	case "i", "info":
+		for i, b := range d.breakpoints {
+			if b != nil {
+				fmt.Fprintf(d.w, "breakpoint %d at %s\n", i+1, b)
+			}
+		}
+		for i, e := range d.watches {
+			if e != nil {
+				fmt.Fprintf(d.w, "watch %d: %s\n", i+1, e.pretty())
+			}
+		}
+	case "p", "print":
+		if arg == "" {
+			fmt.Fprint(d.w, d.showState(s))
+			return false
+		}
+		e, err := parseExp(arg)
+		if err != nil {
+			fmt.Fprintf(d.w, "%s\n", err)
+			return false
+		}
+		fmt.Fprintf(d.w, "%s = %s\n", e.pretty(), showVal(e.eval(s)))
+	case "l", "list":
+		fmt.Fprint(d.w, d.listing(p))
+	case "where":
+		for i := len(d.frames) - 1; i >= 0; i-- {
+			f := d.frames[i]
+			fmt.Fprintf(d.w, "  in line %d (%s): %s\n", d.lines[f.path.String()], f.path, headline(f.owner))
+		}
+		fmt.Fprintf(d.w, "  in the outermost scope\n")
+	case "h", "help":
+		fmt.Fprint(d.w, debugHelp)
+	default:
+		fmt.Fprintf(d.w, "unknown command %q (h for help)\n", cmd)
+	}
+	return false
+}
+
+// parses a breakpoint position: a line number or a path
+func (d *debugger) parseBreakpoint(arg string) (*breakpoint, error) {
+	if line, err := strconv.Atoi(arg); err == nil {
+		for path, l := range d.lines {
+			p, _ := parsePath(path)
+			if n, _ := nodeAt(d.prg, p); l == line && pausable(n) {
+				return &breakpoint{line: line}, nil
+			}
+		}
+		return nil, fmt.Errorf("there is no statement at line %d", line)
+	}
+	p, err := parsePath(arg)
+	if err != nil {
+		return nil, err
+	}
+	n, err := nodeAt(d.prg, p)
+	if err != nil {
+		return nil, err
+	}
+	if !pausable(n) {
+		return nil, fmt.Errorf("the debugger doesn't pause at %s (%s)", p, headline(n))
+	}
+	return &breakpoint{path: p}, nil
+}
+
+// returns whether the debugger pauses before a node
+func pausable(n Node) bool {
+	switch n.(type) {
+	case Decl, Assign, Print, IfThenElse, While:
+		return true
+	}
+	return false
+}
+
+func (d *debugger) showWatches(s ValState) {
+	for i, e := range d.watches {
+		if e != nil {
+			fmt.Fprintf(d.w, "  watch %d: %s = %s\n", i+1, e.pretty(), showVal(e.eval(s)))
+		}
+	}
+}
+
+// returns the current state, one variable per line, telling which variables are block-local copies
+func (d *debugger) showState(s ValState) string {
+	if len(s) == 0 {
+		return "  (no variables)\n"
+	}
+	var names []string
+	for k := range s {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, x := range names {
+		fmt.Fprintf(&b, "  %s = %s", x, showVal(s[x]))
+		if len(d.frames) > 0 {
+			f := d.frames[len(d.frames)-1]
+			end := "the if statement"
+			if _, ok := f.owner.(While); ok {
+				end = "the loop"
+			}
+			outer, declared := f.outer[x]
+			switch {
+			case !declared:
+				fmt.Fprintf(&b, "  (local, discarded at the end of %s)", end)
+			case outer.flag != s[x].flag:
+				fmt.Fprintf(&b, "  (local, the outer %s = %s has another kind and is kept)", x, showVal(outer))
+			default:
+				fmt.Fprintf(&b, "  (block-local copy of the outer %s = %s, written back at the end of %s)", x, showVal(outer), end)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// returns the pretty printed program with line numbers, the current line marked with > and breakpoints with *
+func (d *debugger) listing(p Path) string {
+	current := d.lines[p.String()]
+	marked := make(map[int]bool)
+	for path, line := range d.lines {
+		if pp, err := parsePath(path); err == nil && d.isBreakpoint(pp) {
+			marked[line] = true
+		}
+	}
+	var b strings.Builder
+	for i, line := range strings.Split(d.prg.pretty(), "\n") {
+		mark := " "
+		if marked[i+1] {
+			mark = "*"
+		}
+		if i+1 == current {
+			mark += ">"
+		} else {
+			mark += " "
+		}
+		fmt.Fprintf(&b, "%s%3d  %s\n", mark, i+1, line)
+	}
+	return b.String()
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// big-step derivation trees for evaluation and type checking
+//
+// deriveEval and deriveCheck do the same as Prog.eval and Prog.check (they run on their hooks, see evalHooks
+// and checkHooks), but additionally record which inference rule fired at each expression and statement, with
+// the input state and the resulting value, state or type. Values and types of expressions are computed by eval
+// and infer themselves.
+// Derivations are rendered as indented text or as LaTeX source for the bussproofs package.
+
+// a node of a derivation tree: the rule, its conclusion (as text and as LaTeX) and the premises
+type derivation struct {
+	rule     string
+	text     string
+	latex    string
+	premises []*derivation
+}
+
+// one-line version of a pretty printed node
+func oneLine(n Node) string {
+	return strings.ReplaceAll(n.pretty(), "\n", " ")
+}
+
+// returns the variables of a state in order
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func latexState(s ValState) string {
+	vals := make(map[string]string)
+	for k, v := range s {
+		vals[k] = latexVal(v)
+	}
+	return latexMap(vals)
+}
+func latexTyState(t TyState) string {
+	types := make(map[string]string)
+	for k, ty := range t {
+		types[k] = "\\mathsf{" + showType(ty) + "}"
+	}
+	return latexMap(types)
+}
+func latexMap(m map[string]string) string {
+	var entries []string
+	for _, k := range sortedKeys(m) {
+		entries = append(entries, "\\mathit{"+latexEscape(k)+"} \\mapsto "+m[k])
+	}
+	return "\\{" + strings.Join(entries, ", ") + "\\}"
+}
+func latexVal(v Val) string {
+	if v.flag == Undefined {
+		return "\\bot"
+	}
+	return "\\texttt{" + showVal(v) + "}"
+}
+func latexCode(n Node) string {
+	return "\\texttt{" + latexEscape(oneLine(n)) + "}"
+}
+
+// escapes characters with a special meaning in LaTeX
+func latexEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '\\':
+			b.WriteString("\\textbackslash{}")
+		case '{', '}', '&', '%', '$', '#', '_':
+			b.WriteString("\\" + string(c))
+		case '^', '~':
+			b.WriteString("\\" + string(c) + "{}")
+		case '<':
+			b.WriteString("\\textless{}")
+		case '>':
+			b.WriteString("\\textgreater{}")
+		case '|':
+			b.WriteString("\\textbar{}")
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// type state as pretty string, with variables sorted by name
+func showTyState(t TyState) string {
+	types := make(map[string]string)
+	for k, ty := range t {
+		types[k] = showType(ty)
+	}
+	var entries []string
+	for _, k := range sortedKeys(types) {
+		entries = append(entries, k+": "+types[k])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// derivations of evaluations
+
+// evaluates a program in a fresh state and records the derivation
+func deriveEval(prg Prog) *derivation {
+	d, _ := deriveEvalState(prg)
+	return d
+}
+
+// evaluates a program in a fresh state and records the derivation, returns it with the final state
+func deriveEvalState(prg Prog) (*derivation, ValState) {
+	r := &deriver{}
+	s := make(map[string]Val)
+	r.hooks().exec(prg, Path{}, s)
+	return r.root, s
+}
+
+func expDerivation(rule string, e Exp, s ValState, v Val, premises ...*derivation) *derivation {
+	return &derivation{
+		rule:     rule,
+		text:     fmt.Sprintf("<%s, %s> => %s", oneLine(e), showState(s), showVal(v)),
+		latex:    fmt.Sprintf("\\langle %s, %s \\rangle \\Downarrow %s", latexCode(e), latexState(s), latexVal(v)),
+		premises: premises,
+	}
+}
+
+// records the derivation of evaluating an expression
+func deriveExp(e Exp, s ValState) (*derivation, Val) {
+	v := e.eval(s)
+	binary := func(rule string, x, y Exp, ok func(v1, v2 Val) bool, undef string) (*derivation, Val) {
+		d1, v1 := deriveExp(x, s)
+		d2, v2 := deriveExp(y, s)
+		if !ok(v1, v2) {
+			rule = undef
+		}
+		return expDerivation(rule, e, s, v, d1, d2), v
+	}
+	bothInt := func(v1, v2 Val) bool { return v1.flag == ValueInt && v2.flag == ValueInt }
+
+	switch e := e.(type) {
+	case Num:
+		return expDerivation("Num", e, s, v), v
+	case Bool:
+		return expDerivation("Bool", e, s, v), v
+	case Var:
+		if v.flag == Undefined {
+			return expDerivation("Var-Undef", e, s, v), v
+		}
+		return expDerivation("Var", e, s, v), v
+	case Plus:
+		return binary("Plus", e[0], e[1], bothInt, "Plus-Undef")
+	case Mult:
+		return binary("Mult", e[0], e[1], bothInt, "Mult-Undef")
+	case Lesser:
+		return binary("Lesser", e[0], e[1], bothInt, "Lesser-Undef")
+	case Or:
+		d1, v1 := deriveExp(e[0], s)
+		d2, v2 := deriveExp(e[1], s)
+		rule := "Or-Undef"
+		switch {
+		case v1.flag == ValueBool && v1.valB:
+			rule = "Or-Left"
+		case v1.flag == ValueBool && v2.flag == ValueBool:
+			rule = "Or"
+		}
+		return expDerivation(rule, e, s, v, d1, d2), v
+	case And:
+		d1, v1 := deriveExp(e[0], s)
+		d2, v2 := deriveExp(e[1], s)
+		rule := "And-Undef"
+		switch {
+		case v1.flag == ValueBool && !v1.valB:
+			rule = "And-Left"
+		case v1.flag == ValueBool && v2.flag == ValueBool:
+			rule = "And"
+		}
+		return expDerivation(rule, e, s, v, d1, d2), v
+	case Negation:
+		d, b := deriveExp(e[0], s)
+		rule := "Not"
+		if b.flag != ValueBool {
+			rule = "Not-Undef"
+		}
+		return expDerivation(rule, e, s, v, d), v
+	case Equal:
+		d1, v1 := deriveExp(e[0], s)
+		d2, v2 := deriveExp(e[1], s)
+		rule := "Equal-Undef"
+		if v1.flag == v2.flag && v1.flag == ValueInt {
+			rule = "Equal-Int"
+		} else if v1.flag == v2.flag && v1.flag == ValueBool {
+			rule = "Equal-Bool"
+		}
+		return expDerivation(rule, e, s, v, d1, d2), v
+	case Group:
+		d, _ := deriveExp(e[0], s)
+		return expDerivation("Group", e, s, v, d), v
+	}
+	return expDerivation("?", e, s, v), v
+}
+
+func stmtDerivation(rule string, stmt Stmt, before, after ValState, printed string, premises ...*derivation) *derivation {
+	d := &derivation{
+		rule:     rule,
+		text:     fmt.Sprintf("<%s, %s> => %s", oneLine(stmt), showState(before), showState(after)),
+		latex:    fmt.Sprintf("\\langle %s, %s \\rangle \\Downarrow %s", latexCode(stmt), latexState(before), latexState(after)),
+		premises: premises,
+	}
+	if printed != "" {
+		d.text += fmt.Sprintf(", prints %q", printed)
+		d.latex += fmt.Sprintf(", \\texttt{%s}", latexEscape(strings.TrimSuffix(printed, "\n")))
+	}
+	return d
+}
+
+// a statement (or an iteration of a while loop) whose derivation is being recorded
+type deriveFrame struct {
+	stmt Stmt
+	// set by the condition of an if statement or an iteration
+	rule    string
+	printed string
+	// the state before the statement; for an iteration, that of the enclosing scope and cur that of the
+	// nested scope
+	before, cur ValState
+	// the type state before the statement, for type checks
+	types TyState
+	// the premises recorded so far
+	premises  []*derivation
+	iteration bool
+}
+
+// records derivations from the hooks of exec and check
+type deriver struct {
+	frames []*deriveFrame
+	root   *derivation
+}
+
+func (r *deriver) push(f *deriveFrame) {
+	r.frames = append(r.frames, f)
+}
+
+func (r *deriver) top() *deriveFrame {
+	return r.frames[len(r.frames)-1]
+}
+
+func (r *deriver) pop() *deriveFrame {
+	f := r.top()
+	r.frames = r.frames[:len(r.frames)-1]
+	return f
+}
+
+// adds the derivation of a finished statement to the enclosing one
+func (r *deriver) add(d *derivation) {
+	if len(r.frames) == 0 {
+		r.root = d
+		return
+	}
+	r.top().premises = append(r.top().premises, d)
+}
+
+// returns the hooks recording the derivation of an evaluation
+func (r *deriver) hooks() *evalHooks {
+	return &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			r.push(&deriveFrame{stmt: stmt, before: copyState(s)})
+			return true
+		},
+		exp: func(e Exp, p Path, s ValState) {
+			d, _ := deriveExp(e, s)
+			r.add(d)
+		},
+		iteration: func(while While, p Path, s2 ValState) bool {
+			// the enclosing state doesn't change while the loop runs
+			r.push(&deriveFrame{stmt: while, before: r.top().before, cur: copyState(s2), iteration: true})
+			return true
+		},
+		cond: func(owner Stmt, p Path, s ValState, v Val, output string) {
+			rule := "If"
+			if _, ok := owner.(While); ok {
+				rule = "Loop"
+			}
+			switch {
+			case v.flag == ValueBool && v.valB:
+				rule += "-True"
+			case v.flag == ValueBool:
+				rule += "-False"
+			default:
+				rule += "-Fail"
+			}
+			r.top().rule, r.top().printed = rule, output
+		},
+		done: func(stmt Stmt, p Path, s ValState, output string) {
+			if while, ok := stmt.(While); ok {
+				// every iteration has the derivation of the next one as last premise
+				var next *derivation
+				for r.top().iteration {
+					f := r.pop()
+					if next != nil {
+						f.premises = append(f.premises, next)
+					}
+					next = loopDerivation(f.rule, while, f.before, f.cur, s, f.printed, f.premises...)
+				}
+				f := r.pop()
+				r.add(stmtDerivation("While", stmt, f.before, s, "", next))
+				return
+			}
+			f := r.pop()
+			rule := f.rule
+			switch stmt.(type) {
+			case Prog:
+				rule = "Prog"
+			case Block:
+				rule = "Block"
+			case Seq:
+				rule = "Seq"
+			case Decl:
+				rule = "Decl"
+			case Assign:
+				rule = "Assign"
+				if output != "" {
+					rule = "Assign-Fail"
+				}
+			case Print:
+				rule = "Print"
+			case IfThenElse:
+			default:
+				rule = "?"
+			}
+			r.add(stmtDerivation(rule, stmt, f.before, s, output, f.premises...))
+		},
+	}
+}
+
+// the derivation of an iteration of a while loop: saved is the state of the enclosing scope, cur that of the
+// nested scope when the condition is evaluated and after the state of the enclosing scope after the loop
+func loopDerivation(rule string, while While, saved, cur, after ValState, printed string, premises ...*derivation) *derivation {
+	d := &derivation{
+		rule:     rule,
+		text:     fmt.Sprintf("<%s, %s | %s> => %s", oneLine(while), showState(saved), showState(cur), showState(after)),
+		latex:    fmt.Sprintf("\\langle %s, %s \\mid %s \\rangle \\Downarrow %s", latexCode(while), latexState(saved), latexState(cur), latexState(after)),
+		premises: premises,
+	}
+	if printed != "" {
+		d.text += fmt.Sprintf(", prints %q", printed)
+		d.latex += fmt.Sprintf(", \\texttt{%s}", latexEscape(printed))
+	}
+	return d
+}
+
+// derivations of type checks
+
+// type checks a program in a fresh type state and records the derivation
+func deriveCheck(prg Prog) (*derivation, bool) {
+	r := &deriver{}
+	t := make(map[string]Type)
+	ok := r.checkHooks().check(prg, t)
+	return r.root, ok
+}
+
+func copyTyState(t TyState) TyState {
+	c := make(map[string]Type)
+	for k, v := range t {
+		c[k] = v
+	}
+	return c
+}
+
+// records the derivation of inferring the type of an expression
+func deriveInfer(e Exp, t TyState) *derivation {
+	ty := e.infer(t)
+	var premises []*derivation
+	for _, c := range children(e) {
+		premises = append(premises, deriveInfer(toExp(c), t))
+	}
+	rule := "T-" + strings.TrimPrefix(fmt.Sprintf("%T", e), "main.")
+	if _, isGroup := e.(Group); !isGroup && ty == TyIllTyped {
+		rule += "-Ill"
+	}
+	return &derivation{
+		rule:     rule,
+		text:     fmt.Sprintf("%s |- %s : %s", showTyState(t), oneLine(e), showType(ty)),
+		latex:    fmt.Sprintf("%s \\vdash %s : \\mathsf{%s}", latexTyState(t), latexCode(e), showType(ty)),
+		premises: premises,
+	}
+}
+
+// returns the hooks recording the derivation of a type check
+func (r *deriver) checkHooks() *checkHooks {
+	return &checkHooks{
+		stmt: func(stmt Stmt, t TyState) {
+			r.push(&deriveFrame{stmt: stmt, types: copyTyState(t)})
+		},
+		exp: func(e Exp, t TyState) {
+			r.add(deriveInfer(e, t))
+		},
+		done: func(stmt Stmt, t TyState, ok bool) {
+			f := r.pop()
+			rule, ill := "?", ""
+			if !ok {
+				ill = "-Ill"
+			}
+			switch stmt.(type) {
+			case Prog:
+				rule = "T-Prog"
+			case Block:
+				rule = "T-Block"
+			case Seq:
+				rule = "T-Seq"
+				if !ok && len(f.premises) == 1 {
+					// the first statement failed, the second one isn't checked
+					rule = "T-Seq-Fail"
+				}
+			case Decl:
+				rule = "T-Decl" + ill
+			case Assign:
+				rule = "T-Assign" + ill
+			case While:
+				rule = "T-While" + ill
+			case IfThenElse:
+				rule = "T-If" + ill
+			case Print:
+				rule = "T-Print" + ill
+			}
+			result := "fails"
+			latexResult := "\\times"
+			if ok {
+				result = "ok " + showTyState(t)
+				latexResult = "\\checkmark\\; " + latexTyState(t)
+			}
+			r.add(&derivation{
+				rule:     rule,
+				text:     fmt.Sprintf("%s |- %s %s", showTyState(f.types), oneLine(stmt), result),
+				latex:    fmt.Sprintf("%s \\vdash %s \\;%s", latexTyState(f.types), latexCode(stmt), latexResult),
+				premises: f.premises,
+			})
+		},
+	}
+}
+
+// rendering of derivations
+
+// renders a derivation as indented text, the conclusion first and its premises below
+func (d *derivation) textTree() string {
+	var b strings.Builder
+	var emit func(d *derivation, depth int)
+	emit = func(d *derivation, depth int) {
+		fmt.Fprintf(&b, "%s[%s] %s\n", strings.Repeat("  ", depth), d.rule, d.text)
+		for _, p := range d.premises {
+			emit(p, depth+1)
+		}
+	}
+	emit(d, 0)
+	return b.String()
+}
+
+// renders a derivation as a prooftree of the LaTeX package bussproofs
+func (d *derivation) bussproofs() string {
+	var b strings.Builder
+	infer := []string{"UnaryInfC", "UnaryInfC", "BinaryInfC", "TrinaryInfC", "QuaternaryInfC", "QuinaryInfC"}
+	var emit func(d *derivation)
+	emit = func(d *derivation) {
+		if len(d.premises) == 0 {
+			b.WriteString("\\AxiomC{}\n")
+		}
+		for _, p := range d.premises {
+			emit(p)
+		}
+		fmt.Fprintf(&b, "\\RightLabel{\\scriptsize %s}\n", latexEscape(d.rule))
+		fmt.Fprintf(&b, "\\%s{$%s$}\n", infer[len(d.premises)], d.latex)
+	}
+	b.WriteString("% requires \\usepackage{bussproofs}\n")
+	b.WriteString("\\begin{prooftree}\n")
+	emit(d)
+	b.WriteString("\\end{prooftree}\n")
+	return b.String()
+}
+
+// checks that deriveEval and deriveCheck agree with Prog.eval and Prog.check
+// returns a description of the first difference, or an empty string
+func checkDerivation(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	var s ValState
+	gotOut := captureOutput(func() { _, s = deriveEvalState(prg) })
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- derivation\n%s", wantOut, gotOut)
+	}
+	if !sameState(s, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, derivation %s", showState(wantState), showState(s))
+	}
+	want := prg.check(make(map[string]Type))
+	if _, ok := deriveCheck(prg); ok != want {
+		return fmt.Sprintf("type check differs: check %t, derivation %t", want, ok)
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDerivationsAgreeWithEvalAndCheck(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkDerivation(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+// returns the rules of a derivation in pre-order
+func derivationRules(d *derivation) []string {
+	rules := []string{d.rule}
+	for _, p := range d.premises {
+		rules = append(rules, derivationRules(p)...)
+	}
+	return rules
+}
+
+func TestDerivationRules(t *testing.T) {
+	var d *derivation
+	captureOutput(func() { d = deriveEval(failingLoop()) })
+	var loops []string
+	for _, rule := range derivationRules(d) {
+		if strings.HasPrefix(rule, "Loop-") {
+			loops = append(loops, rule)
+		}
+	}
+	if got, want := strings.Join(loops, " "), "Loop-True Loop-True Loop-Fail"; got != want {
+		t.Errorf("iterations: got %s, want %s", got, want)
+	}
+
+	d, ok := deriveCheck(ex04())
+	if got, want := strings.Join(derivationRules(d), " "), "T-Prog T-Block T-Seq T-Decl T-Num T-Assign-Ill T-Bool"; ok || got != want {
+		t.Errorf("type check: got %t %s, want false %s", ok, got, want)
+	}
+}
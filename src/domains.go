@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// more domains for abstract interpretation (see abstract.go): signs, parities, constants and products of domains
+//
+// signs and parities are finite sets of classes of integers, so their transfer functions are computed class by
+// class from small tables. All three domains have finite height, so widening is just joining.
+
+// a set of classes of integers (e.g. negative, zero and positive), every class is a bit
+type classSet uint8
+
+// combines the classes of a and b pairwise with op, which returns the classes the result can have
+func (a classSet) combine(b classSet, n int, op func(i, j int) classSet) classSet {
+	var r classSet
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if a&(1<<i) != 0 && b&(1<<j) != 0 {
+				r |= op(i, j)
+			}
+		}
+	}
+	return r
+}
+
+// returns whether rel (a table of the booleans a relation can have, by pair of classes) can be true or false
+func (a classSet) relation(b classSet, n int, rel [][]boolSet) (bool, bool) {
+	bs := boolSet(a.combine(b, n, func(i, j int) classSet { return classSet(rel[i][j]) }))
+	return bs&boolTrue != 0, bs&boolFalse != 0
+}
+
+// returns the classes of a and b for which the relation rel can have the value truth
+func (a classSet) refine(b classSet, n int, rel [][]boolSet, truth bool) (classSet, classSet, bool) {
+	var ra, rb classSet
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if a&(1<<i) != 0 && b&(1<<j) != 0 && rel[i][j]&boolsOf(truth) != 0 {
+				ra |= 1 << i
+				rb |= 1 << j
+			}
+		}
+	}
+	return ra, rb, ra != 0
+}
+
+func (a classSet) show(names []string) string {
+	var parts []string
+	for i, name := range names {
+		if a&(1<<i) != 0 {
+			parts = append(parts, name)
+		}
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// the signs of integers: negative, zero or positive
+type sign classSet
+
+const (
+	signNeg sign = 1 << iota
+	signZero
+	signPos
+)
+
+var signNames = []string{"-", "0", "+"}
+
+func (s sign) String() string {
+	return classSet(s).show(signNames)
+}
+
+// the results of the operators on signs, by the indices of the signs of the operands
+// integers wrap around on overflow: the sum of two negative integers can be zero or positive (the smallest int
+// added to itself is 0), the sum of two positive ones negative, and a product of non-zero integers can have
+// any sign (2^32 * 2^32 is 0)
+var (
+	signAny  = signNeg | signZero | signPos
+	signPlus = [][]sign{
+		{signAny, signNeg, signAny},
+		{signNeg, signZero, signPos},
+		{signAny, signPos, signNeg | signPos},
+	}
+	signMult = [][]sign{
+		{signAny, signZero, signAny},
+		{signZero, signZero, signZero},
+		{signAny, signZero, signAny},
+	}
+	signLess = [][]boolSet{
+		{boolTrue | boolFalse, boolTrue, boolTrue},
+		{boolFalse, boolFalse, boolTrue},
+		{boolFalse, boolFalse, boolTrue | boolFalse},
+	}
+	signEqual = [][]boolSet{
+		{boolTrue | boolFalse, boolFalse, boolFalse},
+		{boolFalse, boolTrue, boolFalse},
+		{boolFalse, boolFalse, boolTrue | boolFalse},
+	}
+)
+
+// the domain of signs
+type signDomain struct{}
+
+func (signDomain) constant(n int) absNum {
+	switch {
+	case n < 0:
+		return signNeg
+	case n == 0:
+		return signZero
+	}
+	return signPos
+}
+
+func (signDomain) join(a, b absNum) absNum {
+	return a.(sign) | b.(sign)
+}
+
+func (signDomain) meet(a, b absNum) (absNum, bool) {
+	m := a.(sign) & b.(sign)
+	return m, m != 0
+}
+
+func (d signDomain) widen(a, b absNum) absNum {
+	return d.join(a, b)
+}
+
+func (signDomain) leq(a, b absNum) bool {
+	return a.(sign)&^b.(sign) == 0
+}
+
+func (d signDomain) contains(a absNum, n int) bool {
+	return a.(sign)&d.constant(n).(sign) != 0
+}
+
+func (signDomain) plus(a, b absNum) absNum {
+	return sign(classSet(a.(sign)).combine(classSet(b.(sign)), 3, func(i, j int) classSet { return classSet(signPlus[i][j]) }))
+}
+
+func (signDomain) mult(a, b absNum) absNum {
+	return sign(classSet(a.(sign)).combine(classSet(b.(sign)), 3, func(i, j int) classSet { return classSet(signMult[i][j]) }))
+}
+
+func (signDomain) less(a, b absNum) (bool, bool) {
+	return classSet(a.(sign)).relation(classSet(b.(sign)), 3, signLess)
+}
+
+func (signDomain) equal(a, b absNum) (bool, bool) {
+	return classSet(a.(sign)).relation(classSet(b.(sign)), 3, signEqual)
+}
+
+func (signDomain) refineLess(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y, ok := classSet(a.(sign)).refine(classSet(b.(sign)), 3, signLess, truth)
+	return sign(x), sign(y), ok
+}
+
+func (signDomain) refineEqual(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y, ok := classSet(a.(sign)).refine(classSet(b.(sign)), 3, signEqual, truth)
+	return sign(x), sign(y), ok
+}
+
+// the parities of integers: even or odd
+type parity classSet
+
+const (
+	parityEven parity = 1 << iota
+	parityOdd
+)
+
+var parityNames = []string{"even", "odd"}
+
+func (p parity) String() string {
+	return classSet(p).show(parityNames)
+}
+
+var (
+	parityPlus = [][]parity{
+		{parityEven, parityOdd},
+		{parityOdd, parityEven},
+	}
+	parityMult = [][]parity{
+		{parityEven, parityEven},
+		{parityEven, parityOdd},
+	}
+	// the parity doesn't tell anything about the order of integers
+	parityLess = [][]boolSet{
+		{boolTrue | boolFalse, boolTrue | boolFalse},
+		{boolTrue | boolFalse, boolTrue | boolFalse},
+	}
+	parityEqual = [][]boolSet{
+		{boolTrue | boolFalse, boolFalse},
+		{boolFalse, boolTrue | boolFalse},
+	}
+)
+
+// the domain of parities
+type parityDomain struct{}
+
+func (parityDomain) constant(n int) absNum {
+	if n%2 == 0 {
+		return parityEven
+	}
+	return parityOdd
+}
+
+func (parityDomain) join(a, b absNum) absNum {
+	return a.(parity) | b.(parity)
+}
+
+func (parityDomain) meet(a, b absNum) (absNum, bool) {
+	m := a.(parity) & b.(parity)
+	return m, m != 0
+}
+
+func (d parityDomain) widen(a, b absNum) absNum {
+	return d.join(a, b)
+}
+
+func (parityDomain) leq(a, b absNum) bool {
+	return a.(parity)&^b.(parity) == 0
+}
+
+func (d parityDomain) contains(a absNum, n int) bool {
+	return a.(parity)&d.constant(n).(parity) != 0
+}
+
+func (parityDomain) plus(a, b absNum) absNum {
+	return parity(classSet(a.(parity)).combine(classSet(b.(parity)), 2, func(i, j int) classSet { return classSet(parityPlus[i][j]) }))
+}
+
+func (parityDomain) mult(a, b absNum) absNum {
+	return parity(classSet(a.(parity)).combine(classSet(b.(parity)), 2, func(i, j int) classSet { return classSet(parityMult[i][j]) }))
+}
+
+func (parityDomain) less(a, b absNum) (bool, bool) {
+	return classSet(a.(parity)).relation(classSet(b.(parity)), 2, parityLess)
+}
+
+func (parityDomain) equal(a, b absNum) (bool, bool) {
+	return classSet(a.(parity)).relation(classSet(b.(parity)), 2, parityEqual)
+}
+
+func (parityDomain) refineLess(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y, ok := classSet(a.(parity)).refine(classSet(b.(parity)), 2, parityLess, truth)
+	return parity(x), parity(y), ok
+}
+
+func (parityDomain) refineEqual(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y, ok := classSet(a.(parity)).refine(classSet(b.(parity)), 2, parityEqual, truth)
+	return parity(x), parity(y), ok
+}
+
+// a single integer, or any integer (constant propagation)
+type constant struct {
+	n   int
+	any bool
+}
+
+func (c constant) String() string {
+	if c.any {
+		return "any"
+	}
+	return fmt.Sprint(c.n)
+}
+
+// the domain of constants
+type constDomain struct{}
+
+func (constDomain) constant(n int) absNum {
+	return constant{n: n}
+}
+
+func (constDomain) join(a, b absNum) absNum {
+	if a.(constant) == b.(constant) {
+		return a
+	}
+	return constant{any: true}
+}
+
+func (constDomain) meet(a, b absNum) (absNum, bool) {
+	x, y := a.(constant), b.(constant)
+	switch {
+	case x.any:
+		return y, true
+	case y.any:
+		return x, true
+	}
+	return x, x == y
+}
+
+func (d constDomain) widen(a, b absNum) absNum {
+	return d.join(a, b)
+}
+
+func (constDomain) leq(a, b absNum) bool {
+	return b.(constant).any || a.(constant) == b.(constant)
+}
+
+func (constDomain) contains(a absNum, n int) bool {
+	c := a.(constant)
+	return c.any || c.n == n
+}
+
+func (constDomain) plus(a, b absNum) absNum {
+	x, y := a.(constant), b.(constant)
+	if x.any || y.any {
+		return constant{any: true}
+	}
+	return constant{n: x.n + y.n}
+}
+
+func (constDomain) mult(a, b absNum) absNum {
+	x, y := a.(constant), b.(constant)
+	switch {
+	case x == constant{n: 0} || y == constant{n: 0}:
+		return constant{n: 0}
+	case x.any || y.any:
+		return constant{any: true}
+	}
+	return constant{n: x.n * y.n}
+}
+
+func (constDomain) less(a, b absNum) (bool, bool) {
+	x, y := a.(constant), b.(constant)
+	if x.any || y.any {
+		return true, true
+	}
+	return x.n < y.n, x.n >= y.n
+}
+
+func (constDomain) equal(a, b absNum) (bool, bool) {
+	x, y := a.(constant), b.(constant)
+	if x.any || y.any {
+		return true, true
+	}
+	return x.n == y.n, x.n != y.n
+}
+
+func (d constDomain) refineLess(a, b absNum, truth bool) (absNum, absNum, bool) {
+	canTrue, canFalse := d.less(a, b)
+	return a, b, canTrue && truth || canFalse && !truth
+}
+
+func (d constDomain) refineEqual(a, b absNum, truth bool) (absNum, absNum, bool) {
+	if truth {
+		m, ok := d.meet(a, b)
+		return m, m, ok
+	}
+	_, canFalse := d.equal(a, b)
+	return a, b, canFalse
+}
+
+// the product of domains: an integer is abstracted by a value of each domain
+// the values don't exchange information (e.g. a sign doesn't narrow an interval), except that a relation
+// can only have a value that all domains allow, and that a product is empty if one of its values is
+type productDomain []Domain
+
+// a value of a product domain
+type product []absNum
+
+func (p product) String() string {
+	var parts []string
+	for _, x := range p {
+		parts = append(parts, x.String())
+	}
+	return strings.Join(parts, " & ")
+}
+
+// applies op to the values of a and b of each domain
+func (d productDomain) each(a, b absNum, op func(d Domain, x, y absNum) absNum) absNum {
+	x, y := a.(product), b.(product)
+	r := make(product, len(d))
+	for i := range d {
+		r[i] = op(d[i], x[i], y[i])
+	}
+	return r
+}
+
+func (d productDomain) constant(n int) absNum {
+	r := make(product, len(d))
+	for i := range d {
+		r[i] = d[i].constant(n)
+	}
+	return r
+}
+
+func (d productDomain) join(a, b absNum) absNum {
+	return d.each(a, b, Domain.join)
+}
+
+func (d productDomain) meet(a, b absNum) (absNum, bool) {
+	ok := true
+	m := d.each(a, b, func(d Domain, x, y absNum) absNum {
+		m, okM := d.meet(x, y)
+		ok = ok && okM
+		return m
+	})
+	return m, ok
+}
+
+func (d productDomain) widen(a, b absNum) absNum {
+	return d.each(a, b, Domain.widen)
+}
+
+func (d productDomain) leq(a, b absNum) bool {
+	x, y := a.(product), b.(product)
+	for i := range d {
+		if !d[i].leq(x[i], y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d productDomain) contains(a absNum, n int) bool {
+	x := a.(product)
+	for i := range d {
+		if !d[i].contains(x[i], n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d productDomain) plus(a, b absNum) absNum {
+	return d.each(a, b, Domain.plus)
+}
+
+func (d productDomain) mult(a, b absNum) absNum {
+	return d.each(a, b, Domain.mult)
+}
+
+// returns whether a relation can be true or false in all domains
+func (d productDomain) relation(a, b absNum, rel func(d Domain, x, y absNum) (bool, bool)) (bool, bool) {
+	x, y := a.(product), b.(product)
+	canTrue, canFalse := true, true
+	for i := range d {
+		t, f := rel(d[i], x[i], y[i])
+		canTrue, canFalse = canTrue && t, canFalse && f
+	}
+	return canTrue, canFalse
+}
+
+func (d productDomain) less(a, b absNum) (bool, bool) {
+	return d.relation(a, b, Domain.less)
+}
+
+func (d productDomain) equal(a, b absNum) (bool, bool) {
+	return d.relation(a, b, Domain.equal)
+}
+
+// refines the values of a relation in all domains
+func (d productDomain) refine(a, b absNum, truth bool, refine func(d Domain, x, y absNum, truth bool) (absNum, absNum, bool)) (absNum, absNum, bool) {
+	x, y := a.(product), b.(product)
+	rx, ry := make(product, len(d)), make(product, len(d))
+	for i := range d {
+		var ok bool
+		if rx[i], ry[i], ok = refine(d[i], x[i], y[i], truth); !ok {
+			return a, b, false
+		}
+	}
+	return rx, ry, true
+}
+
+func (d productDomain) refineLess(a, b absNum, truth bool) (absNum, absNum, bool) {
+	return d.refine(a, b, truth, Domain.refineLess)
+}
+
+func (d productDomain) refineEqual(a, b absNum, truth bool) (absNum, absNum, bool) {
+	return d.refine(a, b, truth, Domain.refineEqual)
+}
+
+// the domains by name
+var domains = map[string]Domain{
+	"interval": intervalDomain{},
+	"sign":     signDomain{},
+	"parity":   parityDomain{},
+	"const":    constDomain{},
+}
+
+// returns the domain for a comma-separated list of names, several names give their product
+func domainByNames(names string) (Domain, error) {
+	var d productDomain
+	for _, name := range strings.Split(names, ",") {
+		dom, ok := domains[strings.TrimSpace(name)]
+		if !ok {
+			var known []string
+			for k := range domains {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown domain %q (%s)", name, strings.Join(known, ", "))
+		}
+		d = append(d, dom)
+	}
+	if len(d) == 1 {
+		return d[0], nil
+	}
+	return d, nil
+}
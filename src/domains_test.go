@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestDomainsContainEval(t *testing.T) {
+	product, err := domainByNames("interval,sign,parity,const")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := map[string]Domain{"product": product}
+	for name, d := range domains {
+		ds[name] = d
+	}
+	for dname, d := range ds {
+		for name, prg := range testPrograms() {
+			if diff := checkAnalysis(prg, d); diff != "" {
+				t.Errorf("%s, %s: %s", dname, name, diff)
+			}
+		}
+	}
+}
+
+func TestDomainByNames(t *testing.T) {
+	if d, err := domainByNames(" sign "); err != nil || d != (signDomain{}) {
+		t.Errorf("sign gives %v, %v", d, err)
+	}
+	if _, err := domainByNames("interval,octagon"); err == nil {
+		t.Errorf("an unknown domain was accepted")
+	}
+}
+
+func TestParityAfterLoop(t *testing.T) {
+	// the loop adds 2, x stays even
+	body := block(assignment("x", plus(variable("x"), number(2))))
+	prg := generateProg([]Stmt{declaration("x", number(0)), while(lesser(variable("x"), number(10)), body)})
+	_, end := analyze(prg, parityDomain{})
+	if got := end.String(); got != "{x: even}" {
+		t.Errorf("the final state is %s instead of {x: even}", got)
+	}
+}
+
+func TestDomainsWrapAround(t *testing.T) {
+	// the sum of two positive integers is negative, the product of two negative ones zero
+	prg := generateProg([]Stmt{
+		declaration("x", number(9223372036854775807)),
+		declaration("y", plus(variable("x"), variable("x"))),
+		declaration("z", mult(number(-4294967296), number(-4294967296))),
+		declaration("m", mult(number(-9223372036854775808), number(-1))),
+		sPrint(plus(variable("y"), plus(variable("z"), variable("m")))),
+	})
+	product, _ := domainByNames("interval,sign,parity,const")
+	for name, d := range map[string]Domain{"sign": signDomain{}, "parity": parityDomain{}, "const": constDomain{}, "product": product} {
+		if diff := checkAnalysis(prg, d); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
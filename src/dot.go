@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// visualisation of abstract syntax trees, as Graphviz DOT or as indented text
+
+// options for visualising a tree
+type astOptions struct {
+	// flatten chains of sequences into a single node with one child per statement
+	flatten bool
+	// annotate expressions with the types inferred by infer
+	types bool
+}
+
+// a node of the visualised tree
+type astNode struct {
+	label    string
+	isExp    bool
+	children []astEdge
+}
+
+// an edge to a child, labelled with the role of the child (e.g. cond, then, else)
+type astEdge struct {
+	role  string
+	child *astNode
+}
+
+// builds the visualised tree of a program
+// when types are annotated, the type state is threaded through the statements the same way as check does
+func astTree(prg Prog, opts astOptions) *astNode {
+	t := make(map[string]Type)
+	return stmtTree(prg, opts, t)
+}
+
+func stmtTree(s Stmt, opts astOptions, t TyState) *astNode {
+	switch s := s.(type) {
+	case Prog:
+		return &astNode{label: "Prog", children: []astEdge{{"block", stmtTree(s[0], opts, t)}}}
+	case Block:
+		return &astNode{label: "Block", children: []astEdge{{"body", stmtTree(s[0], opts, t)}}}
+	case Seq:
+		if opts.flatten {
+			n := &astNode{label: "Seq"}
+			for i, x := range flattenSeq(s) {
+				n.children = append(n.children, astEdge{fmt.Sprintf("%d", i+1), stmtTree(x, opts, t)})
+			}
+			return n
+		}
+		first := stmtTree(s[0], opts, t)
+		second := stmtTree(s[1], opts, t)
+		return &astNode{label: "Seq", children: []astEdge{{"first", first}, {"second", second}}}
+	case Decl:
+		n := &astNode{label: "Decl " + s.lhs, children: []astEdge{{"rhs", expTree(s.rhs, opts, t)}}}
+		s.check(t)
+		return n
+	case Assign:
+		n := &astNode{label: "Assign " + s.lhs, children: []astEdge{{"rhs", expTree(s.rhs, opts, t)}}}
+		s.check(t)
+		return n
+	case While:
+		cond := expTree(s.cond, opts, t)
+		return &astNode{label: "While", children: []astEdge{{"cond", cond}, {"do", stmtTree(s.do, opts, t)}}}
+	case IfThenElse:
+		cond := expTree(s.cond, opts, t)
+		th := stmtTree(s.thenBl, opts, t)
+		el := stmtTree(s.elseBl, opts, t)
+		return &astNode{label: "IfThenElse", children: []astEdge{{"cond", cond}, {"then", th}, {"else", el}}}
+	case Print:
+		return &astNode{label: "Print", children: []astEdge{{"exp", expTree(s.printExp, opts, t)}}}
+	}
+	return &astNode{label: fmt.Sprintf("%T", s)}
+}
+
+func expTree(e Exp, opts astOptions, t TyState) *astNode {
+	n := &astNode{isExp: true}
+	switch e := e.(type) {
+	case Num:
+		n.label = "Num " + e.pretty()
+	case Bool:
+		n.label = "Bool " + e.pretty()
+	case Var:
+		n.label = "Var " + string(e)
+	case Plus:
+		n.label = "Plus"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case Mult:
+		n.label = "Mult"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case Or:
+		n.label = "Or"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case And:
+		n.label = "And"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case Equal:
+		n.label = "Equal"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case Lesser:
+		n.label = "Lesser"
+		n.children = binaryTree(e[0], e[1], opts, t)
+	case Negation:
+		n.label = "Negation"
+		n.children = []astEdge{{"operand", expTree(e[0], opts, t)}}
+	case Group:
+		n.label = "Group"
+		n.children = []astEdge{{"operand", expTree(e[0], opts, t)}}
+	default:
+		n.label = fmt.Sprintf("%T", e)
+	}
+	if opts.types && e != nil {
+		n.label += " : " + showType(e.infer(t))
+	}
+	return n
+}
+func binaryTree(x, y Exp, opts astOptions, t TyState) []astEdge {
+	return []astEdge{{"left", expTree(x, opts, t)}, {"right", expTree(y, opts, t)}}
+}
+
+// returns the statements of a chain of sequences in execution order
+// nested sequences on either side are flattened as well
+func flattenSeq(s Stmt) []Stmt {
+	if seq, ok := s.(Seq); ok {
+		return append(flattenSeq(seq[0]), flattenSeq(seq[1])...)
+	}
+	return []Stmt{s}
+}
+
+// renders a tree as a Graphviz DOT graph
+// statements are drawn as boxes, expressions as ellipses
+func astDot(root *astNode) string {
+	var b strings.Builder
+	b.WriteString("digraph AST {\n")
+	b.WriteString("  node [shape=box, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	count := 0
+	var emit func(n *astNode) string
+	emit = func(n *astNode) string {
+		id := fmt.Sprintf("n%d", count)
+		count++
+		shape := ""
+		if n.isExp {
+			shape = ", shape=ellipse"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s%s];\n", id, dotQuote(n.label), shape)
+		for _, c := range n.children {
+			cid := emit(c.child)
+			fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", id, cid, dotQuote(c.role))
+		}
+		return id
+	}
+	emit(root)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renders a tree as indented text, one node per line
+func astText(root *astNode) string {
+	var b strings.Builder
+	var emit func(n *astNode, role string, depth int)
+	emit = func(n *astNode, role string, depth int) {
+		b.WriteString(strings.Repeat("  ", depth))
+		if role != "" {
+			b.WriteString(role + ": ")
+		}
+		b.WriteString(n.label + "\n")
+		for _, c := range n.children {
+			emit(c.child, c.role, depth+1)
+		}
+	}
+	emit(root, "", 0)
+	return b.String()
+}
+
+// quotes a string as a DOT identifier
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return "\"" + s + "\""
+}
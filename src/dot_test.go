@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func astTestProg() Prog {
+	return generateProg([]Stmt{declaration("x", number(1)), sPrint(lesser(variable("x"), number(2)))})
+}
+
+func TestASTText(t *testing.T) {
+	want := `Prog
+  block: Block
+    body: Seq
+      1: Decl x
+        rhs: Num 1 : Int
+      2: Print
+        exp: Lesser : Bool
+          left: Var x : Int
+          right: Num 2 : Int
+`
+	if got := astText(astTree(astTestProg(), astOptions{flatten: true, types: true})); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestASTDot(t *testing.T) {
+	want := `digraph AST {
+  node [shape=box, fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  n0 [label="Prog"];
+  n1 [label="Block"];
+  n2 [label="Seq"];
+  n3 [label="Decl x"];
+  n4 [label="Num 1", shape=ellipse];
+  n3 -> n4 [label="rhs"];
+  n2 -> n3 [label="first"];
+  n5 [label="Print"];
+  n6 [label="Lesser", shape=ellipse];
+  n7 [label="Var x", shape=ellipse];
+  n6 -> n7 [label="left"];
+  n8 [label="Num 2", shape=ellipse];
+  n6 -> n8 [label="right"];
+  n5 -> n6 [label="exp"];
+  n2 -> n5 [label="second"];
+  n1 -> n2 [label="body"];
+  n0 -> n1 [label="block"];
+}
+`
+	if got := astDot(astTree(astTestProg(), astOptions{})); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestASTNodes(t *testing.T) {
+	// one line of text and one DOT node per node of the program, flattening only removes sequences
+	for name, prg := range testPrograms() {
+		nodes, seqs := 0, 0
+		walkPre(prg, func(n Node) bool {
+			nodes++
+			if _, ok := n.(Seq); ok {
+				seqs++
+			}
+			return true
+		})
+		tree := astTree(prg, astOptions{})
+		if got := strings.Count(astText(tree), "\n"); got != nodes {
+			t.Errorf("%s: %d lines of text for %d nodes", name, got, nodes)
+		}
+		if got := strings.Count(astDot(tree), "[label="); got != 2*nodes-1 {
+			t.Errorf("%s: %d DOT nodes and edges for %d nodes", name, got, nodes)
+		}
+		flat := astText(astTree(prg, astOptions{flatten: true}))
+		if got := strings.Count(flat, "\n"); got > nodes || got < nodes-seqs {
+			t.Errorf("%s: %d lines of flattened text for %d nodes and %d sequences", name, got, nodes, seqs)
+		}
+	}
+}
+
+func TestDotQuote(t *testing.T) {
+	if got, want := dotQuote("a \"b\" \\ c\nd"), `"a \"b\" \\ c\nd"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
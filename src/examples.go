@@ -1,10 +1,11 @@
 package main
 
 // all examples are written with help of the AST helper functions
-// the fibonacci example shows how examples are written and executed
+// the fibonacci example shows how examples are written
+// each example returns its program, so it can be run, dumped or analysed
 
 // this example shows the fibonacci calculation
-func fib() {
+func fib() Prog {
 	// declare "lines" of the programm
 	l01 := declaration("prev", number(-1))
 	l02 := declaration("result", number(1))
@@ -19,14 +20,11 @@ func fib() {
 	l03 := while(lesser(variable("result"), number(50)), doBlock)
 
 	// generate a program from multiple "lines" of "code"
-	prog := generateProg([]Stmt{l01, l02, l03})
-
-	// run the program
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03})
 }
 
 // this examples shows if-then-else with scoping rules, the use of or and negation
-func ex01() {
+func ex01() Prog {
 	// declaring a new integer x
 	l01 := declaration("x", number(1))
 
@@ -62,12 +60,11 @@ func ex01() {
 	// z will be undefined (new variables from the inner scope are lost)
 	l06 := sPrint(variable("z"))
 
-	prog := generateProg([]Stmt{l01, l02, l03, l04, l05, l06})
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03, l04, l05, l06})
 }
 
 // this examples shows if-then-else with scoping rules and the use of and (short-circuit evaluation)
-func ex02() {
+func ex02() Prog {
 	// this example is based on ex01
 
 	l01 := declaration("x", number(1))
@@ -103,12 +100,11 @@ func ex02() {
 	// z is undefined, since the declaration won't leak out of its scope
 	l06 := sPrint(variable("z"))
 
-	prog := generateProg([]Stmt{l01, l02, l03, l04, l05, l06})
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03, l04, l05, l06})
 }
 
 // this example shows while loops with scoping rules and printing
-func ex03() {
+func ex03() Prog {
 	// declaring some integer variables
 	l01 := declaration("i", number(0))
 	l02 := declaration("j", number(5))
@@ -129,44 +125,39 @@ func ex03() {
 
 	l03 := while(cond, doB)
 
-	prog := generateProg([]Stmt{l01, l02, l03})
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03})
 }
 
 // this example won't type check and the evaluation fails
-func ex04() {
+func ex04() Prog {
 	l01 := declaration("x", number(4))
 	// WRONG TYPE! --> evaluation will fail
 	l02 := assignment("x", boolean(false))
 
-	prog := generateProg([]Stmt{l01, l02})
-	prog.run()
+	return generateProg([]Stmt{l01, l02})
 }
 
 // this example shows the correct re-declaration of variables
-func ex05() {
+func ex05() Prog {
 	l01 := declaration("x", number(4))
 	// this is okay! re-declaring variables works
 	l02 := declaration("x", boolean(false))
 	// this will print "false", since the re-declaration happened in the same scope
 	l03 := sPrint(variable("x"))
 
-	prog := generateProg([]Stmt{l01, l02, l03})
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03})
 }
 
 // this example shows the behaviour of undeclared variables
-func ex06() {
+func ex06() Prog {
 	// x was never declared!
 	l01 := sPrint(variable("x"))
-	prog := generateProg([]Stmt{l01})
-	prog.run()
-
 	// this example won't type check and "Undefined" will be printed
+	return generateProg([]Stmt{l01})
 }
 
 // this example shows type miss-match with ==
-func ex07() {
+func ex07() Prog {
 	l01 := declaration("x", number(4))
 
 	// x is of type integer, but a boolean is expected --> evaluation of the while condition will fail
@@ -176,27 +167,41 @@ func ex07() {
 
 	l02 := while(cond, do)
 
-	prog := generateProg([]Stmt{l01, l02})
-	prog.run()
+	return generateProg([]Stmt{l01, l02})
 }
 
 // this example shows type miss-match when re-assigning a variable
-func ex08() {
+func ex08() Prog {
 	l01 := declaration("x", number(5))
 	// x is of type integer, so it can't be assigned to type boolean!
 	l02 := assignment("x", boolean(true))
-	prog := generateProg([]Stmt{l01, l02})
-	prog.run()
+	return generateProg([]Stmt{l01, l02})
 }
 
 // this example shows how to use more complex expressions in declarations
-func ex09() {
+func ex09() Prog {
 	l01 := declaration("x", number(5))
 	// re-declaration works with another type than the original one! --> x := x < 10 --> true
 	l02 := declaration("x", lesser(variable("x"), number(10)))
 	// "true" will be printed
 	l03 := sPrint(variable("x"))
 
-	prog := generateProg([]Stmt{l01, l02, l03})
-	prog.run()
+	return generateProg([]Stmt{l01, l02, l03})
 }
+
+// all examples by name, so they can be selected from the command line
+var examples = map[string]func() Prog{
+	"fib":  fib,
+	"ex01": ex01,
+	"ex02": ex02,
+	"ex03": ex03,
+	"ex04": ex04,
+	"ex05": ex05,
+	"ex06": ex06,
+	"ex07": ex07,
+	"ex08": ex08,
+	"ex09": ex09,
+}
+
+// the names of all examples, in the order they are run
+var exampleNames = []string{"fib", "ex01", "ex02", "ex03", "ex04", "ex05", "ex06", "ex07", "ex08", "ex09"}
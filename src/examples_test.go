@@ -0,0 +1,70 @@
+package main
+
+// programs for the tests, beside the examples: they take the failure paths of eval (conditions that aren't
+// booleans, failing assignments) and nest the scopes of loops and if statements
+
+// a while condition that fails after some iterations, the nested scope is thrown away
+func failingLoop() Prog {
+	l01 := declaration("x", number(0))
+	cond := or(group(lesser(variable("x"), number(2))), variable("y"))
+	l02 := while(cond, block(assignment("x", plus(variable("x"), number(1)))))
+	l03 := declaration("x", plus(variable("x"), number(10)))
+	l04 := sPrint(variable("x"))
+	return generateProg([]Stmt{l01, l02, l03, l04})
+}
+
+// an if condition that fails, declarations and failing assignments in nested scopes
+func failingIf() Prog {
+	l01 := declaration("x", number(1))
+	l02 := ifthenelse(variable("x"), block(sPrint(number(1))), block(sPrint(number(2))))
+	l03 := declaration("y", boolean(true))
+	then01 := declaration("x", boolean(true))
+	then02 := declaration("z", number(3))
+	then03 := assignment("q", number(1))
+	l04 := ifthenelse(variable("y"), block(generateSeq([]Stmt{then01, then02, then03})), block(assignment("x", number(2))))
+	l05 := sPrint(variable("x"))
+	l06 := assignment("x", boolean(false))
+	l07 := sPrint(variable("z"))
+	return generateProg([]Stmt{l01, l02, l03, l04, l05, l06, l07})
+}
+
+// nested loops, with declarations changing the kind of a variable of the enclosing scope
+func nestedLoops() Prog {
+	l01 := declaration("i", number(0))
+	inner01 := assignment("j", plus(variable("j"), number(1)))
+	then01 := declaration("i", boolean(false))
+	else01 := sPrint(variable("j"))
+	else02 := declaration("i", plus(variable("i"), number(0)))
+	inner02 := ifthenelse(equal(variable("j"), number(1)), block(then01), block(generateSeq([]Stmt{else01, else02})))
+	do01 := declaration("j", number(0))
+	do02 := while(lesser(variable("j"), number(2)), block(generateSeq([]Stmt{inner01, inner02})))
+	do03 := assignment("i", plus(variable("i"), number(1)))
+	l02 := while(lesser(variable("i"), number(2)), block(generateSeq([]Stmt{do01, do02, do03})))
+	l03 := sPrint(variable("i"))
+	l04 := while(variable("i"), block(sPrint(number(5))))
+	return generateProg([]Stmt{l01, l02, l03, l04})
+}
+
+// a loop ending by an assignment in an if statement, while a declaration of another kind doesn't leave the scope
+func loopWithFlag() Prog {
+	l01 := declaration("b", boolean(true))
+	l02 := declaration("n", number(0))
+	do01 := assignment("n", plus(variable("n"), number(1)))
+	do02 := ifthenelse(equal(variable("n"), number(2)), block(assignment("b", boolean(false))), block(declaration("b", number(7))))
+	l03 := while(and(variable("b"), lesser(variable("n"), number(3))), block(generateSeq([]Stmt{do01, do02})))
+	return generateProg([]Stmt{l01, l02, l03})
+}
+
+// the examples and the programs above, by name
+func testPrograms() map[string]Prog {
+	prgs := map[string]Prog{
+		"failingLoop":  failingLoop(),
+		"failingIf":    failingIf(),
+		"nestedLoops":  nestedLoops(),
+		"loopWithFlag": loopWithFlag(),
+	}
+	for _, name := range exampleNames {
+		prgs[name] = examples[name]()
+	}
+	return prgs
+}
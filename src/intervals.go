@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// the interval domain for abstract interpretation (see abstract.go)
+//
+// integers are abstracted by the interval between their smallest and largest value, e.g. x<10 restricts x
+// to [-inf, 9]. Widening moves bounds that keep growing to infinity.
+// The integers of the interpreter wrap around on overflow, so the bounds are 64-bit integers as well: -inf and
+// +inf are the smallest and largest int, and a sum or product that can overflow can have any value.
+
+const (
+	negInf = math.MinInt64
+	posInf = math.MaxInt64
+)
+
+// a non-empty interval of integers
+type interval struct {
+	lo, hi int
+}
+
+func (iv interval) String() string {
+	if iv.lo == iv.hi {
+		return fmt.Sprint(iv.lo)
+	}
+	bound := func(x int) string {
+		switch x {
+		case negInf:
+			return "-inf"
+		case posInf:
+			return "+inf"
+		}
+		return fmt.Sprint(x)
+	}
+	if iv.lo == iv.hi {
+		return bound(iv.lo)
+	}
+	return "[" + bound(iv.lo) + ", " + bound(iv.hi) + "]"
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (iv interval) join(o interval) interval {
+	return interval{minInt(iv.lo, o.lo), maxInt(iv.hi, o.hi)}
+}
+
+// returns the intersection of two intervals, and false if it is empty
+func (iv interval) meet(o interval) (interval, bool) {
+	m := interval{maxInt(iv.lo, o.lo), minInt(iv.hi, o.hi)}
+	return m, m.lo <= m.hi
+}
+
+// widens iv with a bigger interval o: bounds that grew are moved to infinity
+func (iv interval) widen(o interval) interval {
+	w := iv
+	if o.lo < iv.lo {
+		w.lo = negInf
+	}
+	if o.hi > iv.hi {
+		w.hi = posInf
+	}
+	return w
+}
+
+func (iv interval) leq(o interval) bool {
+	return o.lo <= iv.lo && iv.hi <= o.hi
+}
+
+// adds two integers, returns false if the sum overflows
+func addInts(a, b int) (int, bool) {
+	s := a + b
+	return s, b == 0 || (b > 0) == (s > a)
+}
+
+// multiplies two integers, returns false if the product overflows
+func mulInts(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	p := a * b
+	return p, p/b == a && !(a == -1 && b == negInf) && !(b == -1 && a == negInf)
+}
+
+// the interval of all integers
+var anyInt = interval{negInf, posInf}
+
+func (iv interval) plus(o interval) interval {
+	lo, okLo := addInts(iv.lo, o.lo)
+	hi, okHi := addInts(iv.hi, o.hi)
+	if !okLo || !okHi {
+		return anyInt
+	}
+	return interval{lo, hi}
+}
+
+// the products of the bounds are the extremes of the products, if none of them overflows neither does any other
+func (iv interval) mult(o interval) interval {
+	var r interval
+	for i, ab := range [][2]int{{iv.lo, o.lo}, {iv.lo, o.hi}, {iv.hi, o.lo}, {iv.hi, o.hi}} {
+		p, ok := mulInts(ab[0], ab[1])
+		switch {
+		case !ok:
+			return anyInt
+		case i == 0:
+			r = interval{p, p}
+		default:
+			r.lo, r.hi = minInt(r.lo, p), maxInt(r.hi, p)
+		}
+	}
+	return r
+}
+
+// returns whether x<y can be true and whether it can be false, for x in iv and y in o
+func (iv interval) less(o interval) (canTrue, canFalse bool) {
+	return iv.lo < o.hi, iv.hi >= o.lo
+}
+
+// returns whether x==y can be true and whether it can be false, for x in iv and y in o
+func (iv interval) equal(o interval) (canTrue, canFalse bool) {
+	_, overlap := iv.meet(o)
+	return overlap, !(iv.lo == iv.hi && o.lo == o.hi && iv.lo == o.lo)
+}
+
+// the domain of intervals
+type intervalDomain struct{}
+
+func (intervalDomain) constant(n int) absNum {
+	return interval{n, n}
+}
+
+func (intervalDomain) join(a, b absNum) absNum {
+	return a.(interval).join(b.(interval))
+}
+
+func (intervalDomain) meet(a, b absNum) (absNum, bool) {
+	return a.(interval).meet(b.(interval))
+}
+
+func (intervalDomain) widen(a, b absNum) absNum {
+	return a.(interval).widen(b.(interval))
+}
+
+func (intervalDomain) leq(a, b absNum) bool {
+	return a.(interval).leq(b.(interval))
+}
+
+func (intervalDomain) contains(a absNum, n int) bool {
+	iv := a.(interval)
+	return iv.lo <= n && n <= iv.hi
+}
+
+func (intervalDomain) plus(a, b absNum) absNum {
+	return a.(interval).plus(b.(interval))
+}
+
+func (intervalDomain) mult(a, b absNum) absNum {
+	return a.(interval).mult(b.(interval))
+}
+
+func (intervalDomain) less(a, b absNum) (bool, bool) {
+	return a.(interval).less(b.(interval))
+}
+
+func (intervalDomain) equal(a, b absNum) (bool, bool) {
+	return a.(interval).equal(b.(interval))
+}
+
+func (intervalDomain) refineLess(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y := a.(interval), b.(interval)
+	// x<y: x <= hi(y)-1 and y >= lo(x)+1, not x<y: x >= lo(y) and y <= hi(x)
+	var xBound, yBound interval
+	switch {
+	case !truth:
+		xBound, yBound = interval{y.lo, posInf}, interval{negInf, x.hi}
+	case y.hi == negInf || x.lo == posInf:
+		// nothing is less than the smallest int or greater than the largest
+		return x, y, false
+	default:
+		xBound, yBound = interval{negInf, y.hi - 1}, interval{x.lo + 1, posInf}
+	}
+	x, okX := x.meet(xBound)
+	y, okY := y.meet(yBound)
+	return x, y, okX && okY
+}
+
+func (intervalDomain) refineEqual(a, b absNum, truth bool) (absNum, absNum, bool) {
+	x, y := a.(interval), b.(interval)
+	if truth {
+		m, ok := x.meet(y)
+		return m, m, ok
+	}
+	// x differs from y if y is a single value at a bound of x (and the other way round)
+	rx, okX := x.without(y)
+	ry, okY := y.without(x)
+	return rx, ry, okX && okY
+}
+
+// returns the interval without the value of o if o is a single value, and false if nothing remains
+func (iv interval) without(o interval) (interval, bool) {
+	if o.lo != o.hi {
+		return iv, true
+	}
+	switch k := o.lo; {
+	case iv.lo == k && iv.hi == k:
+		return iv, false
+	case iv.lo == k:
+		iv.lo++
+	case iv.hi == k:
+		iv.hi--
+	}
+	return iv, true
+}
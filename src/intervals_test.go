@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestIntervalsContainEval(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkAnalysis(prg, intervalDomain{}); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestIntervalsAfterLoop(t *testing.T) {
+	// widening gives x in [0, +inf] in the loop, the negated condition restricts it to 10 after the loop
+	body := block(assignment("x", plus(variable("x"), number(1))))
+	prg := generateProg([]Stmt{declaration("x", number(0)), while(lesser(variable("x"), number(10)), body)})
+	_, end := analyze(prg, intervalDomain{})
+	if got := end.String(); got != "{x: 10}" {
+		t.Errorf("the final state is %s instead of {x: 10}", got)
+	}
+}
+
+func TestIntervalsWrapAround(t *testing.T) {
+	// y+2^62 overflows in the third iteration
+	body := block(generateSeq([]Stmt{
+		assignment("y", plus(variable("y"), number(4611686018427387904))),
+		assignment("n", plus(variable("n"), number(1))),
+	}))
+	cond := and(lesser(variable("y"), variable("x")), lesser(variable("n"), number(6)))
+	prg := generateProg([]Stmt{
+		declaration("x", number(9223372036854775807)),
+		declaration("y", number(0)),
+		declaration("n", number(0)),
+		while(cond, body),
+		sPrint(variable("y")),
+	})
+	if diff := checkAnalysis(prg, intervalDomain{}); diff != "" {
+		t.Error(diff)
+	}
+	points, _ := analyze(prg, intervalDomain{})
+	if got, want := points["0.0.1.1.0"].String(), "{x: 9223372036854775807, y: 0}"; got != want {
+		t.Errorf("the state before the declaration of n is %s instead of %s", got, want)
+	}
+}
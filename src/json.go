@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// JSON encoding of programs
+// every node is a JSON object with a "kind" discriminator (the name of its Go type),
+// followed by its children in a fixed order:
+//
+//	Num, Bool              {"kind":"Num","value":1}
+//	Var                    {"kind":"Var","name":"x"}
+//	Plus, Mult, Or, And,
+//	Equal, Lesser          {"kind":"Plus","left":<exp>,"right":<exp>}
+//	Negation, Group        {"kind":"Negation","operand":<exp>}
+//	Prog                   {"kind":"Prog","block":<block>}
+//	Block                  {"kind":"Block","body":<stmt>}
+//	Seq                    {"kind":"Seq","first":<stmt>,"second":<stmt>}
+//	Decl, Assign           {"kind":"Decl","lhs":"x","rhs":<exp>}
+//	While                  {"kind":"While","cond":<exp>,"do":<block>}
+//	IfThenElse             {"kind":"IfThenElse","cond":<exp>,"then":<block>,"else":<block>}
+//	Print                  {"kind":"Print","exp":<exp>}
+
+// a field of a JSON object, fields are written in the order they are given
+type jsonField struct {
+	name string
+	val  interface{}
+}
+
+// writes a JSON object with the kind discriminator first
+func jsonObject(kind string, fields ...jsonField) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	k, _ := marshalJSON(kind)
+	buf.WriteString(`{"kind":`)
+	buf.Write(k)
+	for _, f := range fields {
+		v, err := marshalJSON(f.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`,"` + f.name + `":`)
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// like json.Marshal, but without escaping <, > and &, which appear in pretty printed expressions
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// returns the indented JSON encoding of a program
+func marshalProg(prg Prog) ([]byte, error) {
+	raw, err := encodeStmt(prg)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// rebuilds a program from its JSON encoding
+func unmarshalProg(data []byte) (Prog, error) {
+	obj, kind, err := decodeObject(data, "$")
+	if err != nil {
+		return Prog{}, err
+	}
+	return decodeProg(obj, kind, "$")
+}
+
+// encoding of expressions
+func encodeExp(e Exp) (json.RawMessage, error) {
+	switch e := e.(type) {
+	case Num:
+		return jsonObject("Num", jsonField{"value", int(e)})
+	case Bool:
+		return jsonObject("Bool", jsonField{"value", bool(e)})
+	case Plus:
+		return encodeBinary("Plus", e[0], e[1])
+	case Mult:
+		return encodeBinary("Mult", e[0], e[1])
+	case Or:
+		return encodeBinary("Or", e[0], e[1])
+	case And:
+		return encodeBinary("And", e[0], e[1])
+	case Equal:
+		return encodeBinary("Equal", e[0], e[1])
+	case Lesser:
+		return encodeBinary("Lesser", e[0], e[1])
+	case Negation:
+		return encodeUnary("Negation", e[0])
+	case Group:
+		return encodeUnary("Group", e[0])
+	case Var:
+		return jsonObject("Var", jsonField{"name", string(e)})
+	case nil:
+		return nil, fmt.Errorf("cannot encode missing expression")
+	}
+	return nil, fmt.Errorf("cannot encode expression of type %T", e)
+}
+func encodeBinary(kind string, x, y Exp) (json.RawMessage, error) {
+	l, err := encodeExp(x)
+	if err != nil {
+		return nil, err
+	}
+	r, err := encodeExp(y)
+	if err != nil {
+		return nil, err
+	}
+	return jsonObject(kind, jsonField{"left", l}, jsonField{"right", r})
+}
+func encodeUnary(kind string, x Exp) (json.RawMessage, error) {
+	o, err := encodeExp(x)
+	if err != nil {
+		return nil, err
+	}
+	return jsonObject(kind, jsonField{"operand", o})
+}
+
+// encoding of statements
+func encodeStmt(s Stmt) (json.RawMessage, error) {
+	switch s := s.(type) {
+	case Prog:
+		b, err := encodeStmt(s[0])
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Prog", jsonField{"block", b})
+	case Block:
+		b, err := encodeStmt(s[0])
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Block", jsonField{"body", b})
+	case Seq:
+		first, err := encodeStmt(s[0])
+		if err != nil {
+			return nil, err
+		}
+		second, err := encodeStmt(s[1])
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Seq", jsonField{"first", first}, jsonField{"second", second})
+	case Decl:
+		rhs, err := encodeExp(s.rhs)
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Decl", jsonField{"lhs", s.lhs}, jsonField{"rhs", rhs})
+	case Assign:
+		rhs, err := encodeExp(s.rhs)
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Assign", jsonField{"lhs", s.lhs}, jsonField{"rhs", rhs})
+	case While:
+		cond, err := encodeExp(s.cond)
+		if err != nil {
+			return nil, err
+		}
+		do, err := encodeStmt(s.do)
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("While", jsonField{"cond", cond}, jsonField{"do", do})
+	case IfThenElse:
+		cond, err := encodeExp(s.cond)
+		if err != nil {
+			return nil, err
+		}
+		th, err := encodeStmt(s.thenBl)
+		if err != nil {
+			return nil, err
+		}
+		el, err := encodeStmt(s.elseBl)
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("IfThenElse", jsonField{"cond", cond}, jsonField{"then", th}, jsonField{"else", el})
+	case Print:
+		e, err := encodeExp(s.printExp)
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject("Print", jsonField{"exp", e})
+	case nil:
+		return nil, fmt.Errorf("cannot encode missing statement")
+	}
+	return nil, fmt.Errorf("cannot encode statement of type %T", s)
+}
+
+// helper functions for decoding
+// errors name the position of the malformed node as a path like $.block.body.first.rhs
+
+// decodes a JSON object and returns its fields and kind
+func decodeObject(data []byte, path string) (map[string]json.RawMessage, string, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return nil, "", fmt.Errorf("%s: expected a JSON object, got %s", path, describeJSON(data))
+	}
+	raw, ok := obj["kind"]
+	if !ok {
+		return nil, "", fmt.Errorf("%s: missing field \"kind\"", path)
+	}
+	var kind string
+	if err := json.Unmarshal(raw, &kind); err != nil {
+		return nil, "", fmt.Errorf("%s.kind: expected a string, got %s", path, describeJSON(raw))
+	}
+	return obj, kind, nil
+}
+
+// returns a short description of a JSON value for error messages
+func describeJSON(data []byte) string {
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return "nothing"
+	}
+	if len(s) > 20 {
+		s = s[:20] + "..."
+	}
+	return s
+}
+
+// makes sure that an object has exactly the expected fields (besides "kind")
+func checkFields(obj map[string]json.RawMessage, path, kind string, names ...string) error {
+	expected := map[string]bool{"kind": true}
+	for _, n := range names {
+		expected[n] = true
+		if _, ok := obj[n]; !ok {
+			return fmt.Errorf("%s: %s is missing field %q", path, kind, n)
+		}
+	}
+	var unknown []string
+	for n := range obj {
+		if !expected[n] {
+			unknown = append(unknown, n)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%s: %s has unknown field %q", path, kind, unknown[0])
+	}
+	return nil
+}
+
+func decodeInt(raw json.RawMessage, path string) (int, error) {
+	var x int
+	if err := json.Unmarshal(raw, &x); err != nil {
+		return 0, fmt.Errorf("%s: expected an integer, got %s", path, describeJSON(raw))
+	}
+	return x, nil
+}
+func decodeBool(raw json.RawMessage, path string) (bool, error) {
+	var x bool
+	if err := json.Unmarshal(raw, &x); err != nil {
+		return false, fmt.Errorf("%s: expected a boolean, got %s", path, describeJSON(raw))
+	}
+	return x, nil
+}
+func decodeName(raw json.RawMessage, path string) (string, error) {
+	var x string
+	if err := json.Unmarshal(raw, &x); err != nil {
+		return "", fmt.Errorf("%s: expected a string, got %s", path, describeJSON(raw))
+	}
+	if x == "" {
+		return "", fmt.Errorf("%s: variable name must not be empty", path)
+	}
+	return x, nil
+}
+
+// decoding of expressions
+func decodeExp(data []byte, path string) (Exp, error) {
+	obj, kind, err := decodeObject(data, path)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "Num":
+		if err := checkFields(obj, path, kind, "value"); err != nil {
+			return nil, err
+		}
+		x, err := decodeInt(obj["value"], path+".value")
+		if err != nil {
+			return nil, err
+		}
+		return Num(x), nil
+	case "Bool":
+		if err := checkFields(obj, path, kind, "value"); err != nil {
+			return nil, err
+		}
+		x, err := decodeBool(obj["value"], path+".value")
+		if err != nil {
+			return nil, err
+		}
+		return Bool(x), nil
+	case "Var":
+		if err := checkFields(obj, path, kind, "name"); err != nil {
+			return nil, err
+		}
+		x, err := decodeName(obj["name"], path+".name")
+		if err != nil {
+			return nil, err
+		}
+		return Var(x), nil
+	case "Plus", "Mult", "Or", "And", "Equal", "Lesser":
+		if err := checkFields(obj, path, kind, "left", "right"); err != nil {
+			return nil, err
+		}
+		l, err := decodeExp(obj["left"], path+".left")
+		if err != nil {
+			return nil, err
+		}
+		r, err := decodeExp(obj["right"], path+".right")
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "Plus":
+			return Plus{l, r}, nil
+		case "Mult":
+			return Mult{l, r}, nil
+		case "Or":
+			return Or{l, r}, nil
+		case "And":
+			return And{l, r}, nil
+		case "Equal":
+			return Equal{l, r}, nil
+		default:
+			return Lesser{l, r}, nil
+		}
+	case "Negation", "Group":
+		if err := checkFields(obj, path, kind, "operand"); err != nil {
+			return nil, err
+		}
+		o, err := decodeExp(obj["operand"], path+".operand")
+		if err != nil {
+			return nil, err
+		}
+		if kind == "Negation" {
+			return Negation{o}, nil
+		}
+		return Group{o}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown expression kind %q", path, kind)
+}
+
+// decoding of statements
+func decodeStmt(data []byte, path string) (Stmt, error) {
+	obj, kind, err := decodeObject(data, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStmtObject(obj, kind, path)
+}
+func decodeBlock(data []byte, path string) (Block, error) {
+	obj, kind, err := decodeObject(data, path)
+	if err != nil {
+		return Block{}, err
+	}
+	if kind != "Block" {
+		return Block{}, fmt.Errorf("%s: expected kind \"Block\", got %q", path, kind)
+	}
+	s, err := decodeStmtObject(obj, kind, path)
+	if err != nil {
+		return Block{}, err
+	}
+	return s.(Block), nil
+}
+func decodeProg(obj map[string]json.RawMessage, kind, path string) (Prog, error) {
+	if kind != "Prog" {
+		return Prog{}, fmt.Errorf("%s: expected kind \"Prog\", got %q", path, kind)
+	}
+	if err := checkFields(obj, path, kind, "block"); err != nil {
+		return Prog{}, err
+	}
+	b, err := decodeBlock(obj["block"], path+".block")
+	if err != nil {
+		return Prog{}, err
+	}
+	return Prog{b}, nil
+}
+func decodeStmtObject(obj map[string]json.RawMessage, kind, path string) (Stmt, error) {
+	switch kind {
+	case "Prog":
+		return nil, fmt.Errorf("%s: a Prog is only allowed at the root", path)
+	case "Block":
+		if err := checkFields(obj, path, kind, "body"); err != nil {
+			return nil, err
+		}
+		s, err := decodeStmt(obj["body"], path+".body")
+		if err != nil {
+			return nil, err
+		}
+		return Block{s}, nil
+	case "Seq":
+		if err := checkFields(obj, path, kind, "first", "second"); err != nil {
+			return nil, err
+		}
+		first, err := decodeStmt(obj["first"], path+".first")
+		if err != nil {
+			return nil, err
+		}
+		second, err := decodeStmt(obj["second"], path+".second")
+		if err != nil {
+			return nil, err
+		}
+		return Seq{first, second}, nil
+	case "Decl", "Assign":
+		if err := checkFields(obj, path, kind, "lhs", "rhs"); err != nil {
+			return nil, err
+		}
+		lhs, err := decodeName(obj["lhs"], path+".lhs")
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := decodeExp(obj["rhs"], path+".rhs")
+		if err != nil {
+			return nil, err
+		}
+		if kind == "Decl" {
+			return Decl{lhs, rhs}, nil
+		}
+		return Assign{lhs, rhs}, nil
+	case "While":
+		if err := checkFields(obj, path, kind, "cond", "do"); err != nil {
+			return nil, err
+		}
+		cond, err := decodeExp(obj["cond"], path+".cond")
+		if err != nil {
+			return nil, err
+		}
+		do, err := decodeBlock(obj["do"], path+".do")
+		if err != nil {
+			return nil, err
+		}
+		return While{cond, do}, nil
+	case "IfThenElse":
+		if err := checkFields(obj, path, kind, "cond", "then", "else"); err != nil {
+			return nil, err
+		}
+		cond, err := decodeExp(obj["cond"], path+".cond")
+		if err != nil {
+			return nil, err
+		}
+		th, err := decodeBlock(obj["then"], path+".then")
+		if err != nil {
+			return nil, err
+		}
+		el, err := decodeBlock(obj["else"], path+".else")
+		if err != nil {
+			return nil, err
+		}
+		return IfThenElse{cond, th, el}, nil
+	case "Print":
+		if err := checkFields(obj, path, kind, "exp"); err != nil {
+			return nil, err
+		}
+		e, err := decodeExp(obj["exp"], path+".exp")
+		if err != nil {
+			return nil, err
+		}
+		return Print{e}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown statement kind %q", path, kind)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	prgs := testPrograms()
+	// every kind of expression
+	prgs["expressions"] = generateProg([]Stmt{
+		declaration("x", mult(group(plus(number(-1), variable("y"))), number(3))),
+		sPrint(or(and(boolean(true), negation(variable("b"))), equal(lesser(number(1), variable("x")), boolean(false)))),
+	})
+	for name, prg := range prgs {
+		data, err := marshalProg(prg)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		got, err := unmarshalProg(data)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, prg) {
+			t.Errorf("%s: decoded as\n%s\ninstead of\n%s", name, got.pretty(), prg.pretty())
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	inPrint := func(exp string) string {
+		return `{"kind":"Prog","block":{"kind":"Block","body":{"kind":"Print","exp":` + exp + `}}}`
+	}
+	tests := map[string]string{
+		``:                           "$: expected a JSON object, got nothing",
+		`[1, 2]`:                     "$: expected a JSON object, got [1, 2]",
+		`{"block":{}}`:               `$: missing field "kind"`,
+		`{"kind":1}`:                 "$.kind: expected a string, got 1",
+		`{"kind":"Block","body":{}}`: `$: expected kind "Prog", got "Block"`,
+		`{"kind":"Prog"}`:            `$: Prog is missing field "block"`,
+		`{"kind":"Prog","block":{"kind":"Block","body":{"kind":"Prog","block":{}}}}`: "$.block.body: a Prog is only allowed at the root",
+		inPrint(`{"kind":"Num","value":1.5}`):                                        "$.block.body.exp.value: expected an integer, got 1.5",
+		inPrint(`{"kind":"Bool","value":1}`):                                         "$.block.body.exp.value: expected a boolean, got 1",
+		inPrint(`{"kind":"Var","name":""}`):                                          "$.block.body.exp.name: variable name must not be empty",
+		inPrint(`{"kind":"Plus","left":{"kind":"Num","value":1}}`):                   `$.block.body.exp: Plus is missing field "right"`,
+		inPrint(`{"kind":"Num","value":1,"type":"int"}`):                             `$.block.body.exp: Num has unknown field "type"`,
+		inPrint(`{"kind":"Minus"}`):                                                  "Minus",
+	}
+	for data, want := range tests {
+		if _, err := unmarshalProg([]byte(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got error %v, want %s", data, err, want)
+		}
+	}
+}
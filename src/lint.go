@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// static linter for common mistakes in IMP programs
+// every warning has a stable code, so warnings can be filtered or looked up
+
+const (
+	// a variable of an outer scope is redeclared in a nested scope, an assignment was probably meant
+	lintInnerRedeclaration = "IMP001"
+	// a variable is redeclared with a different type in the same scope
+	lintTypeRedeclaration = "IMP002"
+	// a variable is read (or assigned) before it is declared
+	lintUndeclared = "IMP003"
+	// a condition is always true or always false
+	lintConstantCondition = "IMP004"
+	// none of the variables of a while condition is modified in the loop
+	lintUnmodifiedLoop = "IMP005"
+	// a declared or assigned value is never observed (see eliminateDeadCode)
+	lintUnusedValue = "IMP006"
+)
+
+// a warning of the linter about the statement at path, which is on the given line of the pretty printed program
+type warning struct {
+	code string
+	path Path
+	line int
+	msg  string
+}
+
+func (w warning) String() string {
+	return fmt.Sprintf("line %d (%s): %s %s", w.line, w.path, w.code, w.msg)
+}
+
+// returns the warnings for a program, ordered by their position in the program
+func lintProg(prg Prog) []warning {
+	l := &linter{}
+	l.stmt(prg[0], Path{0}, make(kindEnv), make(varSet))
+	_, removed := eliminateDeadCode(prg)
+	for _, r := range removed {
+		l.warn(lintUnusedValue, r.path, "%s", r.reason)
+	}
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		return l.warnings[i].path.less(l.warnings[j].path)
+	})
+	lines := lineNumbers(prg)
+	for i := range l.warnings {
+		l.warnings[i].line = lines[l.warnings[i].path.String()]
+	}
+	return l.warnings
+}
+
+type linter struct {
+	warnings []warning
+}
+
+func (l *linter) warn(code string, p Path, format string, args ...interface{}) {
+	l.warnings = append(l.warnings, warning{code: code, path: p, msg: fmt.Sprintf(format, args...)})
+}
+
+// checks a statement, env holds the kinds of the variables, local the variables declared in the current scope
+func (l *linter) stmt(s Stmt, p Path, env kindEnv, local varSet) {
+	switch s := s.(type) {
+	case Prog:
+		l.stmt(s[0], p.child(0), env, local)
+	case Block:
+		l.stmt(s[0], p.child(0), env, local)
+	case Seq:
+		l.stmt(s[0], p.child(0), env, local)
+		l.stmt(s[1], p.child(1), env, local)
+	case Decl:
+		l.reads(s.rhs, p, env)
+		ks := env.kinds(s.rhs)
+		if old, declared := env[s.lhs]; declared {
+			switch {
+			case !local[s.lhs] && old&ks == 0:
+				l.warn(lintInnerRedeclaration, p, "%s is redeclared in a nested scope with type %s, the new value won't leave the scope (did you mean %s = ...?)", s.lhs, showKinds(ks), s.lhs)
+			case !local[s.lhs]:
+				l.warn(lintInnerRedeclaration, p, "%s is redeclared in a nested scope, but behaves like an assignment (did you mean %s = ...?)", s.lhs, s.lhs)
+			case old&ks == 0:
+				l.warn(lintTypeRedeclaration, p, "%s is redeclared with type %s, it had type %s", s.lhs, showKinds(ks), showKinds(old))
+			}
+		}
+		env[s.lhs] = ks
+		local[s.lhs] = true
+	case Assign:
+		if _, declared := env[s.lhs]; !declared {
+			l.warn(lintUndeclared, p, "%s is assigned before it is declared", s.lhs)
+		}
+		l.reads(s.rhs, p, env)
+	case Print:
+		l.reads(s.printExp, p, env)
+	case IfThenElse:
+		l.reads(s.cond, p, env)
+		l.condition(s.cond, p, env)
+		l.stmt(s.thenBl, p.child(1), env.copy(), make(varSet))
+		l.stmt(s.elseBl, p.child(2), env.copy(), make(varSet))
+	case While:
+		l.reads(s.cond, p, env)
+		if !l.condition(s.cond, p, env) {
+			modified := make(varSet)
+			escapingWrites(s.do, env.copy(), env, modified)
+			vars := expVars(s.cond)
+			unmodified := true
+			for x := range vars {
+				if modified[x] {
+					unmodified = false
+				}
+			}
+			if unmodified {
+				l.warn(lintUnmodifiedLoop, p, "the loop never modifies %s, so it runs never or forever", strings.Join(vars.sorted(), ", "))
+			}
+		}
+		l.stmt(s.do, p.child(1), env.copy(), make(varSet))
+	}
+}
+
+// adds the variables of the enclosing scope (with the kinds outer) whose values a statement in a nested scope
+// (with the kinds env) can change: ValState.update only keeps the values of the same kind, so declarations and
+// assignments of values of another kind don't count
+func escapingWrites(s Stmt, env, outer kindEnv, vs varSet) {
+	switch s := s.(type) {
+	case Block:
+		escapingWrites(s[0], env, outer, vs)
+	case Seq:
+		escapingWrites(s[0], env, outer, vs)
+		escapingWrites(s[1], env, outer, vs)
+	case Decl:
+		ks := env.kinds(s.rhs)
+		env[s.lhs] = ks
+		if outer[s.lhs]&ks != 0 {
+			vs[s.lhs] = true
+		}
+	case Assign:
+		if outer[s.lhs]&env[s.lhs]&env.kinds(s.rhs) != 0 {
+			vs[s.lhs] = true
+		}
+	case IfThenElse:
+		escapingWrites(s.thenBl, env.copy(), outer, vs)
+		escapingWrites(s.elseBl, env.copy(), outer, vs)
+	case While:
+		escapingWrites(s.do, env.copy(), outer, vs)
+	}
+}
+
+// warns about variables an expression reads before they are declared
+func (l *linter) reads(e Exp, p Path, env kindEnv) {
+	for _, x := range expVars(e).sorted() {
+		if _, declared := env[x]; !declared {
+			l.warn(lintUndeclared, p, "%s is read before it is declared", x)
+		}
+	}
+}
+
+// warns about a condition that is always true or always false, returns whether it did
+func (l *linter) condition(cond Exp, p Path, env kindEnv) bool {
+	if b, ok := optimizeExp(cond, env).(Bool); ok {
+		l.warn(lintConstantCondition, p, "the condition %s is always %s", cond.pretty(), b.pretty())
+		return true
+	}
+	return false
+}
+
+// returns a set of kinds as string, like "Int or Bool"
+func showKinds(ks kindSet) string {
+	var names []string
+	if ks&kindInt != 0 {
+		names = append(names, "Int")
+	}
+	if ks&kindBool != 0 {
+		names = append(names, "Bool")
+	}
+	if ks&kindUndef != 0 {
+		names = append(names, "Undefined")
+	}
+	return strings.Join(names, " or ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := map[string]struct {
+		prg  Prog
+		want []string
+	}{
+		"inner redeclaration": {
+			generateProg([]Stmt{
+				declaration("x", number(1)),
+				ifthenelse(lesser(variable("x"), number(2)), block(declaration("x", number(2))), block(declaration("x", boolean(true)))),
+				sPrint(variable("x")),
+			}),
+			[]string{
+				"line 4 (0.0.1.0.1.0): IMP001 x is redeclared in a nested scope, but behaves like an assignment (did you mean x = ...?)",
+				"line 6 (0.0.1.0.2.0): IMP001 x is redeclared in a nested scope with type Bool, the new value won't leave the scope (did you mean x = ...?)",
+			},
+		},
+		"type redeclaration": {
+			generateProg([]Stmt{declaration("x", number(1)), sPrint(variable("x")), declaration("x", boolean(true)), sPrint(variable("x"))}),
+			[]string{"line 4 (0.0.1.1.0): IMP002 x is redeclared with type Bool, it had type Int"},
+		},
+		"undeclared": {
+			generateProg([]Stmt{assignment("x", number(1)), sPrint(variable("y"))}),
+			[]string{
+				"line 2 (0.0.0): IMP003 x is assigned before it is declared",
+				"line 3 (0.0.1): IMP003 y is read before it is declared",
+			},
+		},
+		"constant conditions": {
+			generateProg([]Stmt{
+				declaration("x", number(1)),
+				ifthenelse(lesser(number(1), number(2)), block(sPrint(variable("x"))), block(sPrint(number(0)))),
+				while(and(boolean(false), lesser(variable("x"), number(2))), block(assignment("x", number(2)))),
+			}),
+			[]string{
+				"line 3 (0.0.1.0): IMP004 the condition (1<2) is always true",
+				"line 8 (0.0.1.1): IMP004 the condition (false && (x<2)) is always false",
+			},
+		},
+		"unmodified loop": {
+			generateProg([]Stmt{
+				declaration("x", number(0)),
+				declaration("y", number(0)),
+				// a declaration of another kind doesn't change x after the iteration
+				while(lesser(variable("x"), number(3)), block(generateSeq([]Stmt{declaration("x", boolean(true)), sPrint(variable("x"))}))),
+				// the assignment changes y in the nested scope of the if statement, and its value leaves the scope
+				while(lesser(variable("y"), number(3)), block(ifthenelse(boolean(true), block(assignment("y", plus(variable("y"), number(1)))), block(sPrint(variable("y")))))),
+				// a declaration of the same kind behaves like an assignment
+				while(lesser(variable("x"), number(3)), block(declaration("x", plus(variable("x"), number(1))))),
+			}),
+			[]string{
+				"line 4 (0.0.1.1.0): IMP005 the loop never modifies x, so it runs never or forever",
+				"line 5 (0.0.1.1.0.1.0.0): IMP001 x is redeclared in a nested scope with type Bool, the new value won't leave the scope (did you mean x = ...?)",
+				"line 9 (0.0.1.1.1.0.1.0): IMP004 the condition true is always true",
+				"line 16 (0.0.1.1.1.1.1.0): IMP001 x is redeclared in a nested scope, but behaves like an assignment (did you mean x = ...?)",
+			},
+		},
+		"unused value": {
+			generateProg([]Stmt{declaration("x", number(1)), declaration("y", number(2)), sPrint(variable("x"))}),
+			[]string{"line 3 (0.0.1.0): IMP006 the value declared for y is never read"},
+		},
+	}
+	for name, test := range tests {
+		var got []string
+		for _, w := range lintProg(test.prg) {
+			got = append(got, w.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
 // ValState is a mapping from variable names to values
 type ValState map[string]Val
@@ -9,17 +12,19 @@ type ValState map[string]Val
 type TyState map[string]Type
 
 func main() {
+	// with arguments, run the selected command of the command line interface
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "imp: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Printf("\n")
 
 	// run the individual examples
-	fib()
-	ex01()
-	ex02()
-	ex03()
-	ex04()
-	ex05()
-	ex06()
-	ex07()
-	ex08()
-	ex09()
+	for _, name := range exampleNames {
+		examples[name]().run()
+	}
 }
@@ -0,0 +1,266 @@
+package main
+
+// constant folding and algebraic simplification
+//
+// the optimized program prints the same output and ends in the same state as the original.
+// identities like x*1 = x only hold if x evaluates to an integer, so the pass keeps track of the kinds
+// of values each variable can have. These kinds are exact and easy to track: an assignment never
+// changes the kind of a variable (a different kind makes it fail), and ValState.update never lets a
+// kind change or a new variable leave a nested scope. Only declarations change kinds.
+
+// the set of kinds (Int, Bool, Undefined) an expression can evaluate to
+type kindSet uint8
+
+const (
+	kindInt   kindSet = 1 << ValueInt
+	kindBool  kindSet = 1 << ValueBool
+	kindUndef kindSet = 1 << Undefined
+)
+
+// the kinds of the variables at a program point; undeclared variables evaluate to Undefined
+type kindEnv map[string]kindSet
+
+func (env kindEnv) copy() kindEnv {
+	c := make(kindEnv)
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
+
+// returns whether an expression always evaluates to a value of one of the given kinds
+func (env kindEnv) always(e Exp, ks kindSet) bool {
+	return env.kinds(e)&^ks == 0
+}
+
+// returns the kinds an expression can evaluate to
+// for operators, the result is found by evaluating them on a representative value of each kind
+func (env kindEnv) kinds(e Exp) kindSet {
+	switch e := e.(type) {
+	case Num:
+		return kindInt
+	case Bool:
+		return kindBool
+	case Var:
+		if k, ok := env[string(e)]; ok {
+			return k
+		}
+		return kindUndef
+	case Group:
+		return env.kinds(e[0])
+	}
+	cs := children(e)
+	args := make([][]Exp, len(cs))
+	for i, c := range cs {
+		args[i] = representatives(env.kinds(toExp(c)))
+	}
+	var ks kindSet
+	empty := make(map[string]Val)
+	var combine func(i int, chosen []Node)
+	combine = func(i int, chosen []Node) {
+		if i == len(args) {
+			ks |= 1 << toExp(withChildren(e, chosen)).eval(empty).flag
+			return
+		}
+		for _, a := range args[i] {
+			combine(i+1, append(chosen, a))
+		}
+	}
+	combine(0, nil)
+	return ks
+}
+
+// returns expressions covering every value of the given kinds that makes a difference for the operators
+// (an undeclared variable stands for Undefined)
+func representatives(ks kindSet) []Exp {
+	var reps []Exp
+	if ks&kindInt != 0 {
+		reps = append(reps, Num(0), Num(1))
+	}
+	if ks&kindBool != 0 {
+		reps = append(reps, Bool(true), Bool(false))
+	}
+	if ks&kindUndef != 0 {
+		reps = append(reps, Var(""))
+	}
+	return reps
+}
+
+// optimizes a program
+func optimizeProg(prg Prog) Prog {
+	env := make(kindEnv)
+	s, _ := optimizeStmt(prg, env)
+	return s.(Prog)
+}
+
+// optimizes a statement, env holds the kinds of the variables before the statement and is updated
+// returns the optimized statement and whether it can be dropped, since it doesn't do anything
+func optimizeStmt(s Stmt, env kindEnv) (Stmt, bool) {
+	switch s := s.(type) {
+	case Prog:
+		b, _ := optimizeStmt(s[0], env)
+		return Prog{b.(Block)}, false
+	case Block:
+		// a block has to keep its statement, even if the statement doesn't do anything
+		x, noop := optimizeStmt(s[0], env)
+		return Block{x}, noop
+	case Seq:
+		x, noop1 := optimizeStmt(s[0], env)
+		y, noop2 := optimizeStmt(s[1], env)
+		switch {
+		case noop1 && noop2:
+			return Seq{x, y}, true
+		case noop1:
+			return y, false
+		case noop2:
+			return x, false
+		}
+		return Seq{x, y}, false
+	case Decl:
+		rhs := optimizeExp(s.rhs, env)
+		env[s.lhs] = env.kinds(rhs)
+		return Decl{s.lhs, rhs}, false
+	case Assign:
+		// assignments don't change kinds
+		return Assign{s.lhs, optimizeExp(s.rhs, env)}, false
+	case Print:
+		return Print{optimizeExp(s.printExp, env)}, false
+	case While:
+		cond := optimizeExp(s.cond, env)
+		if cond == Bool(false) {
+			// the loop is never entered and the nested scope doesn't change anything
+			return While{cond, s.do}, true
+		}
+		// every iteration starts with the kinds of the outer scope
+		do, _ := optimizeStmt(s.do, env.copy())
+		return While{cond, do.(Block)}, false
+	case IfThenElse:
+		cond := optimizeExp(s.cond, env)
+		th, noop1 := optimizeStmt(s.thenBl, env.copy())
+		el, noop2 := optimizeStmt(s.elseBl, env.copy())
+		thenBl, elseBl := th.(Block), el.(Block)
+		if b, ok := cond.(Bool); ok {
+			// keep only the branch that is taken, if it can leave its nested scope
+			taken, noop := thenBl, noop1
+			if !b {
+				taken, noop = elseBl, noop2
+			}
+			if noop || !declaresInScope(taken) {
+				return taken[0], noop
+			}
+		}
+		// nothing happens if both branches don't do anything and the condition can't fail
+		noop := noop1 && noop2 && env.always(cond, kindBool)
+		return IfThenElse{cond, thenBl, elseBl}, noop
+	}
+	return s, false
+}
+
+// returns whether a statement declares a variable in the current scope (nested scopes are not considered)
+// without such declarations, running the statement in a nested scope has the same effect as running it directly
+func declaresInScope(s Stmt) bool {
+	found := false
+	walkPre(s, func(n Node) bool {
+		switch n.(type) {
+		case Decl:
+			found = true
+		case While, IfThenElse:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// optimizes an expression bottom-up, env holds the kinds of the variables
+func optimizeExp(e Exp, env kindEnv) Exp {
+	return rewriteExps(e, func(e Exp) Exp {
+		return simplifyExp(e, env)
+	}).(Exp)
+}
+
+// folds an expression, whose children are already simplified, and applies algebraic identities
+func simplifyExp(e Exp, env kindEnv) Exp {
+	if c, ok := foldConstant(e); ok {
+		return c
+	}
+	isInt := func(x Exp) bool { return env.always(x, kindInt) }
+	isBool := func(x Exp) bool { return env.always(x, kindBool) }
+	// Undefined stays Undefined when negated or combined with true by && (false by ||)
+	isBoolOrUndef := func(x Exp) bool { return env.always(x, kindBool|kindUndef) }
+
+	switch e := e.(type) {
+	case Plus:
+		switch {
+		case e[1] == Num(0) && isInt(e[0]):
+			return e[0]
+		case e[0] == Num(0) && isInt(e[1]):
+			return e[1]
+		}
+	case Mult:
+		switch {
+		case e[1] == Num(1) && isInt(e[0]):
+			return e[0]
+		case e[0] == Num(1) && isInt(e[1]):
+			return e[1]
+		case e[1] == Num(0) && isInt(e[0]), e[0] == Num(0) && isInt(e[1]):
+			return Num(0)
+		}
+	case Negation:
+		if inner, ok := e[0].(Negation); ok && isBoolOrUndef(inner[0]) {
+			return inner[0]
+		}
+	case And:
+		switch {
+		case e[0] == Bool(false):
+			// the right side isn't considered at all
+			return Bool(false)
+		case e[0] == Bool(true) && isBoolOrUndef(e[1]):
+			return e[1]
+		case e[1] == Bool(true) && isBoolOrUndef(e[0]):
+			return e[0]
+		case e[1] == Bool(false) && isBool(e[0]):
+			return Bool(false)
+		}
+	case Or:
+		switch {
+		case e[0] == Bool(true):
+			// the right side isn't considered at all
+			return Bool(true)
+		case e[0] == Bool(false) && isBoolOrUndef(e[1]):
+			return e[1]
+		case e[1] == Bool(false) && isBoolOrUndef(e[0]):
+			return e[0]
+		case e[1] == Bool(true) && isBool(e[0]):
+			return Bool(true)
+		}
+	}
+	return e
+}
+
+// evaluates an expression without variables to a literal
+// Undefined has no literal, so such expressions are not folded
+func foldConstant(e Exp) (Exp, bool) {
+	switch e.(type) {
+	case Num, Bool, Var:
+		return e, false
+	}
+	constant := true
+	walkPre(e, func(n Node) bool {
+		if _, ok := n.(Var); ok {
+			constant = false
+		}
+		return constant
+	})
+	if !constant {
+		return e, false
+	}
+	v := e.eval(make(map[string]Val))
+	switch v.flag {
+	case ValueInt:
+		return Num(v.valI), true
+	case ValueBool:
+		return Bool(v.valB), true
+	}
+	return e, false
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestOptimizeKeepsResults(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := diffEval(prg, optimizeProg(prg)); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestOptimizeEdgeCases(t *testing.T) {
+	tests := map[string][]Stmt{
+		// the taken branch can't replace the if statement, its declarations would leave the nested scope
+		"folded if declaring": {
+			declaration("x", number(1)),
+			ifthenelse(boolean(true), block(generateSeq([]Stmt{declaration("x", boolean(true)), declaration("y", number(2))})), block(sPrint(number(0)))),
+			ifthenelse(lesser(number(1), number(0)), block(sPrint(number(0))), block(declaration("x", number(5)))),
+			sPrint(variable("x")),
+			sPrint(variable("y")),
+		},
+		"folded if assigning": {
+			declaration("x", number(1)),
+			ifthenelse(or(boolean(false), boolean(true)), block(assignment("x", number(2))), block(sPrint(number(0)))),
+			sPrint(variable("x")),
+		},
+		"while false": {
+			declaration("x", number(1)),
+			while(boolean(false), block(declaration("x", boolean(true)))),
+			while(and(boolean(false), variable("u")), block(sPrint(number(0)))),
+			while(lesser(number(1), number(0)), block(assignment("x", number(2)))),
+			sPrint(variable("x")),
+		},
+		"identities on other kinds": {
+			declaration("b", boolean(true)),
+			declaration("n", number(3)),
+			sPrint(mult(variable("b"), number(1))),
+			sPrint(mult(number(1), variable("u"))),
+			sPrint(mult(variable("b"), number(0))),
+			sPrint(plus(variable("b"), number(0))),
+			sPrint(negation(negation(variable("n")))),
+			sPrint(negation(negation(variable("u")))),
+			sPrint(negation(negation(variable("b")))),
+			sPrint(and(boolean(true), variable("n"))),
+			sPrint(or(variable("n"), boolean(true))),
+		},
+		"failing assignments": {
+			declaration("x", number(1)),
+			assignment("x", and(boolean(true), boolean(true))),
+			assignment("y", mult(number(1), number(2))),
+			assignment("x", mult(variable("x"), number(1))),
+			sPrint(variable("x")),
+			sPrint(variable("y")),
+		},
+	}
+	for name, lines := range tests {
+		prg := generateProg(lines)
+		if diff := diffEval(prg, optimizeProg(prg)); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsing of expressions, written like pretty prints them (e.g. watch expressions of the debugger)
+//
+// binary operators bind, from weakest to strongest: ||, &&, ==, <, +, *, followed by the prefix operator !
+// parentheses may be omitted where the precedence makes them unnecessary. A parenthesized expression
+// is parsed as the expression itself, so pretty(parseExp(pretty(e))) is pretty(e) for expressions without Group.
+
+// parses an expression
+func parseExp(src string) (Exp, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &expParser{tokens: tokens}
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], src)
+	}
+	return e, nil
+}
+
+// splits an expression into numbers, names and operators
+func tokenize(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(src[i:], "||") || strings.HasPrefix(src[i:], "&&") || strings.HasPrefix(src[i:], "=="):
+			tokens = append(tokens, src[i:i+2])
+			i += 2
+		case strings.ContainsRune("<+*!()", rune(c)):
+			tokens = append(tokens, src[i:i+1])
+			i++
+		case c == '-' || isDigit(c):
+			j := i + 1
+			for j < len(src) && isDigit(src[j]) {
+				j++
+			}
+			if j == i+1 && c == '-' {
+				return nil, fmt.Errorf("expected a number after - in %q", src)
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			j := i + 1
+			for j < len(src) && validName(src[i:j+1]) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", c, src)
+		}
+	}
+	return tokens, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+type expParser struct {
+	tokens []string
+	pos    int
+}
+
+// the binary operators by precedence, with the constructors of their expressions
+var binaryOperators = []struct {
+	op   string
+	make func(l, r Exp) Exp
+}{
+	{"||", func(l, r Exp) Exp { return Or{l, r} }},
+	{"&&", func(l, r Exp) Exp { return And{l, r} }},
+	{"==", func(l, r Exp) Exp { return Equal{l, r} }},
+	{"<", func(l, r Exp) Exp { return Lesser{l, r} }},
+	{"+", func(l, r Exp) Exp { return Plus{l, r} }},
+	{"*", func(l, r Exp) Exp { return Mult{l, r} }},
+}
+
+func (p *expParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parses a left-associative chain of the binary operator with the given precedence (or stronger ones)
+func (p *expParser) binary(level int) (Exp, error) {
+	if level == len(binaryOperators) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == binaryOperators[level].op {
+		p.pos++
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = binaryOperators[level].make(l, r)
+	}
+	return l, nil
+}
+
+func (p *expParser) unary() (Exp, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "!":
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Negation{e}, nil
+	case tok == "(":
+		e, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("expected ) instead of %q", p.peek())
+		}
+		p.pos++
+		return e, nil
+	case tok == "true" || tok == "false":
+		return Bool(tok == "true"), nil
+	case validName(tok):
+		return Var(tok), nil
+	}
+	x, err := strconv.Atoi(tok)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	return Num(x), nil
+}
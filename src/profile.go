@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// statement-level profiler
+//
+// profileEval evaluates a program like Prog.eval and measures, for every statement, how often it was executed
+// and the wall-clock time spent in it: total includes the nested statements, self doesn't.
+// For while loops, the number of iterations is counted as well.
+// Profiles can be exported in the format of pprof (a gzipped protocol buffer), so `go tool pprof` can show them.
+
+type stmtProfile struct {
+	path       Path
+	stmt       Stmt
+	line       int
+	count      int
+	iterations int
+	total      time.Duration
+	self       time.Duration
+	// the statement enclosing this one, nil for statements in the outermost scope
+	parent *stmtProfile
+}
+
+type profile struct {
+	// all executed statements in the order they were executed first
+	stmts    []*stmtProfile
+	duration time.Duration
+	start    time.Time
+}
+
+// a statement that is being executed, with the time it started and the time spent in its nested statements so far
+type profileFrame struct {
+	stat   *stmtProfile
+	start  time.Time
+	nested time.Duration
+}
+
+type profiler struct {
+	prof  *profile
+	stats map[string]*stmtProfile
+	lines map[string]int
+	stack []profileFrame
+}
+
+// evaluates a program in a fresh state like Prog.eval and returns its profile and the final state
+func profileEval(prg Prog) (*profile, ValState) {
+	pr := &profiler{prof: &profile{start: time.Now()}, stats: make(map[string]*stmtProfile), lines: lineNumbers(prg)}
+	s := make(map[string]Val)
+	pr.hooks().exec(prg, Path{}, s)
+	pr.prof.duration = time.Since(pr.prof.start)
+	return pr.prof, s
+}
+
+// returns the hooks measuring the statements, the nested statements of programs, blocks and sequences are
+// measured on their own
+func (pr *profiler) hooks() *evalHooks {
+	return &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			switch stmt.(type) {
+			case Prog, Block, Seq:
+				return true
+			}
+			stat := pr.stats[p.String()]
+			if stat == nil {
+				stat = &stmtProfile{path: p, stmt: stmt, line: pr.lines[p.String()]}
+				if len(pr.stack) > 0 {
+					stat.parent = pr.stack[len(pr.stack)-1].stat
+				}
+				pr.stats[p.String()] = stat
+				pr.prof.stmts = append(pr.prof.stmts, stat)
+			}
+			stat.count++
+			pr.stack = append(pr.stack, profileFrame{stat: stat, start: time.Now()})
+			return true
+		},
+		cond: func(owner Stmt, p Path, s ValState, v Val, output string) {
+			if _, ok := owner.(While); ok && v.flag == ValueBool && v.valB {
+				pr.stats[p.String()].iterations++
+			}
+		},
+		done: func(stmt Stmt, p Path, s ValState, output string) {
+			switch stmt.(type) {
+			case Prog, Block, Seq:
+				return
+			}
+			frame := pr.stack[len(pr.stack)-1]
+			elapsed := time.Since(frame.start)
+			pr.stack = pr.stack[:len(pr.stack)-1]
+			frame.stat.total += elapsed
+			frame.stat.self += elapsed - frame.nested
+			if len(pr.stack) > 0 {
+				pr.stack[len(pr.stack)-1].nested += elapsed
+			}
+		},
+	}
+}
+
+// returns a report of the hottest statements, by self time (at most top statements, all if top <= 0)
+func (prof *profile) report(top int) string {
+	stmts := append([]*stmtProfile{}, prof.stmts...)
+	sort.SliceStable(stmts, func(i, j int) bool { return stmts[i].self > stmts[j].self })
+	if top > 0 && len(stmts) > top {
+		stmts = stmts[:top]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "total time %s, %d statements executed\n\n", prof.duration, prof.executions())
+	fmt.Fprintf(&b, "%6s %10s %12s %7s %12s  %s\n", "line", "count", "self", "self%", "total", "statement")
+	for _, st := range stmts {
+		percent := 0.0
+		if prof.duration > 0 {
+			percent = 100 * float64(st.self) / float64(prof.duration)
+		}
+		desc := headline(st.stmt)
+		if _, ok := st.stmt.(While); ok {
+			desc += fmt.Sprintf(" (%d iterations)", st.iterations)
+		}
+		fmt.Fprintf(&b, "%6d %10d %12s %6.1f%% %12s  %s\n", st.line, st.count, st.self, percent, st.total, desc)
+	}
+	return b.String()
+}
+
+// returns the number of executed statements
+func (prof *profile) executions() int {
+	n := 0
+	for _, st := range prof.stmts {
+		n += st.count
+	}
+	return n
+}
+
+// checks that profiling a program gives the same output and final state as Prog.eval
+// returns a description of the first difference, or an empty string
+func checkProfile(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	var gotState ValState
+	gotOut := captureOutput(func() { _, gotState = profileEval(prg) })
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- profile\n%s", wantOut, gotOut)
+	}
+	if !sameState(gotState, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, profile %s", showState(wantState), showState(gotState))
+	}
+	return ""
+}
+
+// encoding of profiles in the pprof format
+//
+// the format is the protocol buffer message perftools.profiles.Profile (see profile.proto of pprof),
+// compressed with gzip. Every statement becomes a function and a location, the stack of a sample
+// consists of the statement and the if and while statements enclosing it.
+// Samples have two values: the number of executions and the self time in nanoseconds.
+
+// a protocol buffer message being encoded
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (pb *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		pb.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	pb.WriteByte(byte(x))
+}
+
+// writes an integer field (wire type 0), zero values are omitted
+func (pb *protoBuffer) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	pb.varint(uint64(field)<<3 | 0)
+	pb.varint(x)
+}
+
+func (pb *protoBuffer) int(field int, x int64) {
+	pb.uint(field, uint64(x))
+}
+
+// writes a length-delimited field (wire type 2)
+func (pb *protoBuffer) bytes(field int, data []byte) {
+	pb.varint(uint64(field)<<3 | 2)
+	pb.varint(uint64(len(data)))
+	pb.Write(data)
+}
+
+func (pb *protoBuffer) message(field int, m *protoBuffer) {
+	pb.bytes(field, m.Bytes())
+}
+
+// writes a packed repeated integer field
+func (pb *protoBuffer) packed(field int, xs []uint64) {
+	var m protoBuffer
+	for _, x := range xs {
+		m.varint(x)
+	}
+	pb.message(field, &m)
+}
+
+// returns the profile in the pprof format, name is the name of the program
+func (prof *profile) pprof(name string) ([]byte, error) {
+	strs := []string{""}
+	index := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = int64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+	valueType := func(typ, unit string) *protoBuffer {
+		var m protoBuffer
+		m.int(1, str(typ))
+		m.int(2, str(unit))
+		return &m
+	}
+
+	var pb protoBuffer
+	pb.message(1, valueType("executions", "count"))
+	pb.message(1, valueType("time", "nanoseconds"))
+
+	// ids of functions and locations are the positions in stmts, counted from 1
+	ids := make(map[*stmtProfile]uint64)
+	for i, st := range prof.stmts {
+		ids[st] = uint64(i + 1)
+	}
+	for _, st := range prof.stmts {
+		var sample protoBuffer
+		var stack []uint64
+		for s := st; s != nil; s = s.parent {
+			stack = append(stack, ids[s])
+		}
+		sample.packed(1, stack)
+		sample.packed(2, []uint64{uint64(st.count), uint64(st.self.Nanoseconds())})
+		pb.message(2, &sample)
+	}
+	for _, st := range prof.stmts {
+		var line, loc protoBuffer
+		line.uint(1, ids[st])
+		line.int(2, int64(st.line))
+		loc.uint(1, ids[st])
+		loc.message(4, &line)
+		pb.message(4, &loc)
+	}
+	for _, st := range prof.stmts {
+		var fn protoBuffer
+		fname := fmt.Sprintf("line %d: %s", st.line, headline(st.stmt))
+		// pprof shortens names by removing parenthesized parts, like the arguments of Go functions
+		short := strings.NewReplacer("(", "[", ")", "]").Replace(fname)
+		fn.uint(1, ids[st])
+		fn.int(2, str(short))
+		fn.int(3, str(fname))
+		fn.int(4, str(name))
+		fn.int(5, int64(st.line))
+		pb.message(5, &fn)
+	}
+	// the string table has to be complete before it is written
+	period := valueType("time", "nanoseconds")
+	for _, s := range strs {
+		pb.bytes(6, []byte(s))
+	}
+	pb.int(9, prof.start.UnixNano())
+	pb.int(10, prof.duration.Nanoseconds())
+	pb.message(11, period)
+	pb.int(12, 1)
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(pb.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return gz.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestProfileKeepsResults(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkProfile(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestProfileCounts(t *testing.T) {
+	var prof *profile
+	captureOutput(func() { prof, _ = profileEval(failingLoop()) })
+	// the condition fails in the third test, after two iterations
+	want := map[int][2]int{2: {1, 0}, 3: {1, 2}, 4: {2, 0}, 6: {1, 0}, 7: {1, 0}}
+	if len(prof.stmts) != len(want) {
+		t.Errorf("%d statements were profiled instead of %d", len(prof.stmts), len(want))
+	}
+	for _, st := range prof.stmts {
+		if got := [2]int{st.count, st.iterations}; got != want[st.line] {
+			t.Errorf("line %d: count and iterations %v instead of %v", st.line, got, want[st.line])
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reaching definitions and def-use chains
+//
+// a definition is a Decl or an Assign, a use is a Var; both are identified by the path of their node. A
+// definition reaches a use if the variable can still hold the value it defined when the use is evaluated. The
+// analysis runs forward over the tree, like the liveness analysis of deadcode.go runs backward, and tracks the
+// kinds of the variables like the optimizer (see kindEnv). A Decl replaces the definitions of its variable, an
+// Assign only if it can't fail (see Assign.eval); one that always fails defines nothing.
+//
+// at the end of a nested scope, ValState.update only keeps the variables of the enclosing scope, and only
+// values that have the kind of the variable outside. So a declaration in a while body never reaches the code
+// after the loop, unless it has the kind of a variable of the enclosing scope (it behaves like an assignment
+// then, see IMP001 of the linter), while an assignment to such a variable does. Where a value of another kind
+// is dropped, the definitions from before the scope reach again. A loop is analyzed until the definitions
+// reaching its head don't change.
+//
+// the use-def chains link every use to the definitions reaching it, the def-use chains every definition to the
+// uses it reaches.
+
+// the result of the reaching definitions analysis of a program
+type reachingDefs struct {
+	// the paths of all definitions and uses, in pre-order
+	defs, uses []Path
+	// the variable of every definition and use, keyed by path
+	variables map[string]string
+	// the definitions reaching every use (use-def chains), keyed by the path of the use
+	useDef map[string][]Path
+	// the uses every definition reaches (def-use chains), keyed by the path of the definition
+	defUse map[string][]Path
+}
+
+// returns the definitions reaching the use at a path, in pre-order
+func (rd *reachingDefs) definitionsOf(use Path) []Path {
+	return rd.useDef[use.String()]
+}
+
+// returns the uses the definition at a path reaches, in pre-order
+func (rd *reachingDefs) usesOf(def Path) []Path {
+	return rd.defUse[def.String()]
+}
+
+// the definitions reaching a program point, as sets of paths by variable
+// the sets are never modified, so copies can share them
+type defSets map[string]map[string]bool
+
+func (ds defSets) copy() defSets {
+	c := make(defSets)
+	for x, set := range ds {
+		c[x] = set
+	}
+	return c
+}
+
+func (ds defSets) addAll(other defSets) {
+	for x, set := range other {
+		u := make(map[string]bool)
+		for d := range ds[x] {
+			u[d] = true
+		}
+		for d := range set {
+			u[d] = true
+		}
+		ds[x] = u
+	}
+}
+
+func (ds defSets) equal(other defSets) bool {
+	if len(ds) != len(other) {
+		return false
+	}
+	for x, set := range ds {
+		if len(set) != len(other[x]) {
+			return false
+		}
+		for d := range set {
+			if !other[x][d] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// analyzes the definitions reaching the uses of a program
+func reachingDefinitions(prg Prog) *reachingDefs {
+	rd := &reachingDefs{variables: make(map[string]string), useDef: make(map[string][]Path),
+		defUse: make(map[string][]Path)}
+	r := &reacher{rd: rd, paths: make(map[string]Path), kinds: make(map[string]kindSet),
+		reaching: make(map[string]map[string]bool)}
+	r.stmt(prg, Path{}, make(kindEnv), make(defSets))
+	sortPaths(rd.defs)
+	sortPaths(rd.uses)
+	for _, u := range rd.uses {
+		var defs []Path
+		for d := range r.reaching[u.String()] {
+			defs = append(defs, r.paths[d])
+		}
+		sortPaths(defs)
+		rd.useDef[u.String()] = defs
+		for _, d := range defs {
+			// the uses are visited in pre-order, so are the def-use chains
+			rd.defUse[d.String()] = append(rd.defUse[d.String()], u)
+		}
+	}
+	return rd
+}
+
+func sortPaths(ps []Path) {
+	sort.Slice(ps, func(i, j int) bool { return ps[i].less(ps[j]) })
+}
+
+type reacher struct {
+	rd *reachingDefs
+	// the paths of the definitions and the kinds of the values they give, keyed by path
+	paths map[string]Path
+	kinds map[string]kindSet
+	// the definitions reaching every use, keyed by path
+	reaching map[string]map[string]bool
+}
+
+// records the definition of x at a path, giving a value of the kinds ks
+func (r *reacher) define(p Path, x string, ks kindSet) {
+	key := p.String()
+	if _, ok := r.paths[key]; !ok {
+		r.rd.defs = append(r.rd.defs, p)
+		r.rd.variables[key] = x
+		r.paths[key] = p
+	}
+	r.kinds[key] = ks
+}
+
+// records the uses of an expression at path p, which the definitions in reaching reach
+func (r *reacher) exp(e Exp, p Path, reaching defSets) {
+	walkPaths(e, func(n Node, q Path) bool {
+		v, ok := n.(Var)
+		if !ok {
+			return true
+		}
+		u := append(append(Path{}, p...), q...)
+		key := u.String()
+		if _, ok := r.reaching[key]; !ok {
+			r.rd.uses = append(r.rd.uses, u)
+			r.rd.variables[key] = string(v)
+			r.reaching[key] = make(map[string]bool)
+		}
+		for d := range reaching[string(v)] {
+			r.reaching[key][d] = true
+		}
+		return true
+	})
+}
+
+// analyzes a statement, env holds the kinds of the variables and in the definitions reaching the statement
+// returns the definitions reaching its end (env and in are updated for the current scope)
+func (r *reacher) stmt(s Stmt, p Path, env kindEnv, in defSets) defSets {
+	switch s := s.(type) {
+	case Prog:
+		return r.stmt(s[0], p.child(0), env, in)
+	case Block:
+		return r.stmt(s[0], p.child(0), env, in)
+	case Seq:
+		return r.stmt(s[1], p.child(1), env, r.stmt(s[0], p.child(0), env, in))
+	case Decl:
+		r.exp(s.rhs, p.child(0), in)
+		ks := env.kinds(s.rhs)
+		r.define(p, s.lhs, ks)
+		env[s.lhs] = ks
+		in[s.lhs] = map[string]bool{p.String(): true}
+	case Assign:
+		r.exp(s.rhs, p.child(0), in)
+		ks := env.kinds(s.rhs)
+		old, declared := env[s.lhs]
+		if !declared || old&ks == 0 {
+			// the assignment always fails
+			break
+		}
+		r.define(p, s.lhs, old&ks)
+		def := defSets{s.lhs: {p.String(): true}}
+		if isSingleKind(old) && old == ks {
+			in[s.lhs] = def[s.lhs]
+		} else {
+			// the assignment can fail, keeping the old value
+			in.addAll(def)
+		}
+	case Print:
+		r.exp(s.printExp, p.child(0), in)
+	case IfThenElse:
+		r.exp(s.cond, p.child(0), in)
+		out := r.leave(env, in, r.stmt(s.thenBl, p.child(1), env.copy(), in.copy()))
+		out.addAll(r.leave(env, in, r.stmt(s.elseBl, p.child(2), env.copy(), in.copy())))
+		if !env.always(s.cond, kindBool) {
+			// no block runs if the condition fails
+			out.addAll(in)
+		}
+		return out
+	case While:
+		// the loop exits at its head, or (if the condition fails) with the definitions reaching it
+		head := in.copy()
+		for {
+			r.exp(s.cond, p.child(0), head)
+			next := head.copy()
+			next.addAll(r.leave(env, in, r.stmt(s.do, p.child(1), env.copy(), head.copy())))
+			if next.equal(head) {
+				return head
+			}
+			head = next
+		}
+	}
+	return in
+}
+
+// returns the definitions reaching the enclosing scope after a nested scope, like ValState.update: env holds
+// the kinds of the variables of the enclosing scope, in the definitions reaching the start of the nested scope
+// (the state ValState.update is called on) and inner those reaching its end
+func (r *reacher) leave(env kindEnv, in, inner defSets) defSets {
+	out := make(defSets)
+	for x, ks := range env {
+		set := make(map[string]bool)
+		for d := range inner[x] {
+			if r.kinds[d]&ks != 0 {
+				set[d] = true
+			}
+			if r.kinds[d] != ks || !isSingleKind(ks) {
+				// the value can have another kind, then the value from before the scope is kept
+				for o := range in[x] {
+					set[o] = true
+				}
+			}
+		}
+		if len(set) > 0 {
+			out[x] = set
+		}
+	}
+	return out
+}
+
+// returns the use-def and def-use chains of a program, one line per use and per definition
+func showChains(prg Prog, rd *reachingDefs) string {
+	lines := lineNumbers(prg)
+	describe := func(p Path) string {
+		n, _ := nodeAt(prg, p)
+		return fmt.Sprintf("line %d: %s", lineOf(lines, p), headline(n))
+	}
+	var b strings.Builder
+	b.WriteString("use-def chains:\n")
+	for _, u := range rd.uses {
+		var defs []string
+		for _, d := range rd.definitionsOf(u) {
+			defs = append(defs, describe(d))
+		}
+		if len(defs) == 0 {
+			defs = append(defs, "no definition")
+		}
+		fmt.Fprintf(&b, "  line %d (%s): %s <- %s\n", lineOf(lines, u), u, rd.variables[u.String()], strings.Join(defs, ", "))
+	}
+	b.WriteString("def-use chains:\n")
+	for _, d := range rd.defs {
+		var uses []string
+		for _, u := range rd.usesOf(d) {
+			uses = append(uses, fmt.Sprintf("line %d (%s)", lineOf(lines, u), u))
+		}
+		if len(uses) == 0 {
+			uses = append(uses, "no use")
+		}
+		fmt.Fprintf(&b, "  %s -> %s\n", describe(d), strings.Join(uses, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// returns the lines of the definitions reaching the last use of a program
+func lastUseDefinitions(prg Prog) string {
+	rd := reachingDefinitions(prg)
+	lines := lineNumbers(prg)
+	use := rd.uses[len(rd.uses)-1]
+	var defs []int
+	for _, d := range rd.definitionsOf(use) {
+		defs = append(defs, lineOf(lines, d))
+	}
+	return fmt.Sprintf("%s at line %d <- %v", rd.variables[use.String()], lineOf(lines, use), defs)
+}
+
+func TestReachingDefinitionsScopes(t *testing.T) {
+	x, y := variable("x"), variable("y")
+	inc := func(s string) Stmt { return assignment(s, plus(variable(s), number(1))) }
+	tests := []struct {
+		name string
+		prg  Prog
+		want string
+	}{
+		// y is only declared in the body
+		{"declaration in a loop", generateProg([]Stmt{
+			declaration("x", number(0)),
+			while(lesser(x, number(3)), block(generateSeq([]Stmt{declaration("y", number(5)), inc("x")}))),
+			sPrint(y)}), "y at line 7 <- []"},
+		{"assignment in a loop", generateProg([]Stmt{
+			declaration("x", number(0)),
+			while(lesser(x, number(3)), block(generateSeq([]Stmt{declaration("y", number(5)), inc("x")}))),
+			sPrint(x)}), "x at line 7 <- [2 5]"},
+		{"declaration of the same kind in a loop", generateProg([]Stmt{
+			declaration("x", number(0)),
+			while(lesser(x, number(3)), block(declaration("x", plus(x, number(1))))),
+			sPrint(x)}), "x at line 6 <- [2 4]"},
+		// the value of another kind is dropped at the end of the scope, and with it the increment before it
+		{"declaration of another kind in a loop", generateProg([]Stmt{
+			declaration("x", number(0)),
+			while(lesser(x, number(3)), block(generateSeq([]Stmt{inc("x"), declaration("x", boolean(true))}))),
+			sPrint(x)}), "x at line 7 <- [2]"},
+		{"declaration of another kind", generateProg([]Stmt{
+			declaration("x", number(0)),
+			ifthenelse(lesser(x, number(1)), block(declaration("x", boolean(true))), block(sPrint(x))),
+			sPrint(x)}), "x at line 8 <- [2]"},
+		// the assignment always fails
+		{"assignment of another kind", generateProg([]Stmt{
+			declaration("x", number(0)),
+			assignment("x", boolean(true)),
+			sPrint(x)}), "x at line 4 <- [2]"},
+	}
+	for _, test := range tests {
+		if got := lastUseDefinitions(test.prg); got != test.want {
+			t.Errorf("%s: %s instead of %s", test.name, got, test.want)
+		}
+	}
+}
+
+// the def-use chains are the use-def chains turned around
+func TestDefUseChainsMatchUseDefChains(t *testing.T) {
+	for name, prg := range testPrograms() {
+		rd := reachingDefinitions(prg)
+		pairs := make(map[string]bool)
+		for _, u := range rd.uses {
+			for _, d := range rd.definitionsOf(u) {
+				pairs[d.String()+" "+u.String()] = true
+				if rd.variables[d.String()] != rd.variables[u.String()] {
+					t.Errorf("%s: the definition at %s of %s reaches a use of %s", name, d, rd.variables[d.String()], rd.variables[u.String()])
+				}
+			}
+		}
+		for _, d := range rd.defs {
+			for _, u := range rd.usesOf(d) {
+				if !pairs[d.String()+" "+u.String()] {
+					t.Errorf("%s: %s -> %s is only a def-use chain", name, d, u)
+				}
+				delete(pairs, d.String()+" "+u.String())
+			}
+		}
+		for pair := range pairs {
+			t.Errorf("%s: %s is only a use-def chain", name, pair)
+		}
+	}
+}
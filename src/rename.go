@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// binding resolution and renaming of variables
+//
+// every declaration introduces a binding, and each Var and Assign refers to the binding of its name
+// that is visible at its position: the last declaration in the current scope, or else the binding
+// visible where the nested scope started. Uses of undeclared variables refer to a free binding of their name.
+// A declaration in a nested scope is linked to the outer binding of the same name if their kinds can be
+// the same, since ValState.update lets its value leave the scope. Linked bindings form a variable,
+// which can only be renamed as a whole.
+
+// a binding introduced by a declaration (or a free binding, for undeclared variables)
+type binding struct {
+	id   int
+	name string
+	// the path of the declaration, nil for free bindings
+	decl Path
+	// the binding this one is linked to, itself for the representative of a variable
+	parent *binding
+}
+
+// returns the representative binding of the variable a binding belongs to
+func (b *binding) find() *binding {
+	for b.parent != b {
+		b.parent = b.parent.parent
+		b = b.parent
+	}
+	return b
+}
+
+// the result of binding resolution
+type bindingInfo struct {
+	bindings []*binding
+	// the binding referred to by every Decl, Assign and Var, keyed by path
+	refs map[string]*binding
+	// the paths of all references, in pre-order
+	paths []Path
+	free  map[string]*binding
+}
+
+// returns the variable (representative binding) of the reference at a path, or nil
+func (info *bindingInfo) variableAt(p Path) *binding {
+	if b, ok := info.refs[p.String()]; ok {
+		return b.find()
+	}
+	return nil
+}
+
+// returns the references to a variable, in pre-order
+func (info *bindingInfo) references(v *binding) []Path {
+	var ps []Path
+	for _, p := range info.paths {
+		if info.refs[p.String()].find() == v {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// a visible binding together with the kinds of its value
+type visible struct {
+	b     *binding
+	kinds kindSet
+}
+
+// resolves the bindings of all variables of a program
+func resolveBindings(prg Prog) *bindingInfo {
+	info := &bindingInfo{refs: make(map[string]*binding), free: make(map[string]*binding)}
+	r := &resolver{info: info}
+	r.stmt(prg, Path{}, make(map[string]visible), nil)
+	sort.Slice(info.paths, func(i, j int) bool { return info.paths[i].less(info.paths[j]) })
+	return info
+}
+
+type resolver struct {
+	info *bindingInfo
+}
+
+func (r *resolver) newBinding(name string, decl Path) *binding {
+	b := &binding{id: len(r.info.bindings), name: name, decl: decl}
+	b.parent = b
+	r.info.bindings = append(r.info.bindings, b)
+	return b
+}
+
+func (r *resolver) refer(p Path, b *binding) {
+	r.info.refs[p.String()] = b
+	r.info.paths = append(r.info.paths, p)
+}
+
+// returns the binding of a name in env, or the free binding of the name
+func (r *resolver) lookup(name string, env map[string]visible) *binding {
+	if v, ok := env[name]; ok {
+		return v.b
+	}
+	if b, ok := r.info.free[name]; ok {
+		return b
+	}
+	b := r.newBinding(name, nil)
+	r.info.free[name] = b
+	return b
+}
+
+// resolves the variables of an expression at path p
+func (r *resolver) exp(e Exp, p Path, env map[string]visible) {
+	walkPaths(e, func(n Node, q Path) bool {
+		if v, ok := n.(Var); ok {
+			r.refer(append(append(Path{}, p...), q...), r.lookup(string(v), env))
+		}
+		return true
+	})
+}
+
+// kinds of the visible bindings, for kindEnv
+func visibleKinds(env map[string]visible) kindEnv {
+	ks := make(kindEnv)
+	for k, v := range env {
+		ks[k] = v.kinds
+	}
+	return ks
+}
+
+func copyVisible(env map[string]visible) map[string]visible {
+	c := make(map[string]visible)
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
+
+// resolves the variables of a statement, env holds the visible bindings,
+// entry the bindings visible where the current nested scope started (nil for the outermost scope)
+func (r *resolver) stmt(s Stmt, p Path, env, entry map[string]visible) {
+	switch s := s.(type) {
+	case Prog:
+		r.stmt(s[0], p.child(0), env, entry)
+	case Block:
+		r.stmt(s[0], p.child(0), env, entry)
+	case Seq:
+		r.stmt(s[0], p.child(0), env, entry)
+		r.stmt(s[1], p.child(1), env, entry)
+	case Decl:
+		r.exp(s.rhs, p.child(0), env)
+		ks := visibleKinds(env).kinds(s.rhs)
+		b := r.newBinding(s.lhs, p)
+		if outer, ok := entry[s.lhs]; ok && outer.kinds&ks != 0 {
+			// the value can leave the nested scope
+			b.parent = outer.b.find()
+		}
+		r.refer(p, b)
+		env[s.lhs] = visible{b, ks}
+	case Assign:
+		r.exp(s.rhs, p.child(0), env)
+		r.refer(p, r.lookup(s.lhs, env))
+	case Print:
+		r.exp(s.printExp, p.child(0), env)
+	case IfThenElse:
+		r.exp(s.cond, p.child(0), env)
+		r.stmt(s.thenBl, p.child(1), copyVisible(env), env)
+		r.stmt(s.elseBl, p.child(2), copyVisible(env), env)
+	case While:
+		r.exp(s.cond, p.child(0), env)
+		r.stmt(s.do, p.child(1), copyVisible(env), env)
+	}
+}
+
+// returns the line of the statement containing the node at a path
+func lineOf(lines map[string]int, p Path) int {
+	for i := len(p); i >= 0; i-- {
+		if l, ok := lines[p[:i].String()]; ok {
+			return l
+		}
+	}
+	return 0
+}
+
+// renames the variable referred to at a position (the path of a Var, Decl or Assign) to newName
+// the renaming is refused if it would change which declaration any variable refers to,
+// i.e. if the renamed variable would capture or shadow another variable named newName, or be captured by it
+func rename(prg Prog, pos Path, newName string) (Prog, error) {
+	if !validName(newName) {
+		return prg, fmt.Errorf("rename: %q is not a valid variable name", newName)
+	}
+	info := resolveBindings(prg)
+	v := info.variableAt(pos)
+	if v == nil {
+		return prg, fmt.Errorf("rename: there is no variable at %s", pos)
+	}
+	if v.name == newName {
+		return prg, nil
+	}
+	renamed := rewritePaths(prg, func(n Node, p Path) Node {
+		if info.variableAt(p) != v {
+			return n
+		}
+		switch n := n.(type) {
+		case Var:
+			return Var(newName)
+		case Decl:
+			return Decl{newName, n.rhs}
+		case Assign:
+			return Assign{newName, n.rhs}
+		}
+		return n
+	}).(Prog)
+
+	// every reference has to refer to the same variable as before
+	after := resolveBindings(renamed)
+	lines := lineNumbers(prg)
+	oldToNew := make(map[*binding]*binding)
+	newToOld := make(map[*binding]*binding)
+	for _, p := range info.paths {
+		o, n := info.variableAt(p), after.variableAt(p)
+		if oldToNew[o] == nil && newToOld[n] == nil {
+			oldToNew[o], newToOld[n] = n, o
+			continue
+		}
+		if oldToNew[o] == n && newToOld[n] == o {
+			continue
+		}
+		if o == v {
+			return prg, fmt.Errorf("rename: %s at line %d would be shadowed by another variable named %s", v.name, lineOf(lines, p), newName)
+		}
+		return prg, fmt.Errorf("rename: %s at line %d, which refers to %s, would be captured by the renamed variable", o.name, lineOf(lines, p), describeBinding(o, lines))
+	}
+	return renamed, nil
+}
+
+// returns a description of a variable for error messages
+func describeBinding(b *binding, lines map[string]int) string {
+	if b.decl == nil {
+		return "the undeclared variable " + b.name
+	}
+	return fmt.Sprintf("the variable %s declared at line %d", b.name, lineOf(lines, b.decl))
+}
+
+// returns whether a string is a valid variable name: a letter or underscore, followed by letters, digits or underscores
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// finds the position of the first reference to a variable on a line of the pretty printed program
+func findReference(prg Prog, line int, name string) (Path, error) {
+	info := resolveBindings(prg)
+	lines := lineNumbers(prg)
+	for _, p := range info.paths {
+		if lineOf(lines, p) == line && info.refs[p.String()].name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("there is no reference to %s at line %d", name, line)
+}
+
+// returns a listing of all variables: where they are declared and referenced
+func showBindings(prg Prog) string {
+	info := resolveBindings(prg)
+	lines := lineNumbers(prg)
+	var b strings.Builder
+	for _, bnd := range info.bindings {
+		if bnd.find() != bnd {
+			continue
+		}
+		var decls, refs []string
+		for _, p := range info.references(bnd) {
+			if n, _ := nodeAt(prg, p); n != nil {
+				if _, ok := n.(Decl); ok {
+					decls = append(decls, fmt.Sprintf("%d", lineOf(lines, p)))
+					continue
+				}
+			}
+			refs = append(refs, fmt.Sprintf("%d", lineOf(lines, p)))
+		}
+		desc := "undeclared"
+		if len(decls) > 0 {
+			desc = "declared at line " + strings.Join(decls, ", ")
+		}
+		if len(refs) > 0 {
+			desc += ", referenced at line " + strings.Join(refs, ", ")
+		}
+		fmt.Fprintf(&b, "%s: %s\n", bnd.name, desc)
+	}
+	return b.String()
+}
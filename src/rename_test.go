@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenameKeepsOutput(t *testing.T) {
+	for name, prg := range testPrograms() {
+		info := resolveBindings(prg)
+		for _, p := range info.paths {
+			renamed, err := rename(prg, p, "renamed")
+			if err != nil {
+				t.Errorf("%s: renaming at %s: %s", name, p, err)
+				continue
+			}
+			want, _ := evalCaptured(prg)
+			if got, _ := evalCaptured(renamed); got != want {
+				t.Errorf("%s: renaming at %s changes the output to\n%s", name, p, got)
+			}
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	// the first reference to old on line renamed to new, the renamed program or the error
+	type renaming struct {
+		line          int
+		old, new      string
+		want, wantErr string
+	}
+	tests := map[string]struct {
+		prg       Prog
+		renamings []renaming
+	}{
+		"nested scopes": {
+			generateProg([]Stmt{
+				declaration("x", number(1)),
+				ifthenelse(boolean(true), block(generateSeq([]Stmt{declaration("y", number(2)), sPrint(variable("x"))})), block(declaration("x", number(3)))),
+				sPrint(variable("x")),
+			}),
+			[]renaming{
+				// the declaration in the else block is linked to x, its value leaves the scope
+				{2, "x", "z", "{\nz := 1;\nif true{\ny := 2;\nprint z\n} else {\nz := 3\n};\nprint z\n}", ""},
+				{9, "x", "z", "{\nz := 1;\nif true{\ny := 2;\nprint z\n} else {\nz := 3\n};\nprint z\n}", ""},
+				{2, "x", "y", "", "rename: y at line 4, which refers to the variable y declared at line 4, would be captured by the renamed variable"},
+				{4, "y", "x", "", "rename: y at line 4 would be shadowed by another variable named x"},
+				{4, "y", "w", "{\nx := 1;\nif true{\nw := 2;\nprint x\n} else {\nx := 3\n};\nprint x\n}", ""},
+			},
+		},
+		"declarations of another kind": {
+			generateProg([]Stmt{
+				declaration("x", number(1)),
+				while(boolean(false), block(generateSeq([]Stmt{declaration("x", boolean(true)), sPrint(variable("x"))}))),
+				declaration("b", boolean(true)),
+				sPrint(variable("x")),
+			}),
+			[]renaming{
+				// the boolean x in the loop is another variable, its value never leaves the scope
+				{4, "x", "b", "{\nx := 1;\nwhile false{\nb := true;\nprint b\n};\nb := true;\nprint x\n}", ""},
+				{2, "x", "b", "", "rename: x at line 8 would be shadowed by another variable named b"},
+			},
+		},
+		"undeclared variables": {
+			generateProg([]Stmt{sPrint(variable("u")), declaration("v", number(1)), sPrint(plus(variable("u"), variable("v")))}),
+			[]renaming{
+				{2, "u", "w", "{\nprint w;\nv := 1;\nprint (w+v)\n}", ""},
+				{2, "u", "v", "", "rename: u at line 4 would be shadowed by another variable named v"},
+			},
+		},
+	}
+	for name, test := range tests {
+		for _, r := range test.renamings {
+			pos, err := findReference(test.prg, r.line, r.old)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			got, err := rename(test.prg, pos, r.new)
+			switch {
+			case r.wantErr != "" && (err == nil || !strings.Contains(err.Error(), r.wantErr)):
+				t.Errorf("%s: renaming %s at line %d to %s: got error %v, want %s", name, r.old, r.line, r.new, err, r.wantErr)
+			case r.wantErr == "" && err != nil:
+				t.Errorf("%s: renaming %s at line %d to %s: %s", name, r.old, r.line, r.new, err)
+			case r.wantErr == "" && got.pretty() != r.want:
+				t.Errorf("%s: renaming %s at line %d to %s gives %q", name, r.old, r.line, r.new, got.pretty())
+			}
+		}
+	}
+}
+
+func TestRenameErrors(t *testing.T) {
+	prg := generateProg([]Stmt{declaration("x", number(1)), sPrint(variable("x"))})
+	for _, newName := range []string{"", "1x", "x-y", "x y"} {
+		if _, err := rename(prg, Path{0, 0, 0}, newName); err == nil {
+			t.Errorf("the name %q was accepted", newName)
+		}
+	}
+	if _, err := rename(prg, Path{0, 0}, "y"); err == nil {
+		t.Errorf("renaming a sequence was accepted")
+	}
+}
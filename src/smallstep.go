@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// small-step operational semantics
+//
+// a configuration consists of the remaining statement and the current ValState.
+// step reduces a configuration by exactly one rule. To express the nested scopes of While and IfThenElse,
+// the remaining statement may contain the following runtime statements, which don't appear in programs:
+//
+//	Skip   nothing left to do
+//	Scope  a block running in a nested scope, remembering the state of the outer scope
+//	Loop   a while loop running in a nested scope, remembering the state of the outer scope
+//
+// states are never modified in place, so every recorded configuration keeps its state.
+
+type Skip struct{}
+type Scope struct {
+	body  Stmt
+	saved ValState
+}
+type Loop struct {
+	loop While
+	// the statement of the current iteration, nil at the loop head
+	body  Stmt
+	saved ValState
+}
+
+func (Skip) pretty() string {
+	return "skip"
+}
+func (sc Scope) pretty() string {
+	return "scope" + Block{sc.body}.pretty()
+}
+func (l Loop) pretty() string {
+	if l.body == nil {
+		return "loop " + l.loop.pretty()
+	}
+	return "iterate" + Block{l.body}.pretty() + " of while " + l.loop.cond.pretty()
+}
+
+// evaluating a runtime statement means reducing it until it is finished
+func (Skip) eval(s ValState) {}
+func (sc Scope) eval(s ValState) {
+	runStmt(sc, s)
+}
+func (l Loop) eval(s ValState) {
+	runStmt(l, s)
+}
+
+// runtime statements are type checked like the statements they stand for
+func (Skip) check(t TyState) bool {
+	return true
+}
+func (sc Scope) check(t TyState) bool {
+	return sc.body.check(t)
+}
+func (l Loop) check(t TyState) bool {
+	if l.body != nil && !l.body.check(t) {
+		return false
+	}
+	return l.loop.check(t)
+}
+
+// reduces a statement until it is finished, updating s in place
+func runStmt(stmt Stmt, s ValState) {
+	cur := s
+	for {
+		if _, done := stmt.(Skip); done {
+			break
+		}
+		stmt, cur, _ = step(stmt, cur)
+	}
+	for k := range s {
+		delete(s, k)
+	}
+	for k, v := range cur {
+		s[k] = v
+	}
+}
+
+// a configuration of the small-step semantics
+// rule is the name of the rule that led to this configuration, output what that step printed
+type config struct {
+	stmt   Stmt
+	state  ValState
+	rule   string
+	output string
+}
+
+func copyState(s ValState) ValState {
+	c := make(map[string]Val)
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// reduces a configuration by one step
+// returns the remaining statement, the new state and the name of the applied rule
+// the statement must not be Skip
+func step(stmt Stmt, s ValState) (Stmt, ValState, string) {
+	switch stmt := stmt.(type) {
+	case Prog:
+		return stmt[0], s, "Prog"
+	case Block:
+		return stmt[0], s, "Block"
+	case Seq:
+		if _, done := stmt[0].(Skip); done {
+			return stmt[1], s, "Seq-Skip"
+		}
+		first, s2, rule := step(stmt[0], s)
+		return Seq{first, stmt[1]}, s2, rule
+	case Decl:
+		s2 := copyState(s)
+		stmt.eval(s2)
+		return Skip{}, s2, "Decl"
+	case Assign:
+		s2 := copyState(s)
+		stmt.eval(s2)
+		return Skip{}, s2, "Assign"
+	case Print:
+		stmt.eval(s)
+		return Skip{}, s, "Print"
+	case IfThenElse:
+		v := stmt.cond.eval(s)
+		switch {
+		case v.flag == ValueBool && v.valB:
+			return Scope{stmt.thenBl, s}, copyState(s), "If-True"
+		case v.flag == ValueBool:
+			return Scope{stmt.elseBl, s}, copyState(s), "If-False"
+		}
+		fmt.Fprint(out, condFailure(stmt))
+		return Skip{}, s, "If-Fail"
+	case Scope:
+		if _, done := stmt.body.(Skip); done {
+			// leaving the scope updates the outer state
+			return Skip{}, stmt.saved.update(s), "Scope-Exit"
+		}
+		body, s2, rule := step(stmt.body, s)
+		return Scope{body, stmt.saved}, s2, rule
+	case While:
+		return Loop{stmt, nil, s}, copyState(s), "While-Enter"
+	case Loop:
+		if stmt.body == nil {
+			v := stmt.loop.cond.eval(s)
+			switch {
+			case v.flag == ValueBool && v.valB:
+				return Loop{stmt.loop, stmt.loop.do, stmt.saved}, s, "While-True"
+			case v.flag == ValueBool:
+				return Skip{}, stmt.saved.update(s), "While-False"
+			}
+			// the state of the nested scope is thrown away
+			fmt.Fprint(out, condFailure(stmt.loop))
+			return Skip{}, stmt.saved, "While-Fail"
+		}
+		if _, done := stmt.body.(Skip); done {
+			// after each iteration, the state is reset to the outer state, updated with the nested scope
+			return Loop{stmt.loop, nil, stmt.saved}, stmt.saved.update(s), "While-Reset"
+		}
+		body, s2, rule := step(stmt.body, s)
+		return Loop{stmt.loop, body, stmt.saved}, s2, rule
+	}
+	panic(fmt.Sprintf("step: cannot reduce %T", stmt))
+}
+
+// reduces a program step by step, starting with an empty state, and records all configurations
+// stops after max steps (if max > 0); returns the configurations and whether the program finished
+func smallStepTrace(prg Prog, max int) ([]config, bool) {
+	var stmt Stmt = prg
+	s := make(map[string]Val)
+	configs := []config{{stmt: stmt, state: s}}
+	for n := 0; max <= 0 || n < max; n++ {
+		if _, done := stmt.(Skip); done {
+			return configs, true
+		}
+		var rule string
+		output := captureOutput(func() {
+			stmt, s, rule = step(stmt, s)
+		})
+		fmt.Fprint(out, output)
+		configs = append(configs, config{stmt, s, rule, output})
+	}
+	_, done := stmt.(Skip)
+	return configs, done
+}
+
+// checks that the small-step semantics agrees with Prog.eval on the final state and the output
+// returns a description of the first difference, or an empty string
+func checkSmallStep(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	var configs []config
+	gotOut := captureOutput(func() { configs, _ = smallStepTrace(prg, 0) })
+	gotState := configs[len(configs)-1].state
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- small-step\n%s", wantOut, gotOut)
+	}
+	if !sameState(gotState, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, small-step %s", showState(wantState), showState(gotState))
+	}
+	return ""
+}
+
+// returns the statement that the next step reduces, as one line
+func focus(stmt Stmt) string {
+	switch stmt := stmt.(type) {
+	case Seq:
+		if _, done := stmt[0].(Skip); done {
+			return "skip; ..."
+		}
+		return focus(stmt[0])
+	case Scope:
+		if _, done := stmt.body.(Skip); done {
+			return "end of scope"
+		}
+		return focus(stmt.body)
+	case Loop:
+		if stmt.body == nil {
+			return "while " + stmt.loop.cond.pretty()
+		}
+		if _, done := stmt.body.(Skip); done {
+			return "end of iteration"
+		}
+		return focus(stmt.body)
+	}
+	return headline(stmt)
+}
+
+// returns the recorded configurations as text
+// with full, each configuration shows the whole remaining statement, otherwise only the part reduced next
+func showConfigs(configs []config, full bool) string {
+	var b strings.Builder
+	for i, c := range configs {
+		fmt.Fprintf(&b, "%d:", i)
+		if i > 0 {
+			fmt.Fprintf(&b, " --%s-->", c.rule)
+		}
+		if full {
+			fmt.Fprintf(&b, "\n%s\n   state %s\n", c.stmt.pretty(), showState(c.state))
+		} else {
+			fmt.Fprintf(&b, " next: %s | %s\n", focus(c.stmt), showState(c.state))
+		}
+		if c.output != "" {
+			fmt.Fprintf(&b, "   printed %q\n", c.output)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestSmallStepAgreesWithEval(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkSmallStep(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
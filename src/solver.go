@@ -0,0 +1,668 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// a decision procedure for conjunctions of IMP conditions
+//
+// solve decides whether conditions (boolean expressions over integer and boolean variables) can all be true, and
+// returns a model if they can. The boolean structure is turned into clauses (Tseitin encoding) and searched by
+// DPLL with unit propagation; the comparisons of integers become linear constraints (sum of c*x <= k), whose
+// conjunction is checked by Fourier-Motzkin elimination with integer tightening at every node of the search
+// (variables fixed by an equality are substituted first).
+// A model is found by back-substitution. Mult is only supported if one side is constant.
+//
+// Fourier-Motzkin only refutes with certainty: if an integer solution can't be found by back-substitution,
+// or the search takes too long, the result is unknown. Integer overflow is not taken into account.
+
+type satResult int
+
+const (
+	unsat satResult = iota
+	sat
+	unknown
+)
+
+func (r satResult) String() string {
+	return [...]string{"unsat", "sat", "unknown"}[r]
+}
+
+// the limits of the search, beyond them the result is unknown
+const (
+	maxDecisions   = 100000
+	maxConstraints = 2000
+	maxCandidates  = 8
+)
+
+// a linear term sum of coef[x]*x + c, as constraint: term <= 0
+type linTerm struct {
+	coef map[string]int
+	c    int
+}
+
+func (t linTerm) add(u linTerm, factor int) linTerm {
+	r := linTerm{coef: make(map[string]int), c: t.c + factor*u.c}
+	for x, a := range t.coef {
+		r.coef[x] = a
+	}
+	for x, a := range u.coef {
+		if r.coef[x] += factor * a; r.coef[x] == 0 {
+			delete(r.coef, x)
+		}
+	}
+	return r
+}
+
+func (t linTerm) scale(factor int) linTerm {
+	return linTerm{}.add(t, factor)
+}
+
+// returns the negation of the constraint t <= 0 over the integers: -t+1 <= 0
+func (t linTerm) negate() linTerm {
+	r := t.scale(-1)
+	r.c++
+	return r
+}
+
+func (t linTerm) vars() []string {
+	var xs []string
+	for x := range t.coef {
+		xs = append(xs, x)
+	}
+	sort.Strings(xs)
+	return xs
+}
+
+func (t linTerm) String() string {
+	var parts []string
+	for _, x := range t.vars() {
+		parts = append(parts, fmt.Sprintf("%d*%s", t.coef[x], x))
+	}
+	if t.c != 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprint(t.c))
+	}
+	return strings.Join(parts, " + ") + " <= 0"
+}
+
+func gcd(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// divides a constraint by the gcd of its coefficients, rounding the constant (sound for integers)
+// returns false if the constraint has no variables and is violated
+func (t linTerm) normalize() (linTerm, bool) {
+	g := 0
+	for _, a := range t.coef {
+		g = gcd(g, a)
+	}
+	if g == 0 {
+		return t, t.c <= 0
+	}
+	r := linTerm{coef: make(map[string]int), c: ceilDiv(t.c, g)}
+	for x, a := range t.coef {
+		r.coef[x] = a / g
+	}
+	return r, true
+}
+
+func floorDiv(a, b int) int {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+func ceilDiv(a, b int) int {
+	return -floorDiv(-a, b)
+}
+
+// returns an integer expression as linear term
+func linearize(e Exp) (linTerm, error) {
+	switch e := e.(type) {
+	case Num:
+		return linTerm{c: int(e)}, nil
+	case Var:
+		return linTerm{coef: map[string]int{string(e): 1}}, nil
+	case Group:
+		return linearize(e[0])
+	case Plus:
+		l, err := linearize(e[0])
+		if err != nil {
+			return l, err
+		}
+		r, err := linearize(e[1])
+		return l.add(r, 1), err
+	case Mult:
+		l, err := linearize(e[0])
+		if err != nil {
+			return l, err
+		}
+		r, err := linearize(e[1])
+		switch {
+		case err != nil:
+			return r, err
+		case len(l.coef) == 0:
+			return r.scale(l.c), nil
+		case len(r.coef) == 0:
+			return l.scale(r.c), nil
+		}
+	}
+	return linTerm{}, fmt.Errorf("%s isn't linear", e.pretty())
+}
+
+// returns whether an expression is an integer expression (given the kinds of the variables)
+func isIntExp(e Exp, kinds kindEnv) bool {
+	switch e := e.(type) {
+	case Num, Plus, Mult:
+		return true
+	case Var:
+		return kinds[string(e)] == kindInt
+	case Group:
+		return isIntExp(e[0], kinds)
+	}
+	return false
+}
+
+// infers the kinds of the variables of conditions from their use (e.g. operands of < are integers)
+// variables whose use doesn't tell are booleans
+func inferKinds(conds []Exp) kindEnv {
+	kinds := make(kindEnv)
+	var walk func(e Exp, isInt bool)
+	walk = func(e Exp, isInt bool) {
+		switch e := e.(type) {
+		case Var:
+			if isInt {
+				kinds[string(e)] = kindInt
+			} else if kinds[string(e)] == 0 {
+				kinds[string(e)] = kindBool
+			}
+		case Group:
+			walk(e[0], isInt)
+		case Negation:
+			walk(e[0], false)
+		case Plus, Mult, Lesser:
+			for _, c := range children(e) {
+				walk(c.(Exp), true)
+			}
+		case And, Or:
+			for _, c := range children(e) {
+				walk(c.(Exp), false)
+			}
+		}
+	}
+	// the operands of == have the same kind, which may be known from another use
+	// (variables only change from boolean to integer, so this ends)
+	for changed := true; changed; {
+		before := kinds.copy()
+		for _, c := range conds {
+			walk(c, false)
+			walkPre(c, func(n Node) bool {
+				if eq, ok := n.(Equal); ok {
+					isInt := isIntExp(eq[0], kinds) || isIntExp(eq[1], kinds)
+					walk(eq[0], isInt)
+					walk(eq[1], isInt)
+				}
+				return true
+			})
+		}
+		changed = false
+		for x, k := range kinds {
+			changed = changed || before[x] != k
+		}
+	}
+	return kinds
+}
+
+type solver struct {
+	kinds kindEnv
+	// the clauses, a literal is a variable number (from 1) or its negation
+	clauses [][]int
+	nvars   int
+	// the constraints of the variables standing for comparisons, and of boolean variables by name
+	theory map[int]linTerm
+	bools  map[string]int
+	atoms  map[string]int
+
+	decisions int
+	// whether a part of the search ended without a certain answer
+	incomplete bool
+}
+
+func (sv *solver) newVar() int {
+	sv.nvars++
+	return sv.nvars
+}
+
+// returns the variable for a constraint, the same constraint always gets the same variable
+func (sv *solver) atom(t linTerm) int {
+	key := t.String()
+	if v, ok := sv.atoms[key]; ok {
+		return v
+	}
+	v := sv.newVar()
+	sv.atoms[key] = v
+	sv.theory[v] = t
+	return v
+}
+
+// returns a new variable that is true if and only if both literals are
+func (sv *solver) and(a, b int) int {
+	v := sv.newVar()
+	sv.clauses = append(sv.clauses, []int{-v, a}, []int{-v, b}, []int{v, -a, -b})
+	return v
+}
+
+// returns a literal that is true if and only if the condition is, adding the clauses that define it
+func (sv *solver) encode(e Exp) (int, error) {
+	switch e := e.(type) {
+	case Bool:
+		v := sv.newVar()
+		if e {
+			sv.clauses = append(sv.clauses, []int{v})
+		} else {
+			sv.clauses = append(sv.clauses, []int{-v})
+		}
+		return v, nil
+	case Var:
+		if sv.kinds[string(e)] != kindBool {
+			return 0, fmt.Errorf("%s isn't a boolean", e)
+		}
+		v, ok := sv.bools[string(e)]
+		if !ok {
+			v = sv.newVar()
+			sv.bools[string(e)] = v
+		}
+		return v, nil
+	case Group:
+		return sv.encode(e[0])
+	case Negation:
+		l, err := sv.encode(e[0])
+		return -l, err
+	case And, Or:
+		cs := children(e)
+		a, err := sv.encode(cs[0].(Exp))
+		if err != nil {
+			return 0, err
+		}
+		b, err := sv.encode(cs[1].(Exp))
+		if err != nil {
+			return 0, err
+		}
+		if _, isOr := e.(Or); isOr {
+			return -sv.and(-a, -b), nil
+		}
+		return sv.and(a, b), nil
+	case Lesser:
+		// a<b is a-b+1 <= 0
+		a, err := linearize(e[0])
+		if err != nil {
+			return 0, err
+		}
+		b, err := linearize(e[1])
+		if err != nil {
+			return 0, err
+		}
+		t := a.add(b, -1)
+		t.c++
+		return sv.atom(t), nil
+	case Equal:
+		if isIntExp(e[0], sv.kinds) || isIntExp(e[1], sv.kinds) {
+			// a==b is a-b <= 0 and b-a <= 0
+			a, err := linearize(e[0])
+			if err != nil {
+				return 0, err
+			}
+			b, err := linearize(e[1])
+			if err != nil {
+				return 0, err
+			}
+			return sv.and(sv.atom(a.add(b, -1)), sv.atom(b.add(a, -1))), nil
+		}
+		a, err := sv.encode(e[0])
+		if err != nil {
+			return 0, err
+		}
+		b, err := sv.encode(e[1])
+		if err != nil {
+			return 0, err
+		}
+		v := sv.newVar()
+		sv.clauses = append(sv.clauses, []int{-v, -a, b}, []int{-v, a, -b}, []int{v, a, b}, []int{v, -a, -b})
+		return v, nil
+	}
+	return 0, fmt.Errorf("%s isn't a condition", e.pretty())
+}
+
+// decides whether the conditions can all be true, kinds are the kinds of the variables (inferred if nil)
+// returns a model (values of all variables of the conditions) if they can
+func solve(conds []Exp, kinds kindEnv) (satResult, ValState) {
+	if kinds == nil {
+		kinds = inferKinds(conds)
+	}
+	sv := &solver{kinds: kinds, theory: make(map[int]linTerm), bools: make(map[string]int), atoms: make(map[string]int)}
+	for _, c := range conds {
+		l, err := sv.encode(c)
+		if err != nil {
+			return unknown, nil
+		}
+		sv.clauses = append(sv.clauses, []int{l})
+	}
+	assign := make([]int8, sv.nvars+1)
+	res, model := sv.search(assign)
+	if res == unsat && sv.incomplete {
+		return unknown, nil
+	}
+	if res != sat {
+		return res, nil
+	}
+	// the model is checked, so a wrong answer can only be unknown
+	for _, c := range conds {
+		if c.eval(model) != mkBool(true) {
+			return unknown, nil
+		}
+	}
+	return sat, model
+}
+
+// returns whether a literal is true (1), false (-1) or unassigned (0)
+func value(assign []int8, l int) int8 {
+	if l < 0 {
+		return -assign[-l]
+	}
+	return assign[l]
+}
+
+// assigns the literals of unit clauses until none is left, returns false on a conflict
+func (sv *solver) propagate(assign []int8) bool {
+	for changed := true; changed; {
+		changed = false
+		for _, cl := range sv.clauses {
+			unassigned, n := 0, 0
+			satisfied := false
+			for _, l := range cl {
+				switch value(assign, l) {
+				case 1:
+					satisfied = true
+				case 0:
+					unassigned, n = l, n+1
+				}
+			}
+			switch {
+			case satisfied:
+			case n == 0:
+				return false
+			case n == 1:
+				if unassigned < 0 {
+					assign[-unassigned] = -1
+				} else {
+					assign[unassigned] = 1
+				}
+				changed = true
+			}
+		}
+	}
+	return true
+}
+
+// returns the constraints of the assigned comparisons
+func (sv *solver) constraints(assign []int8) []linTerm {
+	var vs []int
+	for v := range sv.theory {
+		vs = append(vs, v)
+	}
+	sort.Ints(vs)
+	var cs []linTerm
+	for _, v := range vs {
+		switch assign[v] {
+		case 1:
+			cs = append(cs, sv.theory[v])
+		case -1:
+			cs = append(cs, sv.theory[v].negate())
+		}
+	}
+	return cs
+}
+
+// searches an assignment satisfying the clauses and the constraints (DPLL)
+func (sv *solver) search(assign []int8) (satResult, ValState) {
+	if sv.decisions++; sv.decisions > maxDecisions {
+		sv.incomplete = true
+		return unsat, nil
+	}
+	assign = append([]int8{}, assign...)
+	if !sv.propagate(assign) {
+		return unsat, nil
+	}
+	stages, order, res := eliminate(sv.constraints(assign))
+	switch res {
+	case unsat:
+		return unsat, nil
+	case unknown:
+		sv.incomplete = true
+		return unsat, nil
+	}
+	for v := 1; v <= sv.nvars; v++ {
+		if assign[v] == 0 {
+			for _, b := range []int8{1, -1} {
+				assign[v] = b
+				if res, model := sv.search(assign); res == sat {
+					return res, model
+				}
+			}
+			return unsat, nil
+		}
+	}
+	ints, ok := backSubstitute(stages, order)
+	if !ok {
+		sv.incomplete = true
+		return unsat, nil
+	}
+	model := make(map[string]Val)
+	for x, k := range sv.kinds {
+		if k == kindInt {
+			model[x] = mkInt(ints[x])
+		} else {
+			model[x] = mkBool(sv.bools[x] != 0 && assign[sv.bools[x]] == 1)
+		}
+	}
+	return sat, model
+}
+
+// eliminates the variables of constraints by Fourier-Motzkin, stages[i] are the constraints before order[i]
+// is eliminated; returns unsat if a contradiction is derived, unknown if there are too many constraints
+func eliminate(cs []linTerm) ([][]linTerm, []string, satResult) {
+	current, ok := normalizeAll(cs)
+	if !ok {
+		return nil, nil, unsat
+	}
+	var stages [][]linTerm
+	var order []string
+	for {
+		// a variable with coefficient 1 or -1 in an equality (t <= 0 and -t <= 0) is substituted, which adds
+		// no constraints; the stage keeps both sides of the equality, which fix its value in back-substitution
+		if x, eq, ok := unitEquality(current); ok {
+			stages = append(stages, current)
+			order = append(order, x)
+			var next []linTerm
+			for _, t := range current {
+				next = append(next, t.add(eq, -t.coef[x]*eq.coef[x]))
+			}
+			if current, ok = normalizeAll(next); !ok {
+				return nil, nil, unsat
+			}
+			continue
+		}
+		// otherwise the variable with the fewest new constraints is eliminated next
+		best, bestCost := "", -1
+		counts := make(map[string][2]int)
+		for _, t := range current {
+			for x, a := range t.coef {
+				n := counts[x]
+				if a > 0 {
+					n[0]++
+				} else {
+					n[1]++
+				}
+				counts[x] = n
+			}
+		}
+		var xs []string
+		for x := range counts {
+			xs = append(xs, x)
+		}
+		sort.Strings(xs)
+		for _, x := range xs {
+			if cost := counts[x][0] * counts[x][1]; bestCost < 0 || cost < bestCost {
+				best, bestCost = x, cost
+			}
+		}
+		if best == "" {
+			return stages, order, sat
+		}
+		stages = append(stages, current)
+		order = append(order, best)
+		var next, upper, lower []linTerm
+		for _, t := range current {
+			switch a := t.coef[best]; {
+			case a > 0:
+				upper = append(upper, t)
+			case a < 0:
+				lower = append(lower, t)
+			default:
+				next = append(next, t)
+			}
+		}
+		for _, u := range upper {
+			for _, l := range lower {
+				// a*x + ... <= 0 and -b*x + ... <= 0 give b*(...) + a*(...) <= 0
+				a, b := u.coef[best], -l.coef[best]
+				next = append(next, u.scale(b).add(l, a))
+			}
+		}
+		if len(next) > maxConstraints {
+			return nil, nil, unknown
+		}
+		if current, ok = normalizeAll(next); !ok {
+			return nil, nil, unsat
+		}
+	}
+}
+
+// returns a variable with coefficient 1 or -1 in a constraint t whose opposite -t is also a constraint
+func unitEquality(cs []linTerm) (string, linTerm, bool) {
+	seen := make(map[string]bool)
+	for _, t := range cs {
+		seen[t.String()] = true
+	}
+	for _, t := range cs {
+		if !seen[t.scale(-1).String()] {
+			continue
+		}
+		for _, x := range t.vars() {
+			if a := t.coef[x]; a == 1 || a == -1 {
+				return x, t, true
+			}
+		}
+	}
+	return "", linTerm{}, false
+}
+
+// normalizes constraints and removes duplicates, returns false if one is violated without variables
+func normalizeAll(cs []linTerm) ([]linTerm, bool) {
+	var r []linTerm
+	seen := make(map[string]bool)
+	for _, t := range cs {
+		n, ok := t.normalize()
+		if !ok {
+			return nil, false
+		}
+		if len(n.coef) == 0 || seen[n.String()] {
+			continue
+		}
+		seen[n.String()] = true
+		r = append(r, n)
+	}
+	return r, true
+}
+
+// finds integer values of the eliminated variables, from the last one eliminated to the first one
+// every variable gets the value closest to 0 within its bounds, other values are tried if later ones have none
+func backSubstitute(stages [][]linTerm, order []string) (map[string]int, bool) {
+	vals := make(map[string]int)
+	budget := maxDecisions
+	var assign func(i int) bool
+	assign = func(i int) bool {
+		if i < 0 {
+			return true
+		}
+		if budget--; budget < 0 {
+			return false
+		}
+		x := order[i]
+		lo, hi := negInf, posInf
+		for _, t := range stages[i] {
+			a := t.coef[x]
+			if a == 0 {
+				continue
+			}
+			rest := t.c
+			for y, b := range t.coef {
+				if y != x {
+					rest += b * vals[y]
+				}
+			}
+			// a*x + rest <= 0
+			if a > 0 {
+				hi = minInt(hi, floorDiv(-rest, a))
+			} else {
+				lo = maxInt(lo, ceilDiv(rest, -a))
+			}
+		}
+		for _, v := range candidates(lo, hi) {
+			vals[x] = v
+			if assign(i - 1) {
+				return true
+			}
+		}
+		delete(vals, x)
+		return false
+	}
+	return vals, assign(len(order) - 1)
+}
+
+// returns up to maxCandidates values between lo and hi, starting with the one closest to 0
+func candidates(lo, hi int) []int {
+	if lo > hi {
+		return nil
+	}
+	start := 0
+	switch {
+	case lo > 0:
+		start = lo
+	case hi < 0:
+		start = hi
+	}
+	vs := []int{start}
+	for d := 1; len(vs) < maxCandidates && (start-d >= lo || start+d <= hi); d++ {
+		if start+d <= hi {
+			vs = append(vs, start+d)
+		}
+		if start-d >= lo {
+			vs = append(vs, start-d)
+		}
+	}
+	return vs
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func parseConds(t *testing.T, srcs []string) []Exp {
+	var conds []Exp
+	for _, src := range srcs {
+		e, err := parseExp(src)
+		if err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+		conds = append(conds, e)
+	}
+	return conds
+}
+
+func TestSolveResults(t *testing.T) {
+	tests := []struct {
+		conds []string
+		want  satResult
+	}{
+		{[]string{"0<x", "x<3"}, sat},
+		{[]string{"x<y", "y<z", "z<x"}, unsat},
+		// Fourier-Motzkin alone finds x = 1/2, tightening 2*x <= 1 to x <= 0 refutes it
+		{[]string{"0<(x+x)", "(x+x)<2"}, unsat},
+		{[]string{"(x+x)==((y+y)+1)"}, unsat},
+		{[]string{"x<y", "y<(x+1)"}, unsat},
+		// the equalities are substituted
+		{[]string{"x==(y+1)", "y==(z+1)", "z==(x+1)"}, unsat},
+		{[]string{"(x+y)==5", "y==(x+1)"}, sat},
+		// Mult by a constant is linear
+		{[]string{"(3*x)==6"}, sat},
+		{[]string{"(x*3)==7"}, unsat},
+		{[]string{"(2*(x+1))<x", "0<x"}, unsat},
+		{[]string{"(x*y)<1"}, unknown},
+		// booleans
+		{[]string{"b && !c"}, sat},
+		{[]string{"b || c", "!b", "!c"}, unsat},
+		{[]string{"(b==c) && b && !c"}, unsat},
+		{[]string{"b==(x<1)", "!b", "x<5"}, sat},
+		{[]string{"b==(x<1)", "b", "!(x<0)", "!(x==0)"}, unsat},
+		{[]string{"(x<1)==(1<x)", "!(x==1)"}, unsat},
+		{[]string{"true"}, sat},
+		{[]string{"false"}, unsat},
+		// x is used as an integer and a boolean
+		{[]string{"x && (x<1)"}, unknown},
+	}
+	for _, test := range tests {
+		if got, _ := solve(parseConds(t, test.conds), nil); got != test.want {
+			t.Errorf("%s: %s instead of %s", strings.Join(test.conds, ", "), got, test.want)
+		}
+	}
+}
+
+// the model gives every variable a value, the one closest to 0 if the conditions allow several
+func TestSolveModels(t *testing.T) {
+	tests := []struct {
+		conds []string
+		want  string
+	}{
+		{[]string{"0<x", "x<3"}, "{x: 1}"},
+		{[]string{"x<(0+-4)"}, "{x: -5}"},
+		{[]string{"x==(y+1)", "y==3"}, "{x: 4, y: 3}"},
+		{[]string{"(x+y)==5", "y==(x+1)"}, "{x: 2, y: 3}"},
+		{[]string{"(3*x)==6"}, "{x: 2}"},
+		{[]string{"(x*-2)==6"}, "{x: -3}"},
+		{[]string{"b && !c"}, "{b: true, c: false}"},
+		{[]string{"b || c", "!b"}, "{b: false, c: true}"},
+		{[]string{"b==(x<1)", "!b", "x<5"}, "{b: false, x: 1}"},
+		{[]string{"b==c", "c"}, "{b: true, c: true}"},
+	}
+	for _, test := range tests {
+		conds := parseConds(t, test.conds)
+		res, model := solve(conds, nil)
+		if res != sat {
+			t.Errorf("%s: %s instead of sat", strings.Join(test.conds, ", "), res)
+			continue
+		}
+		if got := showState(model); got != test.want {
+			t.Errorf("%s: model %s instead of %s", strings.Join(test.conds, ", "), got, test.want)
+		}
+		for _, c := range conds {
+			if c.eval(model) != mkBool(true) {
+				t.Errorf("%s: %s is false in the model", strings.Join(test.conds, ", "), c.pretty())
+			}
+		}
+	}
+}
+
+// the kinds given to solve decide which variables are integers
+func TestSolveKinds(t *testing.T) {
+	conds := parseConds(t, []string{"x==y"})
+	if _, model := solve(conds, kindEnv{"x": kindInt, "y": kindInt}); showState(model) != "{x: 0, y: 0}" {
+		t.Errorf("integers: %s", showState(model))
+	}
+	if _, model := solve(conds, nil); showState(model) != "{x: true, y: true}" {
+		t.Errorf("booleans: %s", showState(model))
+	}
+}
+
+// dividing by the gcd of the coefficients rounds the constant towards the integer solutions
+func TestNormalizeTightens(t *testing.T) {
+	tests := []struct {
+		t    linTerm
+		want string
+	}{
+		{linTerm{coef: map[string]int{"x": 2}, c: -1}, "1*x <= 0"},
+		{linTerm{coef: map[string]int{"x": -2}, c: 1}, "-1*x + 1 <= 0"},
+		{linTerm{coef: map[string]int{"x": 4, "y": -6}, c: 3}, "2*x + -3*y + 2 <= 0"},
+		{linTerm{coef: map[string]int{"x": 3}, c: -3}, "1*x + -1 <= 0"},
+	}
+	for _, test := range tests {
+		if got, ok := test.t.normalize(); !ok || got.String() != test.want {
+			t.Errorf("%s: %s instead of %s", test.t, got, test.want)
+		}
+	}
+	if _, ok := (linTerm{c: 1}).normalize(); ok {
+		t.Errorf("1 <= 0 isn't violated")
+	}
+}
@@ -1,6 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// the output of print statements and evaluation failures is written to out
+// (it can be replaced, e.g. to capture the output of a program)
+var out io.Writer = os.Stdout
 
 // statement interface
 type Stmt interface {
@@ -61,18 +69,15 @@ func (p Print) pretty() string {
 }
 
 // methods to evaluate statements
+// (the nested statements of programs, blocks, sequences, loops and if-then-else are evaluated by exec)
 func (prg Prog) eval(s ValState) {
-	// evaluating a program means evaluating it's main block
-	prg[0].eval(s)
+	noHooks.exec(prg, Path{}, s)
 }
 func (blck Block) eval(s ValState) {
-	// evaluating a block means evaluating it's statement
-	blck[0].eval(s)
+	noHooks.exec(blck, Path{}, s)
 }
 func (stmt Seq) eval(s ValState) {
-	// evaluating a sequence means evaluating each statement, one after one
-	stmt[0].eval(s)
-	stmt[1].eval(s)
+	noHooks.exec(stmt, Path{}, s)
 }
 func (decl Decl) eval(s ValState) {
 	// declaring overwrites already existing variables, no matter what type
@@ -88,88 +93,243 @@ func (asgn Assign) eval(s ValState) {
 	if exists && (oldVal.flag == v.flag) {
 		s[x] = v
 	} else {
-		fmt.Printf("assign eval fail")
+		fmt.Fprintf(out, "assign eval fail")
 	}
 }
 func (while While) eval(s1 ValState) {
-	// create a new temporary state is needed for the nested scope
-	s2 := make(map[string]Val)
-	for k, v := range s1 {
-		s2[k] = v
+	noHooks.exec(while, Path{}, s1)
+}
+func (ite IfThenElse) eval(s1 ValState) {
+	noHooks.exec(ite, Path{}, s1)
+}
+func (p Print) eval(s ValState) {
+	// evaluating a print means to just print the evaluation result...
+	v := p.printExp.eval(s)
+	fmt.Fprintf(out, "%s\n", showVal(v))
+}
+
+// evaluation with hooks
+//
+// exec evaluates statements like eval and calls hooks at the points of the evaluation, so tools observing a
+// program (like the derivations of derivation.go) share the semantics of the nested scopes instead of repeating
+// them. Every hook is optional, eval is exec without hooks.
+
+type evalHooks struct {
+	// before a statement is executed in s (for an if statement before its condition is evaluated);
+	// returning false stops the evaluation
+	stmt func(stmt Stmt, p Path, s ValState) bool
+	// before an expression of a statement is evaluated in s, p is the path of the expression
+	exp func(e Exp, p Path, s ValState)
+	// after the nested scope of an if or while statement was entered, s1 is the state of the enclosing scope
+	// and s2 the state of the nested one
+	enter func(owner Stmt, p Path, s1, s2 ValState)
+	// before each evaluation of the condition of a while loop, in the state s2 of the nested scope;
+	// returning false stops the evaluation
+	iteration func(while While, p Path, s2 ValState) bool
+	// after the condition of an if or while statement was evaluated in s, output is the message printed if
+	// its value isn't a boolean
+	cond func(owner Stmt, p Path, s ValState, v Val, output string)
+	// before the state s1 of the enclosing scope is updated with the state s2 of the nested scope, at the end of
+	// each iteration of a while loop and when the nested scope is left
+	update func(owner Stmt, p Path, s1, s2 ValState)
+	// after an iteration of a while loop, s2 is the state the nested scope continues with
+	reset func(while While, p Path, s2 ValState)
+	// when the nested scope is left, before the state s1 of the enclosing scope is replaced by s3
+	// s3 is nil if the nested scope is thrown away (the condition of a while loop failed)
+	exit func(owner Stmt, p Path, s1, s3 ValState)
+	// after a statement was executed (unless the evaluation was stopped), output is what it printed itself:
+	// for an if or while statement the message printed if its condition failed, not the output of its blocks
+	done func(stmt Stmt, p Path, s ValState, output string)
+}
+
+// the hooks of eval
+var noHooks = &evalHooks{}
+
+// the message printed if the condition of an if or while statement isn't a boolean
+func condFailure(owner Stmt) string {
+	if _, ok := owner.(While); ok {
+		return "while eval fail"
 	}
+	return "if-then-else eval fail"
+}
 
-	for {
-		v := while.cond.eval(s2)
-		if v.flag == ValueBool {
-			if v.valB == true {
-				// if the while condition is true, evaluate the do block (with the temp state)
-				while.do.eval(s2)
-				// after evaluating the do block, update state --> this state will "leak"!
-				s2 = s1.update(s2)
-			} else {
-				// if the while condition is false, "break" the while loop
-				// now, update the original state, based on the temp state
-				s3 := s1.update(s2)
-				for k := range s1 {
-					s1[k] = s3[k]
-				}
-				break
+// evaluates the statement at path p like eval (updating s in place) and calls the hooks
+// returns false if a hook stopped the evaluation
+func (h *evalHooks) exec(stmt Stmt, p Path, s ValState) bool {
+	if h.stmt != nil && !h.stmt(stmt, p, s) {
+		return false
+	}
+	output := ""
+	switch stmt := stmt.(type) {
+	case Prog:
+		// evaluating a program means evaluating it's main block
+		if !h.exec(stmt[0], p.child(0), s) {
+			return false
+		}
+	case Block:
+		// evaluating a block means evaluating it's statement
+		if !h.exec(stmt[0], p.child(0), s) {
+			return false
+		}
+	case Seq:
+		// evaluating a sequence means evaluating each statement, one after one
+		if !h.exec(stmt[0], p.child(0), s) || !h.exec(stmt[1], p.child(1), s) {
+			return false
+		}
+	case Decl:
+		output = h.run(stmt, stmt.rhs, p, s)
+	case Assign:
+		output = h.run(stmt, stmt.rhs, p, s)
+	case Print:
+		output = h.run(stmt, stmt.printExp, p, s)
+	case While:
+		var ok bool
+		if output, ok = h.loop(stmt, p, s); !ok {
+			return false
+		}
+	case IfThenElse:
+		// create a new temporary state is needed for the nested scope
+		s2 := copyState(s)
+
+		// evaluate the condition and then evaluate the block according to the result, or show an error if it failed
+		v := h.condition(stmt, p, s)
+		if v.flag != ValueBool {
+			output = condFailure(stmt)
+		}
+		if h.enter != nil {
+			h.enter(stmt, p, s, s2)
+		}
+		switch {
+		case v.flag == ValueBool && v.valB:
+			// evaluate the then block with the temp state
+			if !h.exec(stmt.thenBl, p.child(1), s2) {
+				return false
+			}
+		case v.flag == ValueBool:
+			// evaluate the else block with the temp state
+			if !h.exec(stmt.elseBl, p.child(2), s2) {
+				return false
 			}
-		} else {
-			fmt.Printf("while eval fail")
-			break
 		}
+
+		// after evaluatin the if-then-else, update the original state based on the temp state
+		h.leave(stmt, p, s, s2)
+	default:
+		// runtime statements of the small-step semantics
+		stmt.eval(s)
+	}
+	if h.done != nil {
+		h.done(stmt, p, s, output)
 	}
+	return true
 }
-func (ite IfThenElse) eval(s1 ValState) {
+
+// runs a declaration, assignment or print statement whose expression is e
+// returns what it printed (only if the done hook needs it)
+func (h *evalHooks) run(stmt Stmt, e Exp, p Path, s ValState) string {
+	if h.exp != nil {
+		h.exp(e, p.child(0), s)
+	}
+	if h.done == nil {
+		stmt.eval(s)
+		return ""
+	}
+	output := captureOutput(func() { stmt.eval(s) })
+	fmt.Fprint(out, output)
+	return output
+}
+
+// evaluates the condition of an if or while statement, printing the failure if it isn't a boolean
+func (h *evalHooks) condition(owner Stmt, p Path, s ValState) Val {
+	var cond Exp
+	switch owner := owner.(type) {
+	case While:
+		cond = owner.cond
+	case IfThenElse:
+		cond = owner.cond
+	}
+	if h.exp != nil {
+		h.exp(cond, p.child(0), s)
+	}
+	v := cond.eval(s)
+	output := ""
+	if v.flag != ValueBool {
+		output = condFailure(owner)
+		fmt.Fprint(out, output)
+	}
+	if h.cond != nil {
+		h.cond(owner, p, s, v, output)
+	}
+	return v
+}
+
+// runs the iterations of a while loop, s1 is the state of the enclosing scope
+// returns what the loop printed itself and false if a hook stopped the evaluation
+func (h *evalHooks) loop(while While, p Path, s1 ValState) (string, bool) {
 	// create a new temporary state is needed for the nested scope
-	s2 := make(map[string]Val)
-	for k, v := range s1 {
-		s2[k] = v
+	s2 := copyState(s1)
+	if h.enter != nil {
+		h.enter(while, p, s1, s2)
 	}
 
-	// evaluate the condition and then evaluate the block according to the result, or show an error if it failed
-	v := ite.cond.eval(s1)
-	if v.flag == ValueBool {
-		switch {
-		case v.valB:
-			// evaluate the then block with the temp state
-			ite.thenBl.eval(s2)
-		case !v.valB:
-			// evaluate the else block with the temp state
-			ite.elseBl.eval(s2)
+	for {
+		if h.iteration != nil && !h.iteration(while, p, s2) {
+			return "", false
+		}
+		v := h.condition(while, p, s2)
+		if v.flag != ValueBool {
+			// the temp state is thrown away
+			if h.exit != nil {
+				h.exit(while, p, s1, nil)
+			}
+			return condFailure(while), true
+		}
+		if !v.valB {
+			// if the while condition is false, "break" the while loop
+			// now, update the original state, based on the temp state
+			h.leave(while, p, s1, s2)
+			return "", true
+		}
+		// if the while condition is true, evaluate the do block (with the temp state)
+		if !h.exec(while.do, p.child(1), s2) {
+			return "", false
+		}
+		// after evaluating the do block, update state --> this state will "leak"!
+		if h.update != nil {
+			h.update(while, p, s1, s2)
+		}
+		s2 = s1.update(s2)
+		if h.reset != nil {
+			h.reset(while, p, s2)
 		}
-	} else {
-		fmt.Printf("if-then-else eval fail")
 	}
+}
 
-	// after evaluatin the if-then-else, update the original state based on the temp state
+// leaves the nested scope of an if or while statement: the state s1 of the enclosing scope is updated in place
+// with the state s2 of the nested scope
+func (h *evalHooks) leave(owner Stmt, p Path, s1, s2 ValState) {
+	if h.update != nil {
+		h.update(owner, p, s1, s2)
+	}
 	s3 := s1.update(s2)
+	if h.exit != nil {
+		h.exit(owner, p, s1, s3)
+	}
 	for k := range s1 {
 		s1[k] = s3[k]
 	}
 }
-func (p Print) eval(s ValState) {
-	// evaluating a print means to just print the evaluation result...
-	v := p.printExp.eval(s)
-	fmt.Printf("%s\n", showVal(v))
-}
 
 // methods to type-check statements
+// (the nested statements of programs, blocks, sequences, loops and if-then-else are checked by checkHooks.check)
 func (prg Prog) check(t TyState) bool {
-	// type checking a block means checking its "main" block
-	return prg[0].check(t)
+	return noCheckHooks.check(prg, t)
 }
 func (blck Block) check(t TyState) bool {
-	// type checking a block means checking its inner statement
-	return blck[0].check(t)
+	return noCheckHooks.check(blck, t)
 }
 func (stmt Seq) check(t TyState) bool {
-	// both statements of a sequence have to successfully type check
-	if !stmt[0].check(t) {
-		return false
-	}
-	return stmt[1].check(t)
+	return noCheckHooks.check(stmt, t)
 }
 func (decl Decl) check(t TyState) bool {
 	// the right-hand-side has to be a correctly typed expression
@@ -189,28 +349,10 @@ func (a Assign) check(t TyState) bool {
 	return t[x] == a.rhs.infer(t)
 }
 func (while While) check(t TyState) bool {
-	// both, condition and do block of the loop, have to successfully type check
-	if while.cond.infer(t) != TyBool {
-		// condition, then- and else-block all have to successfully type check
-		return false
-	} else if !while.do.check(t) {
-		return false
-	} else {
-		return true
-	}
+	return noCheckHooks.check(while, t)
 }
 func (ite IfThenElse) check(t TyState) bool {
-	// condition, then- and else-block all have to successfully type check
-	if ite.cond.infer(t) != TyBool {
-		// the condition's type always has to be bool
-		return false
-	} else if !ite.thenBl.check(t) {
-		return false
-	} else if !ite.elseBl.check(t) {
-		return false
-	} else {
-		return true
-	}
+	return noCheckHooks.check(ite, t)
 }
 func (p Print) check(t TyState) bool {
 	// the expression to print has to be correctly typed
@@ -221,6 +363,79 @@ func (p Print) check(t TyState) bool {
 	}
 }
 
+// type checking with hooks, like evaluation with hooks (see evalHooks), every hook is optional
+type checkHooks struct {
+	// before a statement is checked in t
+	stmt func(stmt Stmt, t TyState)
+	// before the type of an expression of a statement is inferred in t
+	exp func(e Exp, t TyState)
+	// after a statement was checked, with the result
+	done func(stmt Stmt, t TyState, ok bool)
+}
+
+// the hooks of check
+var noCheckHooks = &checkHooks{}
+
+// type checks a statement like check (updating t in place) and calls the hooks
+func (h *checkHooks) check(stmt Stmt, t TyState) bool {
+	if h.stmt != nil {
+		h.stmt(stmt, t)
+	}
+	ok := h.checkNested(stmt, t)
+	if h.done != nil {
+		h.done(stmt, t, ok)
+	}
+	return ok
+}
+
+func (h *checkHooks) checkNested(stmt Stmt, t TyState) bool {
+	switch stmt := stmt.(type) {
+	case Prog:
+		// type checking a block means checking its "main" block
+		return h.check(stmt[0], t)
+	case Block:
+		// type checking a block means checking its inner statement
+		return h.check(stmt[0], t)
+	case Seq:
+		// both statements of a sequence have to successfully type check
+		if !h.check(stmt[0], t) {
+			return false
+		}
+		return h.check(stmt[1], t)
+	case Decl:
+		h.infers(stmt.rhs, t)
+	case Assign:
+		h.infers(stmt.rhs, t)
+	case Print:
+		h.infers(stmt.printExp, t)
+	case While:
+		// both, condition and do block of the loop, have to successfully type check
+		if h.infers(stmt.cond, t) != TyBool {
+			return false
+		}
+		return h.check(stmt.do, t)
+	case IfThenElse:
+		// condition, then- and else-block all have to successfully type check
+		if h.infers(stmt.cond, t) != TyBool {
+			// the condition's type always has to be bool
+			return false
+		} else if !h.check(stmt.thenBl, t) {
+			return false
+		}
+		return h.check(stmt.elseBl, t)
+	}
+	// declarations, assignments, prints and the runtime statements of the small-step semantics
+	return stmt.check(t)
+}
+
+// infers the type of an expression of a statement
+func (h *checkHooks) infers(e Exp, t TyState) Type {
+	if h.exp != nil {
+		h.exp(e, t)
+	}
+	return e.infer(t)
+}
+
 // helper method to update the value state environment
 // this is necessary to support nested scopes and prevent unwanted leaking
 // returns a value state, which is the updated version of a ValState s1, updated with values from a ValState s2
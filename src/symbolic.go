@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// symbolic execution
+//
+// the inputs of a program are the declarations of variables with a literal (like x := 5): when the program is
+// executed symbolically, such a declaration gives its variable a symbol instead of the literal, and the values of
+// expressions become expressions over the symbols. On a path, the kind of every value is known (see optimize.go),
+// so only conditions that can be true or false fork the path: the path condition collects the conditions taken.
+// && and || fork as well, if their left side decides whether the result is undefined.
+// Loops are unrolled up to a bound; paths that would need more iterations are cut off. The number of paths is
+// limited as well: once it is reached, a path that would fork at an if or another iteration of a loop is cut off
+// there instead of going on (the path on which the condition is false goes on), so that programs with many branches
+// and loops don't run out of memory.
+
+// an input of a program: a declaration with a literal
+type symInput struct {
+	// the symbol standing for the literal, the name of the variable (followed by the line if it isn't unique)
+	name     string
+	variable string
+	path     Path
+	line     int
+	lit      Exp
+}
+
+// a symbolic value: its kind and, unless it is undefined, an expression over the symbols of the inputs
+type symVal struct {
+	kind Kind
+	exp  Exp
+}
+
+// something printed on a path: a symbolic value, or a message like "assign eval fail"
+type symOutput struct {
+	val symVal
+	msg string
+}
+
+type symPath struct {
+	// the conditions taken on the path (their conjunction is the path condition)
+	cond []Exp
+	out  []symOutput
+	vals map[string]symVal
+	// the line of the loop whose bound cut off the path (or of the statement where the path limit did), 0 if the
+	// path is complete
+	cut     int
+	limited bool
+}
+
+// returns a copy of a path, which can be changed independently
+func (pt *symPath) fork() *symPath {
+	f := &symPath{cut: pt.cut, limited: pt.limited, vals: make(map[string]symVal)}
+	f.cond = append(f.cond, pt.cond...)
+	f.out = append(f.out, pt.out...)
+	for k, v := range pt.vals {
+		f.vals[k] = v
+	}
+	return f
+}
+
+func (pt *symPath) get(x string) symVal {
+	if v, ok := pt.vals[x]; ok {
+		return v
+	}
+	return symVal{kind: Undefined}
+}
+
+// returns the path condition as an expression
+func (pt *symPath) condition() Exp {
+	if len(pt.cond) == 0 {
+		return Bool(true)
+	}
+	c := pt.cond[0]
+	for _, e := range pt.cond[1:] {
+		c = And{c, e}
+	}
+	return c
+}
+
+type symExecutor struct {
+	inputs map[string]*symInput
+	// the kinds of the symbols
+	kinds kindEnv
+	bound int
+	// the maximal number of paths, and the number of paths so far
+	maxPaths int
+	paths    int
+	lines    map[string]int
+}
+
+// the default maximal number of paths of a symbolic execution
+const maxSymPaths = 1000
+
+// finds the inputs of a program, only those of the given variables unless names is empty
+func symInputs(prg Prog, names []string) []*symInput {
+	wanted := make(map[string]bool)
+	for _, x := range names {
+		wanted[x] = true
+	}
+	lines := lineNumbers(prg)
+	var inputs []*symInput
+	count := make(map[string]int)
+	walkPaths(prg, func(n Node, p Path) bool {
+		if d, ok := n.(Decl); ok && (len(names) == 0 || wanted[d.lhs]) {
+			switch d.rhs.(type) {
+			case Num, Bool:
+				inputs = append(inputs, &symInput{name: d.lhs, variable: d.lhs, path: p, line: lineOf(lines, p), lit: d.rhs})
+				count[d.lhs]++
+			}
+		}
+		return true
+	})
+	for _, in := range inputs {
+		if count[in.name] > 1 {
+			in.name = fmt.Sprintf("%s_%d", in.name, in.line)
+		}
+	}
+	return inputs
+}
+
+// executes a program symbolically, unrolling loops at most bound times and forking at most about maxPaths paths
+// returns the paths in the order of the branches taken (then before else, another iteration before leaving a loop)
+func symExec(prg Prog, inputs []*symInput, bound, maxPaths int) []*symPath {
+	x := &symExecutor{inputs: make(map[string]*symInput), kinds: inputKinds(inputs), bound: bound, maxPaths: maxPaths,
+		paths: 1, lines: lineNumbers(prg)}
+	for _, in := range inputs {
+		x.inputs[in.path.String()] = in
+	}
+	return x.exec(prg, Path{}, []*symPath{{vals: make(map[string]symVal)}})
+}
+
+// returns the kinds of the symbols of inputs
+func inputKinds(inputs []*symInput) kindEnv {
+	kinds := make(kindEnv)
+	for _, in := range inputs {
+		if kindOfLiteral(in.lit) == ValueInt {
+			kinds[in.name] = kindInt
+		} else {
+			kinds[in.name] = kindBool
+		}
+	}
+	return kinds
+}
+
+func (x *symExecutor) simplify(e Exp) Exp {
+	return optimizeExp(e, x.kinds)
+}
+
+// returns whether the conditions of a path can all be true (or the solver doesn't know)
+func (x *symExecutor) feasible(cond []Exp) bool {
+	res, _ := solve(cond, x.kinds)
+	return res != unsat
+}
+
+// forks a path at a condition, returns the paths on which it is true and false (nil if they are infeasible)
+func (x *symExecutor) branch(pt *symPath, c Exp) (*symPath, *symPath) {
+	switch c {
+	case Bool(true):
+		return pt, nil
+	case Bool(false):
+		return nil, pt
+	}
+	t, f := pt.fork(), pt.fork()
+	t.cond = append(t.cond, c)
+	f.cond = append(f.cond, x.simplify(Negation{c}))
+	if !x.feasible(t.cond) {
+		t = nil
+	}
+	if !x.feasible(f.cond) {
+		f = nil
+	}
+	if t != nil && f != nil {
+		x.paths++
+	}
+	return t, f
+}
+
+// cuts off the path on which a condition at line is true if the paths are more than the limit
+func (x *symExecutor) limit(t, f *symPath, line int) {
+	if t != nil && f != nil && x.paths > x.maxPaths {
+		t.cut, t.limited = line, true
+	}
+}
+
+// the value of an expression on a path
+type symResult struct {
+	path *symPath
+	val  symVal
+}
+
+// evaluates an expression symbolically, forking the path if && or || can be undefined or not
+func (x *symExecutor) eval(pt *symPath, e Exp) []symResult {
+	one := func(v symVal) []symResult { return []symResult{{pt, v}} }
+	undefined := symVal{kind: Undefined}
+	switch e := e.(type) {
+	case Num:
+		return one(symVal{ValueInt, e})
+	case Bool:
+		return one(symVal{ValueBool, e})
+	case Var:
+		return one(pt.get(string(e)))
+	case Group:
+		return x.eval(pt, e[0])
+	case Negation:
+		var rs []symResult
+		for _, r := range x.eval(pt, e[0]) {
+			if r.val.kind == ValueBool {
+				r.val.exp = x.simplify(Negation{r.val.exp})
+			} else {
+				r.val = undefined
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+
+	var l, r Exp
+	switch e := e.(type) {
+	case Plus:
+		l, r = e[0], e[1]
+	case Mult:
+		l, r = e[0], e[1]
+	case Lesser:
+		l, r = e[0], e[1]
+	case Equal:
+		l, r = e[0], e[1]
+	case And:
+		l, r = e[0], e[1]
+	case Or:
+		l, r = e[0], e[1]
+	}
+	var rs []symResult
+	for _, rl := range x.eval(pt, l) {
+		for _, rr := range x.eval(rl.path, r) {
+			a, b := rl.val, rr.val
+			v := undefined
+			switch e.(type) {
+			case Plus:
+				if a.kind == ValueInt && b.kind == ValueInt {
+					v = symVal{ValueInt, x.simplify(Plus{a.exp, b.exp})}
+				}
+			case Mult:
+				if a.kind == ValueInt && b.kind == ValueInt {
+					v = symVal{ValueInt, x.simplify(Mult{a.exp, b.exp})}
+				}
+			case Lesser:
+				if a.kind == ValueInt && b.kind == ValueInt {
+					v = symVal{ValueBool, x.simplify(Lesser{a.exp, b.exp})}
+				}
+			case Equal:
+				if a.kind == b.kind && a.kind != Undefined {
+					v = symVal{ValueBool, x.simplify(Equal{a.exp, b.exp})}
+				}
+			case And, Or:
+				_, isOr := e.(Or)
+				switch {
+				case a.kind == ValueBool && b.kind == ValueBool && isOr:
+					v = symVal{ValueBool, x.simplify(Or{a.exp, b.exp})}
+				case a.kind == ValueBool && b.kind == ValueBool:
+					v = symVal{ValueBool, x.simplify(And{a.exp, b.exp})}
+				case a.kind == ValueBool:
+					// the left side decides on its own (true for ||, false for &&), otherwise the result is undefined
+					t, f := x.branch(rr.path, a.exp)
+					decided, other := f, t
+					if isOr {
+						decided, other = t, f
+					}
+					if decided != nil {
+						rs = append(rs, symResult{decided, symVal{ValueBool, Bool(isOr)}})
+					}
+					if other != nil {
+						rs = append(rs, symResult{other, undefined})
+					}
+					continue
+				}
+			}
+			rs = append(rs, symResult{rr.path, v})
+		}
+	}
+	return rs
+}
+
+// copies the values of the nested scope inner back to outer, like ValState.update
+func symUpdate(outer, inner map[string]symVal) map[string]symVal {
+	r := make(map[string]symVal)
+	for k, v := range outer {
+		r[k] = v
+		if w, ok := inner[k]; ok && w.kind == v.kind {
+			r[k] = w
+		}
+	}
+	return r
+}
+
+// executes a statement on paths, returns the resulting paths
+func (x *symExecutor) exec(stmt Stmt, p Path, paths []*symPath) []*symPath {
+	switch stmt := stmt.(type) {
+	case Prog:
+		return x.exec(stmt[0], p.child(0), paths)
+	case Block:
+		return x.exec(stmt[0], p.child(0), paths)
+	case Seq:
+		return x.exec(stmt[1], p.child(1), x.exec(stmt[0], p.child(0), paths))
+	}
+	var res []*symPath
+	for _, pt := range paths {
+		if pt.cut != 0 {
+			res = append(res, pt)
+			continue
+		}
+		switch stmt := stmt.(type) {
+		case Decl:
+			if in, ok := x.inputs[p.String()]; ok {
+				pt.vals[stmt.lhs] = symVal{kindOfLiteral(in.lit), Var(in.name)}
+				res = append(res, pt)
+				continue
+			}
+			for _, r := range x.eval(pt, stmt.rhs) {
+				r.path.vals[stmt.lhs] = r.val
+				res = append(res, r.path)
+			}
+		case Assign:
+			for _, r := range x.eval(pt, stmt.rhs) {
+				if old, ok := r.path.vals[stmt.lhs]; ok && old.kind == r.val.kind {
+					r.path.vals[stmt.lhs] = r.val
+				} else {
+					r.path.out = append(r.path.out, symOutput{msg: "assign eval fail"})
+				}
+				res = append(res, r.path)
+			}
+		case Print:
+			for _, r := range x.eval(pt, stmt.printExp) {
+				r.path.out = append(r.path.out, symOutput{val: r.val})
+				res = append(res, r.path)
+			}
+		case IfThenElse:
+			for _, r := range x.eval(pt, stmt.cond) {
+				if r.val.kind != ValueBool {
+					r.path.out = append(r.path.out, symOutput{msg: "if-then-else eval fail"})
+					res = append(res, r.path)
+					continue
+				}
+				t, f := x.branch(r.path, r.val.exp)
+				x.limit(t, f, lineOf(x.lines, p))
+				for i, b := range []*symPath{t, f} {
+					if b == nil {
+						continue
+					}
+					outer := b.vals
+					b.vals = symUpdate(outer, outer)
+					for _, done := range x.exec([]Stmt{stmt.thenBl, stmt.elseBl}[i], p.child(i+1), []*symPath{b}) {
+						done.vals = symUpdate(outer, done.vals)
+						res = append(res, done)
+					}
+				}
+			}
+		case While:
+			outer := pt.vals
+			pt.vals = symUpdate(outer, outer)
+			res = append(res, x.loop(stmt, p, pt, outer, 0)...)
+		}
+	}
+	return res
+}
+
+// executes the iterations of a loop on a path, after n iterations; outer is the state of the enclosing scope
+func (x *symExecutor) loop(w While, p Path, pt *symPath, outer map[string]symVal, n int) []*symPath {
+	var res []*symPath
+	for _, r := range x.eval(pt, w.cond) {
+		if r.val.kind != ValueBool {
+			// the nested scope is thrown away; every path gets its own copy of outer, paths failing at different
+			// iterations would share it otherwise
+			r.path.out = append(r.path.out, symOutput{msg: "while eval fail"})
+			r.path.vals = symUpdate(outer, outer)
+			res = append(res, r.path)
+			continue
+		}
+		t, f := x.branch(r.path, r.val.exp)
+		x.limit(t, f, lineOf(x.lines, p))
+		if t != nil && t.cut == 0 && n == x.bound {
+			t.cut = lineOf(x.lines, p)
+		}
+		if t != nil && t.cut != 0 {
+			res = append(res, t)
+		} else if t != nil {
+			for _, done := range x.exec(w.do, p.child(1), []*symPath{t}) {
+				done.vals = symUpdate(outer, done.vals)
+				if done.cut != 0 {
+					res = append(res, done)
+					continue
+				}
+				res = append(res, x.loop(w, p, done, outer, n+1)...)
+			}
+		}
+		if f != nil {
+			f.vals = symUpdate(outer, f.vals)
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func kindOfLiteral(lit Exp) Kind {
+	if _, ok := lit.(Bool); ok {
+		return ValueBool
+	}
+	return ValueInt
+}
+
+func (v symVal) String() string {
+	if v.kind == Undefined {
+		return "Undefined"
+	}
+	return v.exp.pretty()
+}
+
+// returns the value of a symbolic value for the values of the symbols
+func (v symVal) concrete(symbols ValState) Val {
+	if v.kind == Undefined {
+		return mkUndefined()
+	}
+	return v.exp.eval(symbols)
+}
+
+// returns what a path prints for the values of the symbols
+func (pt *symPath) output(symbols ValState) string {
+	var b strings.Builder
+	for _, o := range pt.out {
+		if o.msg != "" {
+			b.WriteString(o.msg)
+		} else {
+			b.WriteString(showVal(o.val.concrete(symbols)) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// returns the values of the symbols of the inputs as declared in the program
+func literalValues(inputs []*symInput) ValState {
+	s := make(map[string]Val)
+	for _, in := range inputs {
+		s[in.name] = in.lit.eval(s)
+	}
+	return s
+}
+
+// returns a description of the inputs and the paths of a symbolic execution
+func showSymbolic(inputs []*symInput, paths []*symPath) string {
+	var b strings.Builder
+	var ins []string
+	for _, in := range inputs {
+		ins = append(ins, fmt.Sprintf("%s = %s (line %d)", in.name, in.lit.pretty(), in.line))
+	}
+	if len(ins) == 0 {
+		ins = append(ins, "none")
+	}
+	fmt.Fprintf(&b, "inputs: %s\n", strings.Join(ins, ", "))
+	for i, pt := range paths {
+		fmt.Fprintf(&b, "\npath %d: %s\n", i+1, pt.condition().pretty())
+		if res, model := solve(pt.cond, nil); res == sat && len(model) > 0 {
+			fmt.Fprintf(&b, "  taken with %s\n", showState(model))
+		}
+		for _, o := range pt.out {
+			if o.msg != "" {
+				fmt.Fprintf(&b, "  prints %q\n", o.msg)
+			} else {
+				fmt.Fprintf(&b, "  prints %s\n", o.val)
+			}
+		}
+		var names []string
+		for k := range pt.vals {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var vals []string
+		for _, k := range names {
+			vals = append(vals, k+": "+pt.vals[k].String())
+		}
+		if pt.limited {
+			fmt.Fprintf(&b, "  cut off by the path limit at line %d with {%s}\n", pt.cut, strings.Join(vals, ", "))
+		} else if pt.cut != 0 {
+			fmt.Fprintf(&b, "  cut off by the loop bound at line %d with {%s}\n", pt.cut, strings.Join(vals, ", "))
+		} else {
+			fmt.Fprintf(&b, "  ends with {%s}\n", strings.Join(vals, ", "))
+		}
+	}
+	return b.String()
+}
+
+// checks that exactly one path of the symbolic execution is taken with the literals of the program, and that it
+// gives the output and final state of eval (for a path cut off by the bound or the path limit, a prefix of the output)
+// returns a description of the first difference, or an empty string
+func checkSymbolic(prg Prog, bound, maxPaths int) string {
+	inputs := symInputs(prg, nil)
+	symbols := literalValues(inputs)
+	var taken []*symPath
+	for _, pt := range symExec(prg, inputs, bound, maxPaths) {
+		if pt.condition().eval(symbols) == mkBool(true) {
+			taken = append(taken, pt)
+		}
+	}
+	if len(taken) != 1 {
+		return fmt.Sprintf("%d paths are taken with the literals of the program instead of 1", len(taken))
+	}
+	pt := taken[0]
+	wantOut, wantState := evalCaptured(prg)
+	gotOut := pt.output(symbols)
+	if pt.cut != 0 {
+		if !strings.HasPrefix(wantOut, gotOut) {
+			return fmt.Sprintf("output of the cut off path isn't a prefix of eval's:\n--- eval\n%s\n--- symbolic\n%s", wantOut, gotOut)
+		}
+		return ""
+	}
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- symbolic\n%s", wantOut, gotOut)
+	}
+	gotState := make(map[string]Val)
+	for k, v := range pt.vals {
+		gotState[k] = v.concrete(symbols)
+	}
+	if !sameState(gotState, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, symbolic %s", showState(wantState), showState(gotState))
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSymbolicExamples(t *testing.T) {
+	for _, name := range exampleNames {
+		if diff := checkSymbolic(examples[name](), 3, maxSymPaths); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+// paths leaving a loop with a failing condition at different iterations don't share their state
+func TestSymbolicWhileFailAtSeveralIterations(t *testing.T) {
+	prg := failingLoop()
+	if out, _ := evalCaptured(prg); out != "while eval fail10\n" {
+		t.Fatalf("eval printed %q", out)
+	}
+	if diff := checkSymbolic(prg, 3, maxSymPaths); diff != "" {
+		t.Error(diff)
+	}
+}
+
+// the path taken with the literals is still found when the path limit cuts off most paths
+func TestCheckSymbolicPathLimit(t *testing.T) {
+	for name, prg := range testPrograms() {
+		for _, limit := range []int{1, 2, 5} {
+			if diff := checkSymbolic(prg, 3, limit); diff != "" {
+				t.Errorf("%s, limit %d: %s", name, limit, diff)
+			}
+		}
+	}
+}
+
+// loops one after the other multiply their paths, the limit cuts them off
+func TestSymbolicPathLimit(t *testing.T) {
+	// a := 3; i := 0; while (i<a){ i = (i+1) }; b := 3; i := 0; while (i<b){ i = (i+1) }; c := 3; ...
+	var stmts []Stmt
+	for _, n := range []string{"a", "b", "c"} {
+		stmts = append(stmts, declaration(n, number(3)), declaration("i", number(0)),
+			while(lesser(variable("i"), variable(n)), block(assignment("i", plus(variable("i"), number(1))))))
+	}
+	prg := generateProg(stmts)
+	inputs := symInputs(prg, []string{"a", "b", "c"})
+	if n := len(symExec(prg, inputs, 2, maxSymPaths)); n != 40 {
+		t.Errorf("%d paths without the limit instead of 40", n)
+	}
+	paths := symExec(prg, inputs, 5, 3)
+	if len(paths) != 10 {
+		t.Errorf("%d paths with a limit of 3 instead of 10", len(paths))
+	}
+	if out := showSymbolic(inputs, paths); !strings.Contains(out, "cut off by the path limit at line 4") {
+		t.Errorf("no path cut off by the limit:\n%s", out)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// termination analysis of loops
+//
+// a loop terminates if it has a ranking function: a function of the state at the head of the loop that is at
+// least 0 whenever the loop iterates and decreases by at least 1 in every iteration. Ranking functions are
+// linear terms over the integer variables. The candidates come from the comparisons of the condition (j-i for
+// i<j) and their sums, the comparisons of the body and the variables the body assigns; a candidate has to
+// decrease on the transition relation of an iteration, and its constant is its least value on it.
+//
+// the transition relation is encoded like in bmc.go, starting from a state where every variable at the head of
+// the loop is unknown. Nested loops aren't unrolled, they give any values to the variables they modify such that
+// they exit. So a loop with a ranking function only terminates if its nested loops do.
+//
+// a loop runs forever from a state of a recurrent set: a set of states where the condition holds and that no
+// iteration leaves. The candidates are the condition, and the condition together with a comparison of the loop
+// or its negation. A state of a recurrent set is a witness of non-termination, if the program can reach it.
+//
+// the solver reasons about unbounded integers, while sums and products of the interpreter wrap around: a loop
+// adding 2 to x while x<y runs forever if y is the largest integer and x is even, and a loop doubling y while 0<y
+// stops when y overflows. So the witness is replayed on the interpreter for a number of iterations, and if the
+// loop stops the result is unknown. Otherwise both results only hold assuming that no sum or product
+// overflows, which they say if the loop has any.
+
+type termination int
+
+const (
+	terminates termination = iota
+	mayNotTerminate
+	terminationUnknown
+)
+
+func (t termination) String() string {
+	return [...]string{"terminates", "possibly non-terminating", "unknown"}[t]
+}
+
+// the maximal number of candidates for ranking functions and for recurrent sets of a loop
+const maxRankingCandidates = 64
+
+// the number of iterations a witness of non-termination is replayed on the interpreter
+const witnessIterations = 1000
+
+// the result of the termination analysis of a loop
+type loopTermination struct {
+	path   Path
+	line   int
+	loop   While
+	result termination
+	// the ranking function, also for an unknown result if only a nested loop prevents the proof
+	ranking *linTerm
+	// a state at the head of the loop from which it runs forever
+	witness ValState
+	// why the result is unknown
+	reason string
+}
+
+// analyzes the termination of every loop of a program, in pre-order
+func terminationOf(prg Prog) []*loopTermination {
+	lines := lineNumbers(prg)
+	var results []*loopTermination
+	loopHeads(prg, Path{}, make(kindEnv), func(w While, p Path, env kindEnv) {
+		r := &loopTermination{path: p, line: lineOf(lines, p), loop: w}
+		r.analyze(lines, env)
+		results = append(results, r)
+	})
+	// nested loops come after their loop, so they are final when it is checked
+	for i := len(results) - 1; i >= 0; i-- {
+		r := results[i]
+		if r.result != terminates {
+			continue
+		}
+		for _, n := range results[i+1:] {
+			if n.path.within(r.path) && n.result != terminates {
+				r.result = terminationUnknown
+				r.reason = fmt.Sprintf("the loop at line %d might not terminate", n.line)
+				break
+			}
+		}
+	}
+	return results
+}
+
+// calls f for every loop of a statement, with the kinds of the variables before it
+func loopHeads(s Stmt, p Path, env kindEnv, f func(While, Path, kindEnv)) {
+	switch s := s.(type) {
+	case Prog:
+		loopHeads(s[0], p.child(0), env, f)
+	case Block:
+		loopHeads(s[0], p.child(0), env, f)
+	case Seq:
+		loopHeads(s[0], p.child(0), env, f)
+		loopHeads(s[1], p.child(1), kindsAfter(s[0], env), f)
+	case IfThenElse:
+		loopHeads(s.thenBl, p.child(1), env, f)
+		loopHeads(s.elseBl, p.child(2), env, f)
+	case While:
+		f(s, p, env)
+		loopHeads(s.do, p.child(1), env, f)
+	}
+}
+
+// analyzes a loop, env holds the kinds of the variables at its head
+func (r *loopTermination) analyze(lines map[string]int, env kindEnv) {
+	k, ok := staticKind(env, r.loop.cond)
+	if !ok {
+		r.result = terminationUnknown
+		r.reason = fmt.Sprintf("the kind of %s depends on the values of its variables", r.loop.cond.pretty())
+		return
+	}
+	if k != ValueBool {
+		// evalHooks.exec doesn't run the body if the condition isn't a boolean
+		r.result, r.ranking = terminates, &linTerm{}
+		return
+	}
+	t, err := newTransition(r.loop, r.path, lines, env)
+	if err != nil {
+		r.result, r.reason = terminationUnknown, err.Error()
+		return
+	}
+	if r.ranking = t.ranking(); r.ranking != nil {
+		r.result = terminates
+		return
+	}
+	if r.witness = t.recurrent(); r.witness != nil {
+		if n, stopped := r.replay(); stopped {
+			r.result = terminationUnknown
+			r.reason = fmt.Sprintf("it stops after %d iterations from the state %s of a recurrent set, as a sum or product overflows",
+				n, showState(r.witness))
+			r.witness = nil
+			return
+		}
+		r.result = mayNotTerminate
+		return
+	}
+	r.result, r.reason = terminationUnknown, "no ranking function or recurrent set was found"
+}
+
+// runs the loop on the interpreter from its witness for at most witnessIterations iterations
+// returns the number of iterations and whether the loop stopped (a nested loop running forever doesn't)
+func (r *loopTermination) replay() (int, bool) {
+	s := copyState(r.witness)
+	iterations, steps := -1, 0
+	h := &evalHooks{
+		stmt: func(stmt Stmt, p Path, s ValState) bool {
+			steps++
+			return steps <= maxAssertionSteps
+		},
+		iteration: func(w While, p Path, s2 ValState) bool {
+			if len(p) == 0 {
+				iterations++
+			}
+			return iterations < witnessIterations
+		},
+	}
+	var stopped bool
+	captureOutput(func() { stopped = h.exec(r.loop, Path{}, s) })
+	return iterations, stopped
+}
+
+// the transition relation of an iteration of a loop
+type transition struct {
+	e    *bmcEncoder
+	loop While
+	// the state at the head of the loop, where a variable x is the variable x of the formulas, and the state
+	// after the iteration
+	pre, post bmcState
+	// the integer variables at the head
+	ints []string
+	// the condition of the loop, the guard of the end of the body and the equations of the body
+	conds []Exp
+}
+
+func newTransition(w While, p Path, lines map[string]int, env kindEnv) (*transition, error) {
+	for _, x := range stmtVars(w).sorted() {
+		if _, ok := staticKind(env, Var(x)); !ok {
+			return nil, fmt.Errorf("the kind of %s depends on the values of the program", x)
+		}
+	}
+	e := &bmcEncoder{lines: lines, inputs: make(map[string]*symInput), asserts: make(map[string][]*bmcAssertion),
+		kinds: make(kindEnv), versions: make(map[string]int), violations: make(map[*bmcAssertion][]Exp),
+		cuts: make(map[int][]Exp), havoc: true}
+	t := &transition{e: e, loop: w, pre: make(bmcState)}
+	for x := range env {
+		k, ok := staticKind(env, Var(x))
+		switch {
+		case !ok:
+			continue
+		case k == Undefined:
+			t.pre[x] = bmcVal{kind: Undefined}
+			continue
+		case k == ValueInt:
+			t.ints = append(t.ints, x)
+		}
+		t.pre[x] = bmcVal{k, Var(x)}
+		e.kinds[x] = 1 << k
+	}
+	sort.Strings(t.ints)
+	cond, err := e.formula(w.cond, t.pre)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %s", lineOf(lines, p), err)
+	}
+	s, g, err := e.encode(w.do, p.child(1), Bool(true), t.pre.copy())
+	if err != nil {
+		return nil, err
+	}
+	t.post = t.pre.update(s)
+	t.conds = append([]Exp{cond, g}, e.defs...)
+	return t, nil
+}
+
+// returns the solver's result for the conditions of the transition and further ones
+func (t *transition) solve(conds ...Exp) (satResult, ValState) {
+	return solve(append(append([]Exp{}, t.conds...), conds...), t.e.kinds)
+}
+
+// returns the formula for a linear term of the program variables in a state
+func (t *transition) term(f linTerm, s bmcState) Exp {
+	var e Exp = Num(f.c)
+	for _, x := range f.vars() {
+		e = Plus{e, Mult{Num(f.coef[x]), s[x].exp}}
+	}
+	return e
+}
+
+// returns a ranking function, nil if none is found
+func (t *transition) ranking() *linTerm {
+	if res, _ := t.solve(); res == unsat {
+		// no iteration can complete
+		return &linTerm{}
+	}
+	for _, f := range t.rankingCandidates() {
+		if res, _ := t.solve(Negation{Lesser{t.term(f, t.post), t.term(f, t.pre)}}); res != unsat {
+			continue
+		}
+		if least, ok := t.least(f); ok {
+			f.c = -least
+			return &f
+		}
+	}
+	return nil
+}
+
+// returns the least value of a linear term at the head of the loop when it iterates, false if it has none or
+// the solver doesn't find it
+func (t *transition) least(f linTerm) (int, bool) {
+	at := t.term(f, t.pre)
+	// f has the value hi in some iteration, a lower bound lo is searched at growing distances below it
+	res, model := t.solve()
+	if res != sat {
+		return 0, false
+	}
+	hi := f.value(model)
+	lo := hi
+	for i := 0; ; i++ {
+		if i == 32 {
+			return 0, false
+		}
+		lo = hi - (1<<i - 1)
+		res, model = t.solve(Lesser{at, Num(lo)})
+		if res == unsat {
+			break
+		}
+		if res != sat {
+			return 0, false
+		}
+		hi = f.value(model)
+	}
+	for lo < hi {
+		mid := floorDiv(lo+hi, 2)
+		switch res, model = t.solve(Lesser{at, Num(mid + 1)}); res {
+		case sat:
+			hi = f.value(model)
+		case unsat:
+			lo = mid + 1
+		default:
+			return 0, false
+		}
+	}
+	return lo, true
+}
+
+// returns the candidates for ranking functions, without constants
+func (t *transition) rankingCandidates() []linTerm {
+	ints := make(varSet)
+	for _, x := range t.ints {
+		ints[x] = true
+	}
+	var forms []linTerm
+	seen := make(map[string]bool)
+	add := func(f linTerm) {
+		f.c = 0
+		if len(f.coef) == 0 || seen[f.String()] || len(forms) == maxRankingCandidates {
+			return
+		}
+		for x := range f.coef {
+			if !ints[x] {
+				return
+			}
+		}
+		seen[f.String()] = true
+		forms = append(forms, f)
+	}
+	for _, c := range comparisons(t.loop.cond) {
+		for _, f := range differences(c) {
+			add(f)
+		}
+	}
+	n := len(forms)
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			add(forms[i].add(forms[j], 1))
+		}
+	}
+	for _, c := range comparisons(t.loop.do) {
+		for _, f := range differences(c) {
+			add(f)
+		}
+	}
+	for _, x := range modifiedVars(t.loop.do).sorted() {
+		add(linTerm{coef: map[string]int{x: 1}})
+		add(linTerm{coef: map[string]int{x: -1}})
+	}
+	return forms
+}
+
+// returns a state of a recurrent set, nil if none is found
+func (t *transition) recurrent() ValState {
+	cond := t.loop.cond
+	sets := []Exp{cond}
+	for _, c := range append(comparisons(cond), comparisons(t.loop.do)...) {
+		sets = append(sets, And{cond, c}, And{cond, Negation{c}})
+		if eq, ok := c.(Equal); ok {
+			sets = append(sets, And{cond, Lesser{eq[0], eq[1]}}, And{cond, Lesser{eq[1], eq[0]}})
+		}
+	}
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		if seen[set.pretty()] || len(seen) == maxRankingCandidates {
+			continue
+		}
+		seen[set.pretty()] = true
+		pre, err := t.e.formula(set, t.pre)
+		if err != nil {
+			continue
+		}
+		post, err := t.e.formula(set, t.post)
+		if err != nil {
+			continue
+		}
+		res, model := t.solve(pre)
+		if res != sat {
+			continue
+		}
+		if res, _ := t.solve(pre, Negation{post}); res != unsat {
+			continue
+		}
+		witness := make(ValState)
+		for x, v := range t.pre {
+			if v.kind == Undefined {
+				witness[x] = mkUndefined()
+			} else {
+				witness[x] = model[x]
+			}
+		}
+		return witness
+	}
+	return nil
+}
+
+// returns the comparisons (Lesser and Equal) in a node
+func comparisons(n Node) []Exp {
+	var cs []Exp
+	walkPre(n, func(n Node) bool {
+		switch n := n.(type) {
+		case Lesser:
+			cs = append(cs, n)
+		case Equal:
+			cs = append(cs, n)
+		}
+		return true
+	})
+	return cs
+}
+
+// returns the differences of the sides of a comparison of linear terms, right minus left first
+func differences(c Exp) []linTerm {
+	sides := children(c)
+	l, err := linearize(sides[0].(Exp))
+	if err != nil {
+		return nil
+	}
+	r, err := linearize(sides[1].(Exp))
+	if err != nil {
+		return nil
+	}
+	return []linTerm{r.add(l, -1), l.add(r, -1)}
+}
+
+// returns the value of a linear term in a state
+func (t linTerm) value(s ValState) int {
+	v := t.c
+	for x, a := range t.coef {
+		v += a * s[x].valI
+	}
+	return v
+}
+
+// returns a linear term as an expression, like j - i - 1, with the positive terms first
+func (t linTerm) pretty() string {
+	type term struct {
+		a int
+		x string
+	}
+	var terms []term
+	for _, positive := range []bool{true, false} {
+		for _, x := range t.vars() {
+			if t.coef[x] > 0 == positive {
+				terms = append(terms, term{t.coef[x], x})
+			}
+		}
+		if t.c != 0 && t.c > 0 == positive {
+			terms = append(terms, term{t.c, ""})
+		}
+	}
+	if len(terms) == 0 {
+		return "0"
+	}
+	var b strings.Builder
+	for i, tm := range terms {
+		a := tm.a
+		switch {
+		case a < 0 && i == 0:
+			b.WriteString("-")
+		case a < 0:
+			b.WriteString(" - ")
+		case i > 0:
+			b.WriteString(" + ")
+		}
+		if a < 0 {
+			a = -a
+		}
+		switch {
+		case tm.x == "":
+			fmt.Fprint(&b, a)
+		case a == 1:
+			b.WriteString(tm.x)
+		default:
+			fmt.Fprintf(&b, "%d*%s", a, tm.x)
+		}
+	}
+	return b.String()
+}
+
+// returns the results of the termination analysis, one line per loop
+func showTermination(results []*loopTermination) string {
+	if len(results) == 0 {
+		return "the program has no loops\n"
+	}
+	var b strings.Builder
+	for _, r := range results {
+		assuming := ""
+		if hasArithmetic(r.loop) {
+			assuming = ", assuming no overflow"
+		}
+		fmt.Fprintf(&b, "line %d: %s %s", r.line, headline(r.loop), r.result)
+		switch r.result {
+		case terminates:
+			if len(r.ranking.coef) == 0 && r.ranking.c == 0 {
+				b.WriteString(", it never completes an iteration")
+				break
+			}
+			fmt.Fprintf(&b, ", ranking function %s%s", r.ranking.pretty(), assuming)
+		case mayNotTerminate:
+			fmt.Fprintf(&b, ", it runs forever from the state %s at its head%s", showState(r.witness), assuming)
+		case terminationUnknown:
+			fmt.Fprintf(&b, ": %s", r.reason)
+			if r.ranking != nil {
+				fmt.Fprintf(&b, " (ranking function %s)", r.ranking.pretty())
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// returns the number of loops that possibly don't terminate and of loops with an unknown result
+func terminationFailures(results []*loopTermination) (int, int) {
+	nonTerminating, unknowns := 0, 0
+	for _, r := range results {
+		switch r.result {
+		case mayNotTerminate:
+			nonTerminating++
+		case terminationUnknown:
+			unknowns++
+		}
+	}
+	return nonTerminating, unknowns
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTermination(t *testing.T) {
+	y, b := variable("y"), variable("b")
+	tests := []struct {
+		loop Stmt
+		want string
+	}{
+		{while(lesser(y, number(3)), block(assignment("y", plus(y, number(1))))),
+			"terminates, ranking function 2 - y, assuming no overflow"},
+		{while(b, block(sPrint(b))),
+			"possibly non-terminating, it runs forever from the state {b: true, y: 0} at its head"},
+		{while(lesser(number(0), y), block(assignment("y", plus(y, number(0))))),
+			"possibly non-terminating, it runs forever from the state {b: false, y: 1} at its head, assuming no overflow"},
+		// y+y is 0 when y overflows, so the recurrent set 0<y+y isn't one on the interpreter
+		{while(and(lesser(mult(number(0), y), plus(y, y)), b), block(assignment("y", plus(y, y)))),
+			"unknown: it stops after 62 iterations from the state {b: true, y: 1} of a recurrent set, as a sum or product overflows"},
+	}
+	for _, test := range tests {
+		prg := generateProg([]Stmt{declaration("y", number(1)), declaration("b", boolean(true)), test.loop})
+		results := terminationOf(prg)
+		got := strings.TrimSuffix(showTermination(results), "\n")
+		if want := "line 4: " + headline(test.loop) + " " + test.want; got != want {
+			t.Errorf("got  %s\nwant %s", got, want)
+		}
+	}
+}
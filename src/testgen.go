@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// test generation and golden tests
+//
+// the inputs of a program are its declarations with literals (see symbolic.go). testgen explores the paths of the
+// program symbolically, asks the solver for inputs taking each complete path, and runs the program with these
+// inputs to see which branches (outcomes of if and while conditions) they cover. A greedy selection keeps inputs
+// until every coverable branch is covered. The tests are written as a golden file: the inputs with the output
+// and final state the interpreter produced for them. Replaying a golden file runs the program again with every
+// test's inputs and reports the tests whose output or final state changed.
+
+type goldenFile struct {
+	// the program: the name of an example or a JSON file, relative to the directory of the golden file
+	Program string       `json:"program"`
+	Tests   []goldenTest `json:"tests"`
+}
+
+type goldenTest struct {
+	Inputs []goldenInput `json:"inputs"`
+	// the branches covered by the test, like "line 4: while (i<j) true"
+	Covers []string               `json:"covers"`
+	Output string                 `json:"output"`
+	State  map[string]interface{} `json:"state"`
+}
+
+// the literal of the declaration at a path
+type goldenInput struct {
+	Path  string      `json:"path"`
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// returns the program with the literals of the inputs replaced
+func withInputs(prg Prog, inputs []goldenInput) (Prog, error) {
+	var n Node = prg
+	for _, in := range inputs {
+		p, err := parsePath(in.Path)
+		if err != nil {
+			return prg, err
+		}
+		node, err := nodeAt(n, p)
+		if err != nil {
+			return prg, err
+		}
+		d, ok := node.(Decl)
+		if !ok || d.lhs != in.Name {
+			return prg, fmt.Errorf("path %s isn't the declaration of %s", in.Path, in.Name)
+		}
+		var lit Exp
+		switch v := in.Value.(type) {
+		case json.Number:
+			i, err := strconv.Atoi(string(v))
+			if err != nil {
+				return prg, fmt.Errorf("the value of %s isn't an integer or a boolean", in.Name)
+			}
+			lit = Num(i)
+		case int:
+			lit = Num(v)
+		case bool:
+			lit = Bool(v)
+		default:
+			return prg, fmt.Errorf("the value of %s isn't an integer or a boolean", in.Name)
+		}
+		if kindOfLiteral(lit) != kindOfLiteral(d.rhs) {
+			return prg, fmt.Errorf("the value of %s has the wrong kind", in.Name)
+		}
+		if n, err = replaceAt(n, p, Decl{d.lhs, lit}); err != nil {
+			return prg, err
+		}
+	}
+	return n.(Prog), nil
+}
+
+// returns the branches of a program, by path and outcome (like "0.0.1 true")
+func branchesOf(prg Prog) []string {
+	var bs []string
+	walkPaths(prg, func(n Node, p Path) bool {
+		switch n.(type) {
+		case IfThenElse, While:
+			bs = append(bs, p.String()+" true", p.String()+" false")
+		}
+		return true
+	})
+	return bs
+}
+
+// returns the branches covered according to the coverage of a program
+func coveredBranches(c *coverage) map[string]bool {
+	covered := make(map[string]bool)
+	for p, n := range c.Ifs {
+		covered[p+" true"] = n[0] > 0
+		covered[p+" false"] = n[1] > 0
+	}
+	for p, n := range c.Loops {
+		covered[p+" true"] = n[1]+n[2] > 0
+		covered[p+" false"] = n[0]+n[1]+n[2] > 0
+	}
+	return covered
+}
+
+// describes a branch, like "line 4: while (i<j) true"
+func describeBranch(prg Prog, lines map[string]int, b string) string {
+	parts := strings.Fields(b)
+	p, _ := parsePath(parts[0])
+	n, _ := nodeAt(prg, p)
+	return fmt.Sprintf("line %d: %s %s", lineOf(lines, p), headline(n), parts[1])
+}
+
+// generates tests covering the branches of a program, exploring paths with at most bound iterations per loop and
+// cutting off forking paths once there are maxPaths
+// returns the golden file (without the name of the program) and the branches no test covers
+func testgen(prg Prog, bound, maxPaths int) (*goldenFile, []string) {
+	inputs := symInputs(prg, nil)
+	kinds := inputKinds(inputs)
+	literals := literalValues(inputs)
+	lines := lineNumbers(prg)
+
+	type candidate struct {
+		test    goldenTest
+		covered map[string]bool
+	}
+	var candidates []candidate
+	seen := make(map[string]bool)
+	for _, pt := range symExec(prg, inputs, bound, maxPaths) {
+		if pt.cut != 0 {
+			// the inputs might make the program loop forever, or take branches the path limit left unexplored
+			continue
+		}
+		res, model := solve(pt.cond, kinds)
+		if res != sat {
+			continue
+		}
+		var test goldenTest
+		for _, in := range inputs {
+			v, ok := model[in.name]
+			if !ok {
+				v = literals[in.name]
+			}
+			test.Inputs = append(test.Inputs, goldenInput{in.path.String(), in.variable, jsonVal(v)})
+		}
+		key, _ := json.Marshal(test.Inputs)
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		p, err := withInputs(prg, test.Inputs)
+		if err != nil {
+			panic(fmt.Sprintf("testgen: %s", err))
+		}
+		output, state := evalCaptured(p)
+		test.Output = output
+		test.State = jsonVals(state)
+		cov := newCoverage(p)
+		captureOutput(func() { cov.run(p) })
+		candidates = append(candidates, candidate{test, coveredBranches(cov)})
+	}
+
+	g := &goldenFile{}
+	covered := make(map[string]bool)
+	for len(candidates) > 0 {
+		// the candidate covering the most new branches is taken, the first one if none covers any
+		best, bestNew := -1, 0
+		for i, c := range candidates {
+			n := 0
+			for b, ok := range c.covered {
+				if ok && !covered[b] {
+					n++
+				}
+			}
+			if n > bestNew {
+				best, bestNew = i, n
+			}
+		}
+		if best < 0 {
+			if len(g.Tests) == 0 {
+				best = 0
+			} else {
+				break
+			}
+		}
+		c := candidates[best]
+		candidates = append(candidates[:best], candidates[best+1:]...)
+		for _, b := range branchesOf(prg) {
+			if c.covered[b] && !covered[b] {
+				c.test.Covers = append(c.test.Covers, describeBranch(prg, lines, b))
+				covered[b] = true
+			}
+		}
+		g.Tests = append(g.Tests, c.test)
+	}
+	var uncovered []string
+	for _, b := range branchesOf(prg) {
+		if !covered[b] {
+			uncovered = append(uncovered, describeBranch(prg, lines, b))
+		}
+	}
+	return g, uncovered
+}
+
+// replays the tests of a golden file on a program
+// returns a description of every test whose output or final state differs
+func replayGolden(prg Prog, g *goldenFile) []string {
+	var failures []string
+	for i, test := range g.Tests {
+		p, err := withInputs(prg, test.Inputs)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("test %d: %s", i+1, err))
+			continue
+		}
+		output, state := evalCaptured(p)
+		if output != test.Output {
+			failures = append(failures, fmt.Sprintf("test %d: output differs:\n--- golden\n%s\n--- now\n%s", i+1, test.Output, output))
+			continue
+		}
+		want, _ := json.Marshal(test.State)
+		got, _ := json.Marshal(jsonVals(state))
+		if string(want) != string(got) {
+			failures = append(failures, fmt.Sprintf("test %d: final state differs: golden %s, now %s", i+1, want, got))
+		}
+	}
+	return failures
+}
+
+func (g *goldenFile) marshal() ([]byte, error) {
+	raw, err := marshalJSON(g)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func unmarshalGolden(data []byte) (*goldenFile, error) {
+	var g goldenFile
+	// the values of the inputs are decoded as json.Number, a float64 can't hold every integer
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&g); err != nil {
+		return nil, err
+	}
+	if g.Program == "" {
+		return nil, fmt.Errorf("the golden file names no program")
+	}
+	return &g, nil
+}
+
+// returns a summary of generated tests
+func testgenSummary(g *goldenFile, uncovered []string) string {
+	var b strings.Builder
+	covered := 0
+	for _, t := range g.Tests {
+		covered += len(t.Covers)
+	}
+	fmt.Fprintf(&b, "%d tests cover %d of %d branches\n", len(g.Tests), covered, covered+len(uncovered))
+	for _, u := range uncovered {
+		fmt.Fprintf(&b, "not covered: %s\n", u)
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// generates the golden file of a program and reads it back like imp testgen and imp golden do
+func roundTripGolden(t *testing.T, name string, prg Prog) *goldenFile {
+	g, _ := testgen(prg, 5, maxSymPaths)
+	g.Program = name
+	data, err := g.marshal()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	read, err := unmarshalGolden(data)
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return read
+}
+
+func TestGoldenTestsPass(t *testing.T) {
+	for name, prg := range testPrograms() {
+		g := roundTripGolden(t, name, prg)
+		if len(g.Tests) == 0 {
+			t.Errorf("%s: no tests were generated", name)
+		}
+		if failures := replayGolden(prg, g); len(failures) > 0 {
+			t.Errorf("%s: %s", name, strings.Join(failures, "\n"))
+		}
+	}
+}
+
+func TestGoldenLargeIntegers(t *testing.T) {
+	// 2^53+1 can't be represented by a float64, 2^53 is the closest float64
+	prg := generateProg([]Stmt{declaration("x", number(1)), sPrint(variable("x"))})
+	golden := `{"program": "large", "tests": [{"inputs": [{"path": "0.0.0", "name": "x", "value": 9007199254740993}],
+		"output": "9007199254740993\n", "state": {"x": %s}}]}`
+	g, err := unmarshalGolden([]byte(fmt.Sprintf(golden, "9007199254740993")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := replayGolden(prg, g); len(failures) > 0 {
+		t.Errorf("%s", strings.Join(failures, "\n"))
+	}
+	g, err = unmarshalGolden([]byte(fmt.Sprintf(golden, "9007199254740992")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := replayGolden(prg, g); len(failures) != 1 {
+		t.Errorf("the wrong final state gives %d failures", len(failures))
+	}
+}
+
+func TestWithInputsRejectsNonIntegers(t *testing.T) {
+	prg := generateProg([]Stmt{declaration("x", number(1)), sPrint(variable("x"))})
+	if _, err := withInputs(prg, []goldenInput{{"0.0.0", "x", json.Number("2")}}); err != nil {
+		t.Errorf("the value 2 was rejected: %s", err)
+	}
+	for _, v := range []interface{}{json.Number("1.5"), true, "1"} {
+		if _, err := withInputs(prg, []goldenInput{{"0.0.0", "x", v}}); err == nil {
+			t.Errorf("the value %#v was accepted", v)
+		}
+	}
+}
+
+func TestUnmarshalGoldenNeedsProgram(t *testing.T) {
+	if _, err := unmarshalGolden([]byte(`{"tests":[]}`)); err == nil {
+		t.Errorf("a golden file without a program was accepted")
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// time-travel debugging: recording an execution and replaying it in both directions
+//
+// a recording is a trace (see trace.go), either of a program that is run for it or read from a JSON lines file.
+// Instead of copying states, the replay keeps one layer per scope, holding only the variables written in
+// that scope; a variable that isn't in the innermost layer has the value of the enclosing layers.
+// Every step of the recording stores its changes to the layers (old and new values), so it can be
+// applied forwards and undone backwards.
+
+// a step of a recording: an event of the trace and its changes to the layers
+type recordedStep struct {
+	kind    string
+	path    Path
+	line    int
+	desc    string
+	depth   int
+	written map[string]Val
+	// the variable that was dropped or refused
+	name   string
+	output string
+
+	changes []layerChange
+	// the step entered a scope (pushed an empty layer)
+	push bool
+	// the layer removed by leaving a scope, nil if the step didn't leave one
+	popped map[string]Val
+}
+
+// a change of a variable in a layer, a missing old or new value means the variable wasn't or isn't in the layer
+type layerChange struct {
+	layer      int
+	name       string
+	old, new   Val
+	hadOld, ok bool
+}
+
+type recording struct {
+	steps []recordedStep
+}
+
+// records the execution of a program
+func recordProg(prg Prog) *recording {
+	var steps []recordedStep
+	captureOutput(func() {
+		traceEval(prg, func(e traceEvent) {
+			steps = append(steps, recordedStep{kind: e.kind, path: e.path, line: e.line, desc: headline(e.node),
+				depth: e.depth, written: e.written, name: e.name, output: e.output})
+		})
+	})
+	rec, err := newRecording(steps)
+	if err != nil {
+		panic(fmt.Sprintf("record: %s", err))
+	}
+	return rec
+}
+
+// reads a recording from a trace in the JSON lines format written by imp trace
+func readRecording(r io.Reader) (*recording, error) {
+	var steps []recordedStep
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e struct {
+			Kind    string                 `json:"kind"`
+			Path    string                 `json:"path"`
+			Line    int                    `json:"line"`
+			Node    string                 `json:"node"`
+			Depth   int                    `json:"depth"`
+			Written map[string]interface{} `json:"written"`
+			Var     string                 `json:"var"`
+			Output  string                 `json:"output"`
+		}
+		// the values of the variables are decoded as json.Number, a float64 can't hold every integer
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		p, err := parsePath(e.Path)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		step := recordedStep{kind: e.Kind, path: p, line: e.Line, desc: e.Node, depth: e.Depth, name: e.Var, output: e.Output}
+		if e.Written != nil {
+			step.written = make(map[string]Val)
+			for k, v := range e.Written {
+				switch v := v.(type) {
+				case json.Number:
+					i, err := strconv.Atoi(string(v))
+					if err != nil {
+						return nil, fmt.Errorf("line %d: the value of %s isn't an integer", n, k)
+					}
+					step.written[k] = mkInt(i)
+				case bool:
+					step.written[k] = mkBool(v)
+				default:
+					step.written[k] = mkUndefined()
+				}
+			}
+		}
+		steps = append(steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	rec, err := newRecording(steps)
+	if err != nil {
+		return nil, fmt.Errorf("inconsistent trace: %s", err)
+	}
+	return rec, nil
+}
+
+// computes the changes of all steps by applying them once
+func newRecording(steps []recordedStep) (*recording, error) {
+	layers := []map[string]Val{make(map[string]Val)}
+	for i := range steps {
+		st := &steps[i]
+		set := func(name string, v Val, ok bool) {
+			top := layers[len(layers)-1]
+			old, hadOld := top[name]
+			st.changes = append(st.changes, layerChange{len(layers) - 1, name, old, v, hadOld, ok})
+			if ok {
+				top[name] = v
+			} else {
+				delete(top, name)
+			}
+		}
+		switch st.kind {
+		case "stmt":
+			for _, k := range sortedNames(st.written) {
+				set(k, st.written[k], true)
+			}
+		case "enter":
+			st.push = true
+			layers = append(layers, make(map[string]Val))
+		case "drop", "refuse":
+			// the variable takes the value of the enclosing scope again (or disappears)
+			set(st.name, Val{}, false)
+		case "exit":
+			if len(layers) == 1 {
+				return nil, fmt.Errorf("step %d leaves the outermost scope", i+1)
+			}
+			st.popped = layers[len(layers)-1]
+			layers = layers[:len(layers)-1]
+			for _, k := range sortedNames(st.written) {
+				set(k, st.written[k], true)
+			}
+		}
+		if len(layers)-1 != st.depth {
+			return nil, fmt.Errorf("step %d has depth %d, but there are %d nested scopes", i+1, st.depth, len(layers)-1)
+		}
+	}
+	return &recording{steps}, nil
+}
+
+func sortedNames(vals map[string]Val) []string {
+	var names []string
+	for k := range vals {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replays a recording, pos is the number of steps applied to the layers
+type replayer struct {
+	rec    *recording
+	pos    int
+	layers []map[string]Val
+}
+
+func newReplayer(rec *recording) *replayer {
+	return &replayer{rec: rec, layers: []map[string]Val{make(map[string]Val)}}
+}
+
+// applies the next step, returns false at the end of the recording
+func (r *replayer) forward() bool {
+	if r.pos == len(r.rec.steps) {
+		return false
+	}
+	st := r.rec.steps[r.pos]
+	if st.push {
+		r.layers = append(r.layers, make(map[string]Val))
+	}
+	if st.popped != nil {
+		r.layers = r.layers[:len(r.layers)-1]
+	}
+	for _, c := range st.changes {
+		if c.ok {
+			r.layers[c.layer][c.name] = c.new
+		} else {
+			delete(r.layers[c.layer], c.name)
+		}
+	}
+	r.pos++
+	return true
+}
+
+// undoes the last applied step, returns false at the beginning of the recording
+func (r *replayer) backward() bool {
+	if r.pos == 0 {
+		return false
+	}
+	r.pos--
+	st := r.rec.steps[r.pos]
+	for i := len(st.changes) - 1; i >= 0; i-- {
+		c := st.changes[i]
+		if c.hadOld {
+			r.layers[c.layer][c.name] = c.old
+		} else {
+			delete(r.layers[c.layer], c.name)
+		}
+	}
+	if st.popped != nil {
+		r.layers = append(r.layers, st.popped)
+	}
+	if st.push {
+		r.layers = r.layers[:len(r.layers)-1]
+	}
+	return true
+}
+
+// moves to the position after n steps
+func (r *replayer) seek(n int) {
+	for r.pos < n && r.forward() {
+	}
+	for r.pos > n && r.backward() {
+	}
+}
+
+// returns the state of the innermost scope at the current position
+func (r *replayer) state() ValState {
+	s := make(map[string]Val)
+	for _, layer := range r.layers {
+		for k, v := range layer {
+			s[k] = v
+		}
+	}
+	return s
+}
+
+// returns the number of the step (counted from 1) that executed the n-th statement, or 0
+func (r *replayer) statement(n int) int {
+	for i, st := range r.rec.steps {
+		if st.kind == "stmt" {
+			if n--; n == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// returns the number of the step whose statement wrote the current value of a variable, or 0
+// if the value was copied from a nested scope when leaving it, the write in the nested scope is returned
+func (r *replayer) lastWrite(name string) int {
+	layer := -1
+	for i := len(r.layers) - 1; i >= 0 && layer < 0; i-- {
+		if _, ok := r.layers[i][name]; ok {
+			layer = i
+		}
+	}
+	if layer < 0 {
+		return 0
+	}
+	for i := r.pos; i > 0; i-- {
+		st := r.rec.steps[i-1]
+		for _, c := range st.changes {
+			if c.name != name || c.layer != layer || !c.ok {
+				continue
+			}
+			if st.kind == "stmt" {
+				return i
+			}
+			// exit copied the value from the scope it left
+			layer++
+		}
+	}
+	return 0
+}
+
+// describes step n (counted from 1)
+func (r *replayer) describe(n int) string {
+	if n == 0 {
+		return "step 0: start of the program"
+	}
+	st := r.rec.steps[n-1]
+	desc := fmt.Sprintf("step %d: line %d, %s %s", n, st.line, st.kind, st.desc)
+	switch {
+	case st.kind == "drop" || st.kind == "refuse":
+		desc += " (" + st.name + ")"
+	case len(st.written) > 0:
+		var ws []string
+		for _, k := range sortedNames(st.written) {
+			ws = append(ws, k+" = "+showVal(st.written[k]))
+		}
+		desc += ", writes " + strings.Join(ws, ", ")
+	}
+	if st.output != "" {
+		desc += fmt.Sprintf(", prints %q", st.output)
+	}
+	return desc
+}
+
+const replayHelp = `commands:
+  f, forward [N]     replay N steps (default 1)
+  b, back [N]        undo N steps (default 1)
+  goto N             move to the state after step N
+  stmt N             move to the state after the N-th executed statement
+  last VAR           move back to the statement that wrote the current value of VAR
+  p, print           print the state of the innermost scope, by scope
+  q, quit            stop replaying
+  h, help            print this help
+`
+
+// replays a recording interactively, reading commands from in and writing to w
+func replay(rec *recording, in io.Reader, w io.Writer) {
+	r := newReplayer(rec)
+	fmt.Fprintf(w, "%d steps recorded\n%s\n", len(rec.steps), r.describe(0))
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(w, "(replay) ")
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		count := func() (int, bool) {
+			if len(fields) < 2 {
+				return 1, true
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				fmt.Fprintf(w, "expected a number instead of %q\n", fields[1])
+				return 0, false
+			}
+			return n, true
+		}
+		switch fields[0] {
+		case "f", "forward":
+			if n, ok := count(); ok {
+				r.seek(r.pos + n)
+				fmt.Fprintln(w, r.describe(r.pos))
+			}
+		case "b", "back":
+			if n, ok := count(); ok {
+				r.seek(r.pos - n)
+				fmt.Fprintln(w, r.describe(r.pos))
+			}
+		case "goto":
+			if n, ok := count(); ok {
+				r.seek(n)
+				fmt.Fprintln(w, r.describe(r.pos))
+			}
+		case "stmt":
+			if n, ok := count(); ok {
+				step := r.statement(n)
+				if step == 0 {
+					fmt.Fprintf(w, "less than %d statements were executed\n", n)
+					continue
+				}
+				r.seek(step)
+				fmt.Fprintln(w, r.describe(r.pos))
+				fmt.Fprintf(w, "  state %s\n", showState(r.state()))
+			}
+		case "last":
+			if len(fields) < 2 {
+				fmt.Fprintf(w, "last needs a variable\n")
+				continue
+			}
+			step := r.lastWrite(fields[1])
+			if step == 0 {
+				fmt.Fprintf(w, "%s wasn't written up to step %d\n", fields[1], r.pos)
+				continue
+			}
+			r.seek(step)
+			fmt.Fprintln(w, r.describe(r.pos))
+		case "p", "print":
+			for i, layer := range r.layers {
+				fmt.Fprintf(w, "  scope %d writes %s\n", i, showState(layer))
+			}
+			fmt.Fprintf(w, "  state %s\n", showState(r.state()))
+		case "q", "quit":
+			return
+		case "h", "help":
+			fmt.Fprint(w, replayHelp)
+		default:
+			fmt.Fprintf(w, "unknown command %q (h for help)\n", fields[0])
+		}
+	}
+}
+
+// checks that replaying the recording of a program forwards and backwards reproduces the states of eval:
+// the final state after replaying all steps, and the empty state after undoing them
+// returns a description of the first difference, or an empty string
+func checkReplay(prg Prog) string {
+	_, want := evalCaptured(prg)
+	r := newReplayer(recordProg(prg))
+	var states []ValState
+	for states = append(states, r.state()); r.forward(); {
+		states = append(states, r.state())
+	}
+	if !sameState(r.state(), want) {
+		return fmt.Sprintf("final state differs: eval %s, replay %s", showState(want), showState(r.state()))
+	}
+	for r.backward() {
+		if !sameState(r.state(), states[r.pos]) {
+			return fmt.Sprintf("going back to step %d gives %s instead of %s", r.pos, showState(r.state()), showState(states[r.pos]))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReplayReproducesEval(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkReplay(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestReadRecordingOfTrace(t *testing.T) {
+	for name, prg := range testPrograms() {
+		var lines []string
+		captureOutput(func() {
+			traceEval(prg, func(e traceEvent) { lines = append(lines, e.json()) })
+		})
+		rec, err := readRecording(strings.NewReader(strings.Join(lines, "\n")))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		r := newReplayer(rec)
+		r.seek(len(rec.steps))
+		_, want := evalCaptured(prg)
+		if !sameState(r.state(), want) {
+			t.Errorf("%s: final state differs: eval %s, replay %s", name, showState(want), showState(r.state()))
+		}
+	}
+}
+
+func TestReadRecordingValues(t *testing.T) {
+	// 2^53+1 can't be represented by a float64
+	line := `{"kind":"stmt","path":"0","line":1,"node":"x := 9007199254740993","depth":0,"written":{"x":9007199254740993}}`
+	rec, err := readRecording(strings.NewReader(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.steps[0].written["x"]; got != mkInt(9007199254740993) {
+		t.Errorf("read %s instead of 9007199254740993", showVal(got))
+	}
+	line = `{"kind":"stmt","path":"0","line":1,"node":"x := 1","depth":0,"written":{"x":1.5}}`
+	if _, err := readRecording(strings.NewReader(line)); err == nil {
+		t.Errorf("a value that isn't an integer was accepted")
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// execution traces
+//
+// traceEval evaluates a program like Prog.eval and reports an event for every executed statement and
+// every evaluated condition, and for the nested scopes of if and while statements:
+//
+//	stmt    a declaration, assignment or print was executed, with the values read and written
+//	cond    the condition of an if or while was evaluated, with the values read and the result
+//	enter   a nested scope was entered, its state is a copy of the enclosing one
+//	reset   an iteration of a while loop ended, the nested scope continues with the updated enclosing state
+//	exit    a nested scope was left, written holds the values copied to the enclosing scope
+//	drop    ValState.update dropped a variable declared in the nested scope
+//	refuse  ValState.update refused to copy a variable whose kind was changed in the nested scope
+//
+// depth is the number of nested scopes the event happened in (for enter the new scope, for exit the enclosing one).
+// Encoded as JSON lines, traces of different interpreter versions can be compared with diff.
+
+type traceEvent struct {
+	kind  string
+	path  Path
+	line  int
+	node  Node
+	depth int
+	read  map[string]Val
+	// the values written by a statement, or copied to the enclosing scope by exit
+	written map[string]Val
+	// the value of a condition, or of the variable that was dropped or refused
+	value Val
+	// the variable that was dropped or refused, and for refuse the value it keeps in the enclosing scope
+	name  string
+	outer Val
+	// what the event printed (including error messages of eval)
+	output string
+}
+
+// a value as JSON: a number, a boolean or null for undefined
+func jsonVal(v Val) interface{} {
+	switch v.flag {
+	case ValueInt:
+		return v.valI
+	case ValueBool:
+		return v.valB
+	}
+	return nil
+}
+
+func jsonVals(vals map[string]Val) map[string]interface{} {
+	m := make(map[string]interface{})
+	for k, v := range vals {
+		m[k] = jsonVal(v)
+	}
+	return m
+}
+
+// returns the event as one line of JSON, with the fields in a fixed order
+func (e traceEvent) json() string {
+	fields := []jsonField{
+		{"path", e.path.String()},
+		{"line", e.line},
+		{"node", headline(e.node)},
+		{"depth", e.depth},
+	}
+	if e.read != nil {
+		fields = append(fields, jsonField{"read", jsonVals(e.read)})
+	}
+	if e.written != nil {
+		fields = append(fields, jsonField{"written", jsonVals(e.written)})
+	}
+	switch e.kind {
+	case "cond":
+		fields = append(fields, jsonField{"value", jsonVal(e.value)})
+	case "drop":
+		fields = append(fields, jsonField{"var", e.name}, jsonField{"value", jsonVal(e.value)})
+	case "refuse":
+		fields = append(fields, jsonField{"var", e.name}, jsonField{"value", jsonVal(e.value)}, jsonField{"outer", jsonVal(e.outer)})
+	}
+	if e.output != "" {
+		fields = append(fields, jsonField{"output", e.output})
+	}
+	raw, err := jsonObject(e.kind, fields...)
+	if err != nil {
+		panic(fmt.Sprintf("trace: %s", err))
+	}
+	return string(raw)
+}
+
+// evaluates a program in a fresh state like Prog.eval and reports every event to emit
+// returns the final state
+func traceEval(prg Prog, emit func(traceEvent)) ValState {
+	t := &tracer{lines: lineNumbers(prg), emit: emit}
+	s := make(map[string]Val)
+	t.hooks().exec(prg, Path{}, s)
+	return s
+}
+
+type tracer struct {
+	lines map[string]int
+	emit  func(traceEvent)
+	depth int
+	// the values read by the statement being executed
+	read map[string]Val
+}
+
+func (t *tracer) event(kind string, n Node, p Path) traceEvent {
+	return traceEvent{kind: kind, path: p, line: lineOf(t.lines, p), node: n, depth: t.depth}
+}
+
+// returns the values of the variables an expression reads
+func reads(e Exp, s ValState) map[string]Val {
+	read := make(map[string]Val)
+	for x := range expVars(e) {
+		read[x] = Var(x).eval(s)
+	}
+	return read
+}
+
+// returns the hooks reporting the events of an evaluation
+func (t *tracer) hooks() *evalHooks {
+	return &evalHooks{
+		exp: func(e Exp, p Path, s ValState) {
+			// the values are read before a declaration or assignment changes them
+			t.read = reads(e, s)
+		},
+		done: func(stmt Stmt, p Path, s ValState, output string) {
+			e := t.event("stmt", stmt, p)
+			e.read, e.output = t.read, output
+			switch stmt := stmt.(type) {
+			case Decl:
+				e.written = map[string]Val{stmt.lhs: s[stmt.lhs]}
+			case Assign:
+				if output == "" {
+					e.written = map[string]Val{stmt.lhs: s[stmt.lhs]}
+				}
+			case Print:
+			default:
+				// if and while statements are reported by their cond, enter and exit events
+				return
+			}
+			t.emit(e)
+		},
+		cond: func(owner Stmt, p Path, s ValState, v Val, output string) {
+			e := t.event("cond", children(owner)[0], p.child(0))
+			e.read, e.value, e.output = t.read, v, output
+			t.emit(e)
+		},
+		enter: func(owner Stmt, p Path, s1, s2 ValState) {
+			t.depth++
+			t.emit(t.event("enter", owner, p))
+		},
+		update: t.update,
+		reset: func(while While, p Path, s2 ValState) {
+			t.emit(t.event("reset", while, p))
+		},
+		exit: func(owner Stmt, p Path, s1, s3 ValState) {
+			t.depth--
+			e := t.event("exit", owner, p)
+			if s3 != nil {
+				// the values copied to the enclosing scope
+				e.written = make(map[string]Val)
+				for k := range s1 {
+					if s3[k] != s1[k] {
+						e.written[k] = s3[k]
+					}
+				}
+			}
+			t.emit(e)
+		},
+	}
+}
+
+// reports the variables ValState.update drops or refuses when s1 is updated with s2
+func (t *tracer) update(owner Stmt, p Path, s1, s2 ValState) {
+	var names []string
+	for k := range s2 {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		outer, declared := s1[k]
+		switch {
+		case !declared:
+			e := t.event("drop", owner, p)
+			e.name, e.value = k, s2[k]
+			t.emit(e)
+		case outer.flag != s2[k].flag:
+			e := t.event("refuse", owner, p)
+			e.name, e.value, e.outer = k, s2[k], outer
+			t.emit(e)
+		}
+	}
+}
+
+// checks that tracing a program gives the same output and final state as Prog.eval
+// returns a description of the first difference, or an empty string
+func checkTrace(prg Prog) string {
+	wantOut, wantState := evalCaptured(prg)
+	var gotState ValState
+	gotOut := captureOutput(func() { gotState = traceEval(prg, func(traceEvent) {}) })
+	if gotOut != wantOut {
+		return fmt.Sprintf("output differs:\n--- eval\n%s\n--- trace\n%s", wantOut, gotOut)
+	}
+	if !sameState(gotState, wantState) {
+		return fmt.Sprintf("final state differs: eval %s, trace %s", showState(wantState), showState(gotState))
+	}
+	return ""
+}
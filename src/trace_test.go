@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTraceAgreesWithEval(t *testing.T) {
+	for name, prg := range testPrograms() {
+		if diff := checkTrace(prg); diff != "" {
+			t.Errorf("%s: %s", name, diff)
+		}
+	}
+}
+
+func TestTraceEvents(t *testing.T) {
+	var kinds []string
+	captureOutput(func() {
+		traceEval(failingLoop(), func(e traceEvent) { kinds = append(kinds, e.kind) })
+	})
+	want := "stmt enter cond stmt reset cond stmt reset cond exit stmt stmt"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Hoare-logic verification
+//
+// a specification annotates a program with a precondition (requires), a postcondition (ensures) and invariants
+// of its while loops. The precondition is about the inputs of the program, the declarations with literals (see
+// symbolic.go) it mentions or the specification lists; the postcondition is about the variables at the end of
+// the program, and an invariant about the variables whenever the condition of its loop is evaluated.
+//
+// verification computes the weakest precondition of the postcondition backwards through the program and has to
+// show that the precondition implies it. A loop contributes its invariant to the weakest precondition and two
+// conditions of its own: its body preserves the invariant, and the invariant together with the negated loop
+// condition implies what has to hold after the loop. A condition is valid if its negation is unsatisfiable (see
+// solver.go), so each one is proved, refuted with values of its variables, or unknown. Conditions of loops are
+// about all values of the variables, so a refuted one may be refuted by values no run of the program reaches:
+// its invariant is too weak then.
+//
+// the solver reasons about unbounded integers, while sums and products of the interpreter wrap around: x<(x+1)
+// is proved, though it is false for the largest integer. A condition with a sum or a product is therefore only
+// proved assuming that none of them overflows, which is reported with it. A counterexample is checked by
+// evaluating the condition, so a refutation holds either way.
+//
+// the kinds of values are known statically (see optimize.go), which keeps the scoping rules simple: a variable
+// of the formulas is a program variable together with its kind. Declaring x with another kind in a nested scope
+// introduces another variable, which leaves the one of the outer scope alone (ValState.update doesn't copy a
+// value of another kind back), while assigning x or declaring it with the same kind changes the outer one too.
+// Variables declared only in a nested scope can't be mentioned after it. A statement that fails (an assignment
+// of the wrong kind, a condition that isn't a boolean) leaves the state as it is.
+
+// the annotations of a program, read from a JSON file
+type hoareSpec struct {
+	Requires string `json:"requires,omitempty"`
+	Ensures  string `json:"ensures,omitempty"`
+	// the invariants of loops by their line or path, like "3" or "0.0.1.1"
+	Invariants map[string]string `json:"invariants,omitempty"`
+	// inputs the precondition doesn't mention
+	Inputs []string `json:"inputs,omitempty"`
+}
+
+func unmarshalSpec(data []byte) (*hoareSpec, error) {
+	var spec hoareSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+type verificationCondition struct {
+	// the line of the loop the condition is about, 0 for the condition of the program
+	line    int
+	desc    string
+	formula Exp
+	result  satResult
+	// values of the variables of the formula that make it false
+	counterexample ValState
+}
+
+type verifier struct {
+	lines map[string]int
+	// the inputs by the path of their declaration
+	inputs map[string]*symInput
+	// the invariants by the path of their loop, as given and about the variables of the formulas
+	annotations map[string]Exp
+	invariants  map[string]Exp
+	// the kinds of the variables of the formulas
+	kinds kindEnv
+	// the number of fresh variables so far
+	fresh int
+	vcs   []*verificationCondition
+}
+
+// returns the variable of the formulas standing for a program variable of a kind
+func (v *verifier) variable(x string, k Kind) Var {
+	name := x + ":int"
+	if k == ValueBool {
+		name = x + ":bool"
+	}
+	v.kinds[name] = 1 << k
+	return Var(name)
+}
+
+// returns the variable of the formulas standing for an input
+func (v *verifier) input(in *symInput) Var {
+	name := in.name + ":in"
+	v.kinds[name] = 1 << kindOfLiteral(in.lit)
+	return Var(name)
+}
+
+// returns the kind of an expression if it is the same for all values of the variables
+func staticKind(env kindEnv, e Exp) (Kind, bool) {
+	switch env.kinds(e) {
+	case kindInt:
+		return ValueInt, true
+	case kindBool:
+		return ValueBool, true
+	case kindUndef:
+		return Undefined, true
+	}
+	return Undefined, false
+}
+
+// returns the formula for a program expression of static kind, env holds the kinds of the program variables
+func (v *verifier) formula(e Exp, env kindEnv) (Exp, error) {
+	return translateExp(e, env, func(x string, k Kind) Exp { return v.variable(x, k) })
+}
+
+// translates a program expression of static kind into a formula, replacing each variable x, which has the
+// kind k in env, by variable(x, k)
+func translateExp(e Exp, env kindEnv, variable func(x string, k Kind) Exp) (Exp, error) {
+	if _, ok := staticKind(env, e); !ok {
+		return nil, fmt.Errorf("the kind of %s depends on the values of its variables", e.pretty())
+	}
+	switch e := e.(type) {
+	case Num, Bool:
+		return e, nil
+	case Var:
+		k, _ := staticKind(env, e)
+		if k == Undefined {
+			return nil, fmt.Errorf("%s is undefined", string(e))
+		}
+		return variable(string(e), k), nil
+	case Group:
+		return translateExp(e[0], env, variable)
+	case And:
+		// a right side that isn't a boolean is never evaluated, else the result had several kinds
+		if !env.always(e[1], kindBool) {
+			return translateExp(e[0], env, variable)
+		}
+	case Or:
+		if !env.always(e[1], kindBool) {
+			return translateExp(e[0], env, variable)
+		}
+	}
+	cs := children(e)
+	translated := make([]Node, len(cs))
+	for i, c := range cs {
+		f, err := translateExp(c.(Exp), env, variable)
+		if err != nil {
+			return nil, err
+		}
+		translated[i] = f
+	}
+	return withChildren(e, translated).(Exp), nil
+}
+
+// replaces a variable of a formula by an expression
+func substitute(e Exp, x Var, by Exp) Exp {
+	return rewriteExps(e, func(e Exp) Exp {
+		if e == x {
+			return by
+		}
+		return e
+	}).(Exp)
+}
+
+func implies(a, b Exp) Exp {
+	return Or{Negation{a}, b}
+}
+
+// returns the kinds of the variables after a statement of the same scope
+func kindsAfter(s Stmt, env kindEnv) kindEnv {
+	switch s := s.(type) {
+	case Prog:
+		return kindsAfter(s[0][0], env)
+	case Seq:
+		return kindsAfter(s[1], kindsAfter(s[0], env))
+	case Decl:
+		env = env.copy()
+		env[s.lhs] = env.kinds(s.rhs)
+		return env
+	}
+	// nested scopes change no kinds
+	return env
+}
+
+// returns the weakest precondition of a statement at a path for a postcondition, env holds the kinds of the
+// program variables before the statement
+func (v *verifier) wp(s Stmt, p Path, env kindEnv, post Exp) (Exp, error) {
+	line := lineOf(v.lines, p)
+	fail := func(err error) (Exp, error) {
+		return nil, v.errorAt(p, err)
+	}
+	var pre Exp
+	switch s := s.(type) {
+	case Prog:
+		return v.wp(s[0], p.child(0), env, post)
+	case Block:
+		return v.wp(s[0], p.child(0), env, post)
+	case Seq:
+		mid, err := v.wp(s[1], p.child(1), kindsAfter(s[0], env), post)
+		if err != nil {
+			return nil, err
+		}
+		return v.wp(s[0], p.child(0), env, mid)
+	case Print:
+		pre = post
+	case Decl:
+		if in, ok := v.inputs[p.String()]; ok {
+			pre = substitute(post, v.variable(s.lhs, kindOfLiteral(in.lit)), v.input(in))
+			break
+		}
+		k, ok := staticKind(env, s.rhs)
+		if !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.rhs.pretty()))
+		}
+		if k == Undefined {
+			// the formulas have no variable for an undefined value
+			pre = post
+			break
+		}
+		rhs, err := v.formula(s.rhs, env)
+		if err != nil {
+			return fail(err)
+		}
+		pre = substitute(post, v.variable(s.lhs, k), rhs)
+	case Assign:
+		k, ok := staticKind(env, s.rhs)
+		if !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.rhs.pretty()))
+		}
+		if old, _ := staticKind(env, Var(s.lhs)); k != old || k == Undefined {
+			// the assignment fails (or assigns an undefined value to an undefined variable)
+			pre = post
+			break
+		}
+		rhs, err := v.formula(s.rhs, env)
+		if err != nil {
+			return fail(err)
+		}
+		pre = substitute(post, v.variable(s.lhs, k), rhs)
+	case IfThenElse:
+		if k, ok := staticKind(env, s.cond); !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.cond.pretty()))
+		} else if k != ValueBool {
+			pre = post
+			break
+		}
+		cond, err := v.formula(s.cond, env)
+		if err != nil {
+			return fail(err)
+		}
+		pt, err := v.nested(s.thenBl, p.child(1), env, post)
+		if err != nil {
+			return nil, err
+		}
+		pe, err := v.nested(s.elseBl, p.child(2), env, post)
+		if err != nil {
+			return nil, err
+		}
+		pre = And{implies(cond, pt), implies(Negation{cond}, pe)}
+	case While:
+		if k, ok := staticKind(env, s.cond); !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.cond.pretty()))
+		} else if k != ValueBool {
+			pre = post
+			break
+		}
+		cond, err := v.formula(s.cond, env)
+		if err != nil {
+			return fail(err)
+		}
+		inv, ok := v.invariants[p.String()]
+		what := "the invariant true (none given)"
+		if ok {
+			what = "the invariant " + v.annotations[p.String()].pretty()
+		} else {
+			inv = Bool(true)
+		}
+		body, err := v.nested(s.do, p.child(1), env, inv)
+		if err != nil {
+			return nil, err
+		}
+		v.condition(line, fmt.Sprintf("line %d: %s of %s is preserved by its body", line, what, headline(s)),
+			implies(And{inv, cond}, body))
+		v.condition(line, fmt.Sprintf("line %d: %s and the negated condition of %s imply what follows", line, what, headline(s)),
+			implies(And{inv, Negation{cond}}, post))
+		pre = inv
+	}
+	return optimizeExp(pre, v.kinds), nil
+}
+
+// returns an error at a path, with its line if the statement is a program
+func (v *verifier) errorAt(p Path, err error) error {
+	if line := lineOf(v.lines, p); line != 0 {
+		return fmt.Errorf("line %d: %s", line, err)
+	}
+	return err
+}
+
+// returns the weakest precondition of a block with a nested scope
+// a variable of the enclosing scope that has another kind at the end of the block keeps its value, whatever
+// the block did to it before declaring it with the other kind: it is saved in a fresh variable before the block
+// and restored after it
+func (v *verifier) nested(b Block, p Path, env kindEnv, post Exp) (Exp, error) {
+	after := kindsAfter(b[0], env)
+	saved := make(map[Var]Var)
+	for x, ks := range env {
+		k, ok := staticKind(env, Var(x))
+		if !ok || k == Undefined || after[x] == ks {
+			continue
+		}
+		outer := v.variable(x, k)
+		v.fresh++
+		s := Var(fmt.Sprintf("%s#%d", outer, v.fresh))
+		v.kinds[string(s)] = ks
+		saved[outer] = s
+		post = substitute(post, outer, s)
+	}
+	pre, err := v.wp(b, p, env.copy(), post)
+	if err != nil {
+		return nil, err
+	}
+	// the fresh variables stay in the conditions of loops in the block, which hold for all of their values
+	for outer, s := range saved {
+		pre = substitute(pre, s, outer)
+	}
+	return pre, nil
+}
+
+func (v *verifier) condition(line int, desc string, formula Exp) {
+	v.vcs = append(v.vcs, &verificationCondition{line: line, desc: desc, formula: optimizeExp(formula, v.kinds)})
+}
+
+// returns the verification conditions of a program for a specification, proved, refuted or unknown
+func verify(prg Prog, spec *hoareSpec) ([]*verificationCondition, error) {
+	parse := func(what, src string) (Exp, error) {
+		if src == "" {
+			return Bool(true), nil
+		}
+		e, err := parseExp(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %s", what, src, err)
+		}
+		return e, nil
+	}
+	requires, err := parse("requires", spec.Requires)
+	if err != nil {
+		return nil, err
+	}
+	ensures, err := parse("ensures", spec.Ensures)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{lines: lineNumbers(prg), inputs: make(map[string]*symInput), annotations: make(map[string]Exp), invariants: make(map[string]Exp), kinds: make(kindEnv)}
+
+	// the inputs are those the precondition mentions and those listed
+	wanted := make(map[string]bool)
+	for _, x := range spec.Inputs {
+		wanted[x] = true
+	}
+	walkPre(requires, func(n Node) bool {
+		if x, ok := n.(Var); ok {
+			wanted[string(x)] = true
+		}
+		return true
+	})
+	inputEnv := make(kindEnv)
+	var names []string
+	for _, in := range symInputs(prg, nil) {
+		names = append(names, in.name)
+		if wanted[in.name] {
+			v.inputs[in.path.String()] = in
+			inputEnv[in.name] = 1 << kindOfLiteral(in.lit)
+			delete(wanted, in.name)
+		}
+	}
+	var missing []string
+	for x := range wanted {
+		missing = append(missing, x)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%s isn't an input (a declaration with a literal), the inputs are %s", missing[0], strings.Join(names, ", "))
+	}
+	pre, err := v.formula(requires, inputEnv)
+	if err == nil && !inputEnv.always(requires, kindBool) {
+		err = fmt.Errorf("it isn't a condition")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("requires %s: %s", requires.pretty(), err)
+	}
+	// the formula has variables for program variables, which are renamed to inputs
+	for _, in := range v.inputs {
+		x := v.variable(in.name, kindOfLiteral(in.lit))
+		pre = substitute(pre, x, v.input(in))
+		delete(v.kinds, string(x))
+	}
+
+	// the invariants are about the variables before their loops
+	loops := make(map[string]Path)
+	var envErr error
+	var walk func(s Stmt, p Path, env kindEnv)
+	walk = func(s Stmt, p Path, env kindEnv) {
+		switch s := s.(type) {
+		case Prog:
+			walk(s[0], p.child(0), env)
+		case Block:
+			walk(s[0], p.child(0), env.copy())
+		case Seq:
+			walk(s[0], p.child(0), env)
+			walk(s[1], p.child(1), kindsAfter(s[0], env))
+		case IfThenElse:
+			walk(s.thenBl, p.child(1), env)
+			walk(s.elseBl, p.child(2), env)
+		case While:
+			loops[p.String()] = p
+			loops[strconv.Itoa(lineOf(v.lines, p))] = p
+			src, ok := spec.Invariants[p.String()]
+			if !ok {
+				src, ok = spec.Invariants[strconv.Itoa(lineOf(v.lines, p))]
+			}
+			if ok && envErr == nil {
+				annotation, err := parse("invariant", src)
+				var inv Exp
+				if err == nil {
+					inv, err = v.formula(annotation, env)
+				}
+				if err == nil && !env.always(annotation, kindBool) {
+					err = fmt.Errorf("it isn't a condition")
+				}
+				if err != nil {
+					envErr = fmt.Errorf("invariant %s: %s", src, err)
+				}
+				v.annotations[p.String()], v.invariants[p.String()] = annotation, inv
+			}
+			walk(s.do, p.child(1), env)
+		}
+	}
+	walk(prg, Path{}, make(kindEnv))
+	if envErr != nil {
+		return nil, envErr
+	}
+	for _, key := range spec.invariantKeys() {
+		if _, ok := loops[key]; !ok {
+			return nil, fmt.Errorf("invariant %s: there is no while loop at %s", spec.Invariants[key], key)
+		}
+	}
+
+	end := kindsAfter(prg, make(kindEnv))
+	post, err := v.formula(ensures, end)
+	if err == nil && !end.always(ensures, kindBool) {
+		err = fmt.Errorf("it isn't a condition")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ensures %s: %s", ensures.pretty(), err)
+	}
+	wp, err := v.wp(prg, Path{}, make(kindEnv), post)
+	if err != nil {
+		return nil, err
+	}
+	// the program starts with no variables, so the precondition of the program is about the inputs only
+	v.condition(0, "the precondition implies the weakest precondition of the program", implies(pre, wp))
+	// the conditions were found backwards
+	sort.SliceStable(v.vcs, func(i, j int) bool { return v.vcs[i].line < v.vcs[j].line })
+
+	for _, vc := range v.vcs {
+		vc.result, vc.counterexample = solve([]Exp{Negation{vc.formula}}, v.kinds)
+		v.rename(vc)
+	}
+	return v.vcs, nil
+}
+
+// renames the variables of a condition and its counterexample for showing them: a program variable is shown by
+// its name, followed by its kind if it has several (a saved value by the name and a number after #), and an
+// input by its name, followed by ₀ if a program variable of the condition has the same name
+func (v *verifier) rename(vc *verificationCondition) {
+	// splits a name like x:int#2 into x, int and #2
+	split := func(name string) (string, string, string) {
+		i := strings.LastIndex(name, ":")
+		kind, saved := name[i+1:], ""
+		if j := strings.Index(kind, "#"); j >= 0 {
+			kind, saved = kind[:j], kind[j:]
+		}
+		return name[:i], kind, saved
+	}
+	kinds := make(map[string]map[string]bool)
+	for name := range v.kinds {
+		if x, k, _ := split(name); k != "in" {
+			if kinds[x] == nil {
+				kinds[x] = make(map[string]bool)
+			}
+			kinds[x][k] = true
+		}
+	}
+	names := make(map[string]string)
+	shown := make(map[string]bool)
+	walkPre(vc.formula, func(n Node) bool {
+		if x, ok := n.(Var); ok {
+			if base, k, saved := split(string(x)); k != "in" {
+				names[string(x)] = base + saved
+				if len(kinds[base]) > 1 {
+					names[string(x)] = base + ":" + k + saved
+				}
+				shown[names[string(x)]] = true
+			}
+		}
+		return true
+	})
+	walkPre(vc.formula, func(n Node) bool {
+		if x, ok := n.(Var); ok {
+			if base, k, _ := split(string(x)); k == "in" {
+				names[string(x)] = base
+				if shown[base] {
+					names[string(x)] = base + "₀"
+				}
+			}
+		}
+		return true
+	})
+	vc.formula = rewriteExps(vc.formula, func(e Exp) Exp {
+		if x, ok := e.(Var); ok {
+			return Var(names[string(x)])
+		}
+		return e
+	}).(Exp)
+	if vc.counterexample != nil {
+		renamed := make(ValState)
+		for x, val := range vc.counterexample {
+			if n, ok := names[x]; ok {
+				renamed[n] = val
+			}
+		}
+		vc.counterexample = renamed
+	}
+}
+
+// returns the results of verification, with the formulas of the conditions if showFormulas is set
+func showVerification(vcs []*verificationCondition, showFormulas bool) string {
+	var b strings.Builder
+	for _, vc := range vcs {
+		res := "proved"
+		switch vc.result {
+		case sat:
+			res = "refuted"
+		case unknown:
+			res = "unknown"
+		}
+		if vc.result == unsat && hasArithmetic(vc.formula) {
+			fmt.Fprintf(&b, "%-8s %s, assuming no overflow\n", res, vc.desc)
+		} else {
+			fmt.Fprintf(&b, "%-8s %s\n", res, vc.desc)
+		}
+		if showFormulas {
+			fmt.Fprintf(&b, "         condition: %s\n", vc.formula.pretty())
+		}
+		if vc.result == sat {
+			fmt.Fprintf(&b, "         counterexample: %s\n", showState(vc.counterexample))
+		}
+	}
+	return b.String()
+}
+
+// returns whether a node has a sum or a product, which can overflow
+func hasArithmetic(n Node) bool {
+	found := false
+	walkPre(n, func(n Node) bool {
+		switch n.(type) {
+		case Plus, Mult:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// returns how many conditions are refuted and how many are unknown
+func verificationFailures(vcs []*verificationCondition) (int, int) {
+	refuted, unknowns := 0, 0
+	for _, vc := range vcs {
+		switch vc.result {
+		case sat:
+			refuted++
+		case unknown:
+			unknowns++
+		}
+	}
+	return refuted, unknowns
+}
+
+// returns the keys of a specification's invariants, sorted
+func (spec *hoareSpec) invariantKeys() []string {
+	var keys []string
+	for k := range spec.Invariants {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
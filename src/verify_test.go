@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+// x<(x+1) is proved for unbounded integers only, which the result says
+func TestVerifyAssumesNoOverflow(t *testing.T) {
+	prg := generateProg([]Stmt{declaration("x", number(1)), declaration("y", plus(variable("x"), number(1)))})
+	tests := map[string]string{
+		"x<y": "proved   the precondition implies the weakest precondition of the program, assuming no overflow\n",
+		"0<x": "proved   the precondition implies the weakest precondition of the program\n",
+	}
+	for ensures, want := range tests {
+		vcs, err := verify(prg, &hoareSpec{Requires: "0<x", Ensures: ensures})
+		if err != nil {
+			t.Fatalf("%s: %s", ensures, err)
+		}
+		if got := showVerification(vcs, false); got != want {
+			t.Errorf("%s: got\n%s", ensures, got)
+		}
+	}
+}
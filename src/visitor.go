@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// generic traversal and rewriting of abstract syntax trees
+// new analyses and transformations can be written with these functions,
+// without adding methods to every expression and statement
+
+// a node of an abstract syntax tree is either an expression (Exp) or a statement (Stmt)
+type Node interface {
+	pretty() string
+}
+
+// returns the children of a node, in evaluation order
+func children(n Node) []Node {
+	switch n := n.(type) {
+	case Prog:
+		return []Node{n[0]}
+	case Block:
+		return []Node{n[0]}
+	case Seq:
+		return []Node{n[0], n[1]}
+	case Decl:
+		return []Node{n.rhs}
+	case Assign:
+		return []Node{n.rhs}
+	case While:
+		return []Node{n.cond, n.do}
+	case IfThenElse:
+		return []Node{n.cond, n.thenBl, n.elseBl}
+	case Print:
+		return []Node{n.printExp}
+	case Plus:
+		return []Node{n[0], n[1]}
+	case Mult:
+		return []Node{n[0], n[1]}
+	case Or:
+		return []Node{n[0], n[1]}
+	case And:
+		return []Node{n[0], n[1]}
+	case Equal:
+		return []Node{n[0], n[1]}
+	case Lesser:
+		return []Node{n[0], n[1]}
+	case Negation:
+		return []Node{n[0]}
+	case Group:
+		return []Node{n[0]}
+	case Num, Bool, Var:
+		return nil
+	}
+	panic(fmt.Sprintf("children: unknown node %T", n))
+}
+
+// returns a copy of a node with its children replaced
+// children in block positions (e.g. the do block of a while) may be any statement, which is wrapped into a block
+func withChildren(n Node, cs []Node) Node {
+	if len(cs) != len(children(n)) {
+		panic(fmt.Sprintf("withChildren: %T needs %d children, got %d", n, len(children(n)), len(cs)))
+	}
+	switch n := n.(type) {
+	case Prog:
+		return Prog{toBlock(cs[0])}
+	case Block:
+		return Block{toStmt(cs[0])}
+	case Seq:
+		return Seq{toStmt(cs[0]), toStmt(cs[1])}
+	case Decl:
+		return Decl{n.lhs, toExp(cs[0])}
+	case Assign:
+		return Assign{n.lhs, toExp(cs[0])}
+	case While:
+		return While{toExp(cs[0]), toBlock(cs[1])}
+	case IfThenElse:
+		return IfThenElse{toExp(cs[0]), toBlock(cs[1]), toBlock(cs[2])}
+	case Print:
+		return Print{toExp(cs[0])}
+	case Plus:
+		return Plus{toExp(cs[0]), toExp(cs[1])}
+	case Mult:
+		return Mult{toExp(cs[0]), toExp(cs[1])}
+	case Or:
+		return Or{toExp(cs[0]), toExp(cs[1])}
+	case And:
+		return And{toExp(cs[0]), toExp(cs[1])}
+	case Equal:
+		return Equal{toExp(cs[0]), toExp(cs[1])}
+	case Lesser:
+		return Lesser{toExp(cs[0]), toExp(cs[1])}
+	case Negation:
+		return Negation{toExp(cs[0])}
+	case Group:
+		return Group{toExp(cs[0])}
+	}
+	return n
+}
+
+func toExp(n Node) Exp {
+	if e, ok := n.(Exp); ok {
+		return e
+	}
+	panic(fmt.Sprintf("expected an expression, got %T", n))
+}
+func toStmt(n Node) Stmt {
+	if s, ok := n.(Stmt); ok {
+		return s
+	}
+	panic(fmt.Sprintf("expected a statement, got %T", n))
+}
+func toBlock(n Node) Block {
+	if b, ok := n.(Block); ok {
+		return b
+	}
+	return Block{toStmt(n)}
+}
+
+// visits all nodes in pre-order (parents before their children)
+// if f returns false, the children of the node are skipped
+func walkPre(n Node, f func(Node) bool) {
+	if !f(n) {
+		return
+	}
+	for _, c := range children(n) {
+		walkPre(c, f)
+	}
+}
+
+// visits all nodes in post-order (children before their parents)
+func walkPost(n Node, f func(Node)) {
+	for _, c := range children(n) {
+		walkPost(c, f)
+	}
+	f(n)
+}
+
+// rebuilds a tree bottom-up: the children of a node are rewritten first,
+// then f is applied to the node rebuilt from the rewritten children
+func rewrite(n Node, f func(Node) Node) Node {
+	cs := children(n)
+	if len(cs) > 0 {
+		rewritten := make([]Node, len(cs))
+		for i, c := range cs {
+			rewritten[i] = rewrite(c, f)
+		}
+		n = withChildren(n, rewritten)
+	}
+	return f(n)
+}
+
+// rewrites all expressions of a tree bottom-up and leaves the statements as they are
+func rewriteExps(n Node, f func(Exp) Exp) Node {
+	return rewrite(n, func(n Node) Node {
+		if e, ok := n.(Exp); ok {
+			return f(e)
+		}
+		return n
+	})
+}
+
+// a path addresses a node by the indices of the children leading to it from the root
+// e.g. the path 0.0.1 of a program is its main block's statement's second child
+type Path []int
+
+// returns the path as string, the root is "."
+func (p Path) String() string {
+	if len(p) == 0 {
+		return "."
+	}
+	parts := make([]string, len(p))
+	for i, x := range p {
+		parts[i] = strconv.Itoa(x)
+	}
+	return strings.Join(parts, ".")
+}
+
+// parses a path as returned by Path.String
+func parsePath(s string) (Path, error) {
+	if s == "." || s == "" {
+		return Path{}, nil
+	}
+	var p Path
+	for _, part := range strings.Split(s, ".") {
+		x, err := strconv.Atoi(part)
+		if err != nil || x < 0 {
+			return nil, fmt.Errorf("invalid path %q", s)
+		}
+		p = append(p, x)
+	}
+	return p, nil
+}
+
+// returns the path extended by a child index (without sharing memory with p)
+func (p Path) child(i int) Path {
+	q := make(Path, len(p)+1)
+	copy(q, p)
+	q[len(p)] = i
+	return q
+}
+
+// visits all nodes in pre-order together with their paths
+// if f returns false, the children of the node are skipped
+func walkPaths(n Node, f func(Node, Path) bool) {
+	var walk func(n Node, p Path)
+	walk = func(n Node, p Path) {
+		if !f(n, p) {
+			return
+		}
+		for i, c := range children(n) {
+			walk(c, p.child(i))
+		}
+	}
+	walk(n, Path{})
+}
+
+// returns the node at a path
+func nodeAt(root Node, p Path) (Node, error) {
+	n := root
+	for i, x := range p {
+		cs := children(n)
+		if x >= len(cs) {
+			return nil, fmt.Errorf("path %s: %T at %s has no child %d", p, n, p[:i], x)
+		}
+		n = cs[x]
+	}
+	return n, nil
+}
+
+// returns a copy of the tree with the node at a path replaced
+func replaceAt(root Node, p Path, replacement Node) (Node, error) {
+	if len(p) == 0 {
+		return replacement, nil
+	}
+	cs := children(root)
+	if p[0] >= len(cs) {
+		return nil, fmt.Errorf("%T has no child %d", root, p[0])
+	}
+	c, err := replaceAt(cs[p[0]], p[1:], replacement)
+	if err != nil {
+		return nil, err
+	}
+	replaced := append([]Node{}, cs...)
+	replaced[p[0]] = c
+	return withChildren(root, replaced), nil
+}
+
+// returns whether a path comes before another one in pre-order
+func (p Path) less(q Path) bool {
+	for i := 0; i < len(p) && i < len(q); i++ {
+		if p[i] != q[i] {
+			return p[i] < q[i]
+		}
+	}
+	return len(p) < len(q)
+}
+
+// returns whether a path is the path q or below it
+func (p Path) within(q Path) bool {
+	if len(p) < len(q) {
+		return false
+	}
+	for i := range q {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// returns a one-line description of a node, compound statements are shortened to their head
+func headline(n Node) string {
+	switch n := n.(type) {
+	case Prog:
+		return "program"
+	case Block:
+		return "block"
+	case Seq:
+		return headline(n[0]) + "; ..."
+	case While:
+		return "while " + n.cond.pretty()
+	case IfThenElse:
+		return "if " + n.cond.pretty()
+	}
+	return n.pretty()
+}
+
+// returns the line of every statement in the pretty printed program, keyed by path
+// (lines are numbered from 1, a while or if statement is on the line of its condition)
+func lineNumbers(prg Prog) map[string]int {
+	lines := make(map[string]int)
+	var assign func(s Stmt, p Path, line int)
+	assign = func(s Stmt, p Path, line int) {
+		lines[p.String()] = line
+		switch s := s.(type) {
+		case Prog:
+			assign(s[0], p.child(0), line)
+		case Block:
+			assign(s[0], p.child(0), line+1)
+		case Seq:
+			assign(s[0], p.child(0), line)
+			assign(s[1], p.child(1), line+lineCount(s[0]))
+		case While:
+			// the block starts on the line of the condition
+			assign(s.do, p.child(1), line)
+		case IfThenElse:
+			// the else block starts on the line closing the then block
+			assign(s.thenBl, p.child(1), line)
+			assign(s.elseBl, p.child(2), line+lineCount(s.thenBl)-1)
+		}
+	}
+	assign(prg, Path{}, 1)
+	return lines
+}
+
+// returns the number of lines of a pretty printed statement
+func lineCount(s Stmt) int {
+	return strings.Count(s.pretty(), "\n") + 1
+}
+
+// rebuilds a tree bottom-up like rewrite, f also gets the path of each node in the original tree
+func rewritePaths(n Node, f func(Node, Path) Node) Node {
+	var rw func(n Node, p Path) Node
+	rw = func(n Node, p Path) Node {
+		cs := children(n)
+		if len(cs) > 0 {
+			rewritten := make([]Node, len(cs))
+			for i, c := range cs {
+				rewritten[i] = rw(c, p.child(i))
+			}
+			n = withChildren(n, rewritten)
+		}
+		return f(n, p)
+	}
+	return rw(n, Path{})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// a program with every kind of node
+func allNodes() Prog {
+	l01 := declaration("x", plus(number(1), mult(number(2), group(variable("y")))))
+	l02 := assignment("x", number(3))
+	body := block(generateSeq([]Stmt{sPrint(negation(boolean(true))), assignment("x", plus(variable("x"), number(1)))}))
+	l03 := while(or(lesser(variable("x"), number(5)), and(boolean(false), equal(variable("x"), number(0)))), body)
+	l04 := ifthenelse(boolean(true), block(sPrint(variable("x"))), block(sPrint(number(0))))
+	return generateProg([]Stmt{l01, l02, l03, l04})
+}
+
+func nodeKinds(n Node) []string {
+	var kinds []string
+	walkPost(n, func(n Node) { kinds = append(kinds, fmt.Sprintf("%T", n)) })
+	return kinds
+}
+
+func TestAllNodesHasEveryKind(t *testing.T) {
+	kinds := make(map[string]bool)
+	for _, k := range nodeKinds(allNodes()) {
+		kinds[k] = true
+	}
+	var got []string
+	for k := range kinds {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	// the kinds children knows about
+	want := []string{"main.And", "main.Assign", "main.Block", "main.Bool", "main.Decl", "main.Equal", "main.Group",
+		"main.IfThenElse", "main.Lesser", "main.Mult", "main.Negation", "main.Num", "main.Or", "main.Plus", "main.Print",
+		"main.Prog", "main.Seq", "main.Var", "main.While"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kinds %v, want %v", got, want)
+	}
+}
+
+func TestRewriteRebuildsEveryNode(t *testing.T) {
+	prgs := testPrograms()
+	prgs["allNodes"] = allNodes()
+	for name, prg := range prgs {
+		// f sees every node once, after its children, as a node of the same kind
+		var seen []string
+		got := rewrite(prg, func(n Node) Node {
+			seen = append(seen, fmt.Sprintf("%T", n))
+			return n
+		})
+		if !reflect.DeepEqual(got, Node(prg)) {
+			t.Errorf("%s: the identity rewrite gives\n%s", name, got.pretty())
+		}
+		if want := nodeKinds(prg); !reflect.DeepEqual(seen, want) {
+			t.Errorf("%s: rewrite visits %v instead of %v", name, seen, want)
+		}
+	}
+
+	// replacing the literals rebuilds every node above them
+	got := rewriteExps(allNodes(), func(e Exp) Exp {
+		if n, ok := e.(Num); ok {
+			return Num(int(n) * 10)
+		}
+		return e
+	})
+	want := "{\nx := (10+(20*(y)));\nx = 30;\nwhile ((x<50) || (false && (x==0))){\nprint (!true);\nx = (x+10)\n};\nif true{\nprint x\n} else {\nprint 0\n}\n}"
+	if got.pretty() != want {
+		t.Errorf("rewriteExps gives\n%s\nwant\n%s", got.pretty(), want)
+	}
+}
+
+func TestWithChildrenWrapsBlocks(t *testing.T) {
+	w := withChildren(While{boolean(true), block(sPrint(number(1)))}, []Node{boolean(false), sPrint(number(2))})
+	if want := (While{boolean(false), Block{sPrint(number(2))}}); !reflect.DeepEqual(w, want) {
+		t.Errorf("got %s, want %s", w.pretty(), want.pretty())
+	}
+}
+
+func TestReplaceAt(t *testing.T) {
+	prg := allNodes()
+	walkPaths(prg, func(n Node, p Path) bool {
+		var marker Node = Var("marker")
+		if _, ok := n.(Stmt); ok {
+			marker = sPrint(Var("marker"))
+		}
+		replaced, err := replaceAt(prg, p, marker)
+		if err != nil {
+			t.Errorf("%s: %s", p, err)
+			return true
+		}
+		if got, _ := nodeAt(replaced, p); !reflect.DeepEqual(got, marker) && !reflect.DeepEqual(got, Block{marker.(Stmt)}) {
+			t.Errorf("%s: %s was replaced by %s", p, n.pretty(), got.pretty())
+		}
+		if same, _ := replaceAt(prg, p, n); !reflect.DeepEqual(same, Node(prg)) {
+			t.Errorf("%s: replacing a node by itself changes the program", p)
+		}
+		return true
+	})
+	if _, err := replaceAt(prg, Path{0, 0, 5}, Var("x")); err == nil {
+		t.Errorf("a path without a node was accepted")
+	}
+}
+
+func TestPaths(t *testing.T) {
+	for _, s := range []string{".", "0", "0.0.1.2"} {
+		p, err := parsePath(s)
+		if err != nil || p.String() != s {
+			t.Errorf("%s: parsed as %v, %v", s, p, err)
+		}
+	}
+	for _, s := range []string{"0..1", "a", "-1"} {
+		if _, err := parsePath(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+	p, q := Path{0, 1}, Path{0, 1, 2}
+	if !p.less(q) || q.less(p) || !(Path{0, 0, 5}).less(p) {
+		t.Errorf("less doesn't follow pre-order")
+	}
+	if !q.within(p) || p.within(q) || !p.within(p) || (Path{0, 2}).within(p) {
+		t.Errorf("within is wrong")
+	}
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// weakest preconditions and strongest postconditions
+//
+// WP and SP work on statements with conditions given as expressions, for tools that want the formulas themselves
+// rather than a verdict (see verify.go, whose machinery they share). The variables a statement reads before
+// declaring them, and those of the given condition, are taken to be declared before the statement, with the
+// kinds their uses suggest.
+//
+// a program starts without variables, so the weakest precondition of a program is true or false.
+//
+// the formulas follow the scoping rules: a variable declared in a nested scope, or declared there with another
+// kind than outside, is another variable than the one outside, and a variable that changes its kind in a block
+// keeps its value from before the block. SP introduces a fresh variable for an old value it can't express
+// otherwise (the value a variable had before an assignment, or a variable of a scope that ended): such a
+// variable stands for some value, it is existentially quantified. Fresh variables get names no variable of the
+// statement or the condition has, so they never capture one.
+//
+// a while loop needs an invariant, given by the path of the loop in the statement (two loops with the same
+// code are different loops). WP of a loop is its invariant and SP the invariant with the negated loop
+// condition; these are the weakest precondition and the strongest postcondition only as far as the invariant
+// is preserved by the body, which imp verify proves.
+
+// the invariant of the while loop at a path of a statement (see nodeAt)
+type Invariant struct {
+	Loop Path
+	Inv  Exp
+}
+
+// returns the weakest precondition of a statement for a postcondition
+// returns an error if a loop has no invariant, or a condition or the kind of a value can't be expressed
+func WP(stmt Stmt, post Exp, invariants ...Invariant) (Exp, error) {
+	v, env, err := newPredicateVerifier(stmt, post, invariants)
+	if err != nil {
+		return nil, err
+	}
+	q, err := v.condFormula(post, kindsAfter(stmt, env))
+	if b, ok := stmt.(Block); ok && err == nil {
+		// a block on its own is a nested scope
+		q, err = v.nested(b, Path{}, env, q)
+	} else if err == nil {
+		q, err = v.wp(stmt, Path{}, env, q)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.names(v.simplifyFormula(q), stmt, post), nil
+}
+
+// returns the strongest postcondition of a statement for a precondition
+// returns an error if a loop has no invariant, or a condition or the kind of a value can't be expressed
+func SP(pre Exp, stmt Stmt, invariants ...Invariant) (Exp, error) {
+	v, env, err := newPredicateVerifier(stmt, pre, invariants)
+	if err != nil {
+		return nil, err
+	}
+	p, err := v.condFormula(pre, env)
+	if b, ok := stmt.(Block); ok && err == nil {
+		p, err = v.spNested(p, b, Path{}, env)
+	} else if err == nil {
+		p, err = v.sp(p, stmt, Path{}, env)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.names(v.simplifyFormula(p), stmt, pre), nil
+}
+
+// returns a verifier for a statement with the invariants of its loops, and the kinds of the variables before it
+func newPredicateVerifier(stmt Stmt, cond Exp, invariants []Invariant) (*verifier, kindEnv, error) {
+	v := &verifier{inputs: make(map[string]*symInput), annotations: make(map[string]Exp), invariants: make(map[string]Exp), kinds: make(kindEnv)}
+	if p, ok := stmt.(Prog); ok {
+		v.lines = lineNumbers(p)
+	}
+	env := make(kindEnv)
+	if _, ok := stmt.(Prog); ok {
+		// a program starts without variables
+		return v, env, v.loopInvariants(stmt, env, invariants)
+	}
+	free, exps := freeVars(stmt, cond)
+	inferred := inferKinds(exps)
+	for _, x := range free {
+		if k, ok := inferred[x]; ok {
+			env[x] = k
+		} else {
+			// a variable whose uses don't tell (like x in x := x) is taken to be an integer
+			env[x] = kindInt
+		}
+	}
+	return v, env, v.loopInvariants(stmt, env, invariants)
+}
+
+// finds the invariants of the loops of a statement, env holds the kinds of the variables before it
+func (v *verifier) loopInvariants(stmt Stmt, env kindEnv, invariants []Invariant) error {
+	var loopErr error
+	var walk func(s Stmt, p Path, env kindEnv)
+	walk = func(s Stmt, p Path, env kindEnv) {
+		switch s := s.(type) {
+		case Prog:
+			walk(s[0], p.child(0), env)
+		case Block:
+			walk(s[0], p.child(0), env.copy())
+		case Seq:
+			walk(s[0], p.child(0), env)
+			walk(s[1], p.child(1), kindsAfter(s[0], env))
+		case IfThenElse:
+			walk(s.thenBl, p.child(1), env)
+			walk(s.elseBl, p.child(2), env)
+		case While:
+			found := false
+			for _, inv := range invariants {
+				if inv.Loop.String() == p.String() {
+					f, err := v.condFormula(inv.Inv, env)
+					if err != nil && loopErr == nil {
+						loopErr = fmt.Errorf("invariant %s: %s", inv.Inv.pretty(), err)
+					}
+					v.annotations[p.String()], v.invariants[p.String()] = inv.Inv, f
+					found = true
+				}
+			}
+			if !found && loopErr == nil {
+				loopErr = fmt.Errorf("%s has no invariant", headline(s))
+			}
+			walk(s.do, p.child(1), env)
+		}
+	}
+	walk(stmt, Path{}, env)
+	for _, inv := range invariants {
+		if _, ok := v.invariants[inv.Loop.String()]; !ok && loopErr == nil {
+			loopErr = fmt.Errorf("invariant %s: there is no while loop at %s", inv.Inv.pretty(), inv.Loop)
+		}
+	}
+	return loopErr
+}
+
+// returns the variables of a condition and those a statement reads before declaring them, with the
+// expressions reading them
+func freeVars(stmt Stmt, cond Exp) ([]string, []Exp) {
+	free := make(map[string]bool)
+	var exps []Exp
+	read := func(e Exp, declared map[string]bool) {
+		found := false
+		walkPre(e, func(n Node) bool {
+			if x, ok := n.(Var); ok && !declared[string(x)] {
+				free[string(x)] = true
+				found = true
+			}
+			return true
+		})
+		if found {
+			exps = append(exps, e)
+		}
+	}
+	read(cond, nil)
+	var walk func(s Stmt, declared map[string]bool)
+	walk = func(s Stmt, declared map[string]bool) {
+		nested := func(b Block) {
+			inner := make(map[string]bool)
+			for x := range declared {
+				inner[x] = true
+			}
+			walk(b, inner)
+		}
+		switch s := s.(type) {
+		case Prog:
+			walk(s[0], declared)
+		case Block:
+			walk(s[0], declared)
+		case Seq:
+			walk(s[0], declared)
+			walk(s[1], declared)
+		case Decl:
+			read(s.rhs, declared)
+			declared[s.lhs] = true
+		case Assign:
+			read(s.rhs, declared)
+			// the variable has the kind of the value
+			read(Equal{Var(s.lhs), s.rhs}, declared)
+		case Print:
+			read(s.printExp, declared)
+		case IfThenElse:
+			read(s.cond, declared)
+			nested(s.thenBl)
+			nested(s.elseBl)
+		case While:
+			read(s.cond, declared)
+			nested(s.do)
+		}
+	}
+	walk(stmt, make(map[string]bool))
+	var names []string
+	for x := range free {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+	return names, exps
+}
+
+// returns the formula for a condition
+func (v *verifier) condFormula(e Exp, env kindEnv) (Exp, error) {
+	f, err := v.formula(e, env)
+	if err == nil && !env.always(e, kindBool) {
+		err = fmt.Errorf("%s isn't a condition", e.pretty())
+	}
+	return f, err
+}
+
+// returns a fresh variable for a value a variable of the formulas had
+func (v *verifier) freshVar(x Var) Var {
+	v.fresh++
+	name := strings.SplitN(string(x), "#", 2)[0]
+	f := Var(fmt.Sprintf("%s#%d", name, v.fresh))
+	v.kinds[string(f)] = v.kinds[name]
+	return f
+}
+
+// returns the strongest postcondition of an assignment of a variable of the formulas
+func (v *verifier) assign(pre Exp, x Var, rhs Exp) Exp {
+	old := v.freshVar(x)
+	return And{substitute(pre, x, old), Equal{x, substitute(rhs, x, old)}}
+}
+
+// returns the strongest postcondition of a statement at a path for a precondition, env holds the kinds of the
+// program variables before the statement
+func (v *verifier) sp(pre Exp, s Stmt, p Path, env kindEnv) (Exp, error) {
+	fail := func(err error) (Exp, error) {
+		return nil, v.errorAt(p, err)
+	}
+	// a variable of another kind than the one a declaration gives can't be read anymore in the scope
+	forget := func(f Exp, x string, k Kind) Exp {
+		if old, ok := staticKind(env, Var(x)); ok && old != Undefined && old != k {
+			return substitute(f, v.variable(x, old), v.freshVar(v.variable(x, old)))
+		}
+		return f
+	}
+	var post Exp
+	switch s := s.(type) {
+	case Prog:
+		return v.sp(pre, s[0], p.child(0), env)
+	case Block:
+		return v.sp(pre, s[0], p.child(0), env)
+	case Seq:
+		mid, err := v.sp(pre, s[0], p.child(0), env)
+		if err != nil {
+			return nil, err
+		}
+		return v.sp(mid, s[1], p.child(1), kindsAfter(s[0], env))
+	case Print:
+		post = pre
+	case Decl:
+		k, ok := staticKind(env, s.rhs)
+		if !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.rhs.pretty()))
+		}
+		post = pre
+		if k != Undefined {
+			rhs, err := v.formula(s.rhs, env)
+			if err != nil {
+				return fail(err)
+			}
+			post = v.assign(pre, v.variable(s.lhs, k), rhs)
+		}
+		post = forget(post, s.lhs, k)
+	case Assign:
+		k, ok := staticKind(env, s.rhs)
+		if !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.rhs.pretty()))
+		}
+		if old, _ := staticKind(env, Var(s.lhs)); k != old || k == Undefined {
+			post = pre
+			break
+		}
+		rhs, err := v.formula(s.rhs, env)
+		if err != nil {
+			return fail(err)
+		}
+		post = v.assign(pre, v.variable(s.lhs, k), rhs)
+	case IfThenElse:
+		if k, ok := staticKind(env, s.cond); !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.cond.pretty()))
+		} else if k != ValueBool {
+			post = pre
+			break
+		}
+		cond, err := v.formula(s.cond, env)
+		if err != nil {
+			return fail(err)
+		}
+		pt, err := v.spNested(And{pre, cond}, s.thenBl, p.child(1), env)
+		if err != nil {
+			return nil, err
+		}
+		pe, err := v.spNested(And{pre, Negation{cond}}, s.elseBl, p.child(2), env)
+		if err != nil {
+			return nil, err
+		}
+		post = Or{pt, pe}
+	case While:
+		if k, ok := staticKind(env, s.cond); !ok {
+			return fail(fmt.Errorf("the kind of %s depends on the values of its variables", s.cond.pretty()))
+		} else if k != ValueBool {
+			post = pre
+			break
+		}
+		cond, err := v.formula(s.cond, env)
+		if err != nil {
+			return fail(err)
+		}
+		inv, ok := v.invariants[p.String()]
+		if !ok {
+			return fail(fmt.Errorf("%s has no invariant", headline(s)))
+		}
+		post = And{inv, Negation{cond}}
+	}
+	return optimizeExp(post, v.kinds), nil
+}
+
+// returns the strongest postcondition of a block with a nested scope
+// a variable of the enclosing scope that has another kind at the end of the block is saved in a fresh variable
+// before the block and restored after it, and the variables of the block that ValState.update drops are forgotten
+func (v *verifier) spNested(pre Exp, b Block, p Path, env kindEnv) (Exp, error) {
+	after := kindsAfter(b[0], env)
+	saved := make(map[Var]Var)
+	for x, ks := range env {
+		k, ok := staticKind(env, Var(x))
+		if !ok || k == Undefined || after[x] == ks {
+			continue
+		}
+		outer := v.variable(x, k)
+		s := v.freshVar(outer)
+		saved[outer] = s
+		pre = And{pre, Equal{s, outer}}
+	}
+	post, err := v.sp(pre, b, p, env.copy())
+	if err != nil {
+		return nil, err
+	}
+	for x, ks := range after {
+		k, ok := staticKind(after, Var(x))
+		if !ok || k == Undefined || env[x] == ks {
+			continue
+		}
+		inner := v.variable(x, k)
+		post = substitute(post, inner, v.freshVar(inner))
+	}
+	for outer, s := range saved {
+		post = v.assign(post, outer, s)
+	}
+	return post, nil
+}
+
+// returns the conjuncts of a formula
+func conjuncts(e Exp) []Exp {
+	switch e := e.(type) {
+	case And:
+		return append(conjuncts(e[0]), conjuncts(e[1])...)
+	case Group:
+		return conjuncts(e[0])
+	}
+	return []Exp{e}
+}
+
+// returns the conjunction of formulas
+func conjoin(es []Exp) Exp {
+	if len(es) == 0 {
+		return Bool(true)
+	}
+	c := es[0]
+	for _, e := range es[1:] {
+		c = And{c, e}
+	}
+	return c
+}
+
+func isFresh(x Var) bool {
+	return strings.Contains(string(x), "#")
+}
+
+func mentions(e Exp, x Var) bool {
+	found := false
+	walkPre(e, func(n Node) bool {
+		found = found || n == x
+		return !found
+	})
+	return found
+}
+
+// simplifies a formula: fresh variables equal to an expression are replaced by it (they are existentially
+// quantified), conjuncts that occur twice are dropped, and a formula the solver finds valid or unsatisfiable
+// becomes true or false
+func (v *verifier) simplifyFormula(e Exp) Exp {
+	e = optimizeExp(e, v.kinds)
+	if d, ok := e.(Or); ok {
+		return optimizeExp(Or{v.simplifyFormula(d[0]), v.simplifyFormula(d[1])}, v.kinds)
+	}
+	for changed := true; changed; {
+		changed = false
+		cs := conjuncts(e)
+		for i, c := range cs {
+			eq, ok := c.(Equal)
+			if !ok {
+				continue
+			}
+			for j := 0; j < 2 && !changed; j++ {
+				x, ok := eq[j].(Var)
+				if ok && isFresh(x) && !mentions(eq[1-j], x) {
+					rest := conjoin(append(append([]Exp{}, cs[:i]...), cs[i+1:]...))
+					e = optimizeExp(substitute(rest, x, eq[1-j]), v.kinds)
+					changed = true
+				}
+			}
+			if changed {
+				break
+			}
+		}
+	}
+	var kept []Exp
+	seen := make(map[string]bool)
+	for _, c := range conjuncts(e) {
+		if key := c.pretty(); !seen[key] {
+			seen[key] = true
+			kept = append(kept, c)
+		}
+	}
+	e = conjoin(kept)
+	if res, _ := solve([]Exp{Negation{e}}, v.kinds); res == unsat {
+		return Bool(true)
+	}
+	if res, _ := solve([]Exp{e}, v.kinds); res == unsat {
+		return Bool(false)
+	}
+	return e
+}
+
+// renames the variables of a formula to program variables: a variable of the formulas to the name of its
+// program variable, a fresh one to that name followed by a number, such that no variable of the statement or
+// the condition has it
+func (v *verifier) names(e Exp, stmt Stmt, cond Exp) Exp {
+	taken := make(map[string]bool)
+	for _, n := range []Node{stmt, cond} {
+		walkPre(n, func(n Node) bool {
+			switch n := n.(type) {
+			case Var:
+				taken[string(n)] = true
+			case Decl:
+				taken[n.lhs] = true
+			case Assign:
+				taken[n.lhs] = true
+			}
+			return true
+		})
+	}
+	names := make(map[Var]Var)
+	used := make(map[Var]bool)
+	var fresh []Var
+	walkPre(e, func(n Node) bool {
+		if x, ok := n.(Var); ok {
+			if _, ok := names[x]; ok {
+				return true
+			}
+			name := Var(string(x)[:strings.LastIndex(string(x), ":")])
+			if isFresh(x) || used[name] {
+				// a program variable can only have one kind in a formula found by WP or SP, but another one
+				// wouldn't capture it either
+				names[x] = ""
+				fresh = append(fresh, x)
+			} else {
+				names[x] = name
+				used[name] = true
+			}
+		}
+		return true
+	})
+	for _, x := range fresh {
+		base := string(x)[:strings.LastIndex(string(x), ":")]
+		for i := 0; ; i++ {
+			name := fmt.Sprintf("%s_%d", base, i)
+			if !taken[name] {
+				taken[name] = true
+				names[x] = Var(name)
+				break
+			}
+		}
+	}
+	return rewriteExps(e, func(e Exp) Exp {
+		if x, ok := e.(Var); ok {
+			return names[x]
+		}
+		return e
+	}).(Exp)
+}
+
+// returns the invariants of the loops of the statement at a path of a program, given by the line or the path of
+// their loop in the program; the invariants have the paths of the loops in the statement
+func invariantsAt(prg Prog, at Path, srcs map[string]string) ([]Invariant, error) {
+	lines := lineNumbers(prg)
+	var invariants []Invariant
+	found := make(map[string]bool)
+	walkPaths(prg, func(n Node, p Path) bool {
+		if _, ok := n.(While); !ok {
+			return true
+		}
+		for _, key := range []string{p.String(), strconv.Itoa(lineOf(lines, p))} {
+			if src, ok := srcs[key]; ok && !found[key] {
+				found[key] = true
+				inv, err := parseExp(src)
+				if err != nil {
+					inv = nil
+				}
+				if p.within(at) {
+					invariants = append(invariants, Invariant{p[len(at):], inv})
+				}
+			}
+		}
+		return true
+	})
+	var keys []string
+	for key := range srcs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !found[key] {
+			return nil, fmt.Errorf("invariant %s: there is no while loop at %s", srcs[key], key)
+		}
+		if _, err := parseExp(srcs[key]); err != nil {
+			return nil, fmt.Errorf("invariant %q: %s", srcs[key], err)
+		}
+	}
+	return invariants, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func mustParseExp(t *testing.T, src string) Exp {
+	e, err := parseExp(src)
+	if err != nil {
+		t.Fatalf("%q: %s", src, err)
+	}
+	return e
+}
+
+func TestWPSP(t *testing.T) {
+	inc := assignment("x", plus(variable("x"), number(1)))
+	tests := []struct {
+		stmt   Stmt
+		cond   string
+		wp, sp string
+	}{
+		{inc, "0<x", "(0<(x+1))", "((0<x_0) && (x==(x_0+1)))"},
+		{declaration("y", variable("x")), "x<y", "false", "((x<y_0) && (y==x))"},
+		{ifthenelse(lesser(variable("x"), number(0)), block(assignment("x", number(0))), block(sPrint(variable("x")))),
+			"0<x", "((!(x<0)) && ((x<0) || (0<x)))", "((0<x) && (!(x<0)))"},
+		// the block declares another variable x, the one outside keeps its value
+		{block(declaration("x", boolean(true))), "0<x", "(0<x)", "(0<x)"},
+		// an assignment of the wrong kind fails
+		{assignment("x", boolean(true)), "0<x", "(0<x)", "(0<x)"},
+	}
+	for _, test := range tests {
+		cond := mustParseExp(t, test.cond)
+		if pre, err := WP(test.stmt, cond); err != nil {
+			t.Errorf("WP of %s: %s", headline(test.stmt), err)
+		} else if pre.pretty() != test.wp {
+			t.Errorf("WP of %s for %s: %s instead of %s", headline(test.stmt), test.cond, pre.pretty(), test.wp)
+		}
+		if post, err := SP(cond, test.stmt); err != nil {
+			t.Errorf("SP of %s: %s", headline(test.stmt), err)
+		} else if post.pretty() != test.sp {
+			t.Errorf("SP of %s for %s: %s instead of %s", headline(test.stmt), test.cond, post.pretty(), test.sp)
+		}
+	}
+}
+
+// the fresh variable for the old value of x gets a name no variable of the statement or the condition has
+func TestSPFreshNames(t *testing.T) {
+	stmt := generateSeq([]Stmt{
+		assignment("x", plus(variable("x"), variable("x_1"))),
+		assignment("x", plus(variable("x"), number(1)))})
+	post, err := SP(mustParseExp(t, "x_0<x"), stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "((x_0<x_2) && (x==((x_2+x_1)+1)))"; post.pretty() != want {
+		t.Errorf("%s instead of %s", post.pretty(), want)
+	}
+}
+
+// loops with the same code are told apart by their paths
+func TestWPInvariantsOfEqualLoops(t *testing.T) {
+	loop := func() Stmt {
+		return while(lesser(variable("x"), number(3)), block(assignment("x", plus(variable("x"), number(1)))))
+	}
+	prg := generateProg([]Stmt{declaration("x", number(0)), loop(), loop()})
+	first, _ := parsePath("0.0.1.0")
+	second, _ := parsePath("0.0.1.1")
+	pre, err := WP(prg, mustParseExp(t, "x==3"),
+		Invariant{first, mustParseExp(t, "x==0 || 0<x")}, Invariant{second, mustParseExp(t, "x==3")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre.pretty() != "true" {
+		t.Errorf("%s instead of true", pre.pretty())
+	}
+}
+
+func TestWPSPErrors(t *testing.T) {
+	loop := while(lesser(variable("x"), number(3)), block(assignment("x", plus(variable("x"), number(1)))))
+	noLoop, _ := parsePath("0")
+	tests := []struct {
+		stmt       Stmt
+		invariants []Invariant
+	}{
+		// b := ((x<0) && 5)
+		{declaration("b", and(group(lesser(variable("x"), number(0))), number(5))), nil},
+		{loop, nil},
+		{loop, []Invariant{{Path{}, mustParseExp(t, "0<x")}, {noLoop, mustParseExp(t, "0<x")}}},
+		{loop, []Invariant{{Path{}, mustParseExp(t, "x+1")}}},
+	}
+	for _, test := range tests {
+		if _, err := WP(test.stmt, Bool(true), test.invariants...); err == nil {
+			t.Errorf("WP of %s: no error", headline(test.stmt))
+		}
+		if _, err := SP(Bool(true), test.stmt, test.invariants...); err == nil {
+			t.Errorf("SP of %s: no error", headline(test.stmt))
+		}
+	}
+}